@@ -0,0 +1,375 @@
+package portwarden
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	zxcvbn "github.com/nbutton23/zxcvbn-go"
+)
+
+const (
+	AuditFormatTable = "table"
+	AuditFormatJSON  = "json"
+
+	// DefaultAuditWeakScore flags passwords zxcvbn scores below 3 of 4, the
+	// ones it doesn't consider "safely unguessable".
+	DefaultAuditWeakScore = 3
+	DefaultAuditMaxAge    = 365
+
+	ErrUnknownAuditFormat = "unknown audit format"
+)
+
+// AuditOptions configures Audit.
+type AuditOptions struct {
+	// WeakScore is the zxcvbn score (0 to 4) a password needs to not be weak,
+	// DefaultAuditWeakScore if nil. 0 flags no password as weak.
+	WeakScore *int
+	// MaxAgeDays flags passwords that haven't changed for longer
+	MaxAgeDays int
+	// TOTPSites lists the domains that support TOTP, defaults to a built-in
+	// list of popular sites
+	TOTPSites []string
+	// PwnedDB, if set, is checked for breached passwords
+	PwnedDB *PwnedDB
+	Now     time.Time
+}
+
+// AuditItem names an item of the audited backup.
+type AuditItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type AuditWeakPassword struct {
+	AuditItem
+	Score     int    `json:"score"`
+	CrackTime string `json:"crackTime"`
+}
+
+// AuditReusedPassword is a password that several items share. Items use it
+// now, History have used it before.
+type AuditReusedPassword struct {
+	Items   []AuditItem `json:"items"`
+	History []AuditItem `json:"history"`
+}
+
+type AuditStalePassword struct {
+	AuditItem
+	ChangedDate string `json:"changedDate"`
+	AgeDays     int    `json:"ageDays"`
+}
+
+// AuditBreachedPassword is a password found Count times in the Have I Been
+// Pwned dataset, either the current one or, with History, an old one.
+type AuditBreachedPassword struct {
+	AuditItem
+	Count   int64 `json:"count"`
+	History bool  `json:"history"`
+}
+
+type AuditMissingTOTP struct {
+	AuditItem
+	Domain string `json:"domain"`
+}
+
+// AuditReport is the result of Audit. It names items but never contains
+// their passwords.
+type AuditReport struct {
+	Logins      int                   `json:"logins"`
+	Weak        []AuditWeakPassword   `json:"weak"`
+	Reused      []AuditReusedPassword `json:"reused"`
+	Stale       []AuditStalePassword  `json:"stale"`
+	MissingTOTP []AuditMissingTOTP    `json:"missingTotp"`
+	// Breached is nil unless AuditOptions.PwnedDB is set
+	Breached []AuditBreachedPassword `json:"breached"`
+}
+
+// Audit checks the logins of b for weak, reused and stale passwords and for
+// sites that support TOTP without a TOTP seed in the item.
+func Audit(b *Backup, opts AuditOptions) (*AuditReport, error) {
+	weakScore := DefaultAuditWeakScore
+	if opts.WeakScore != nil {
+		weakScore = *opts.WeakScore
+	}
+	if opts.MaxAgeDays == 0 {
+		opts.MaxAgeDays = DefaultAuditMaxAge
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if opts.TOTPSites == nil {
+		opts.TOTPSites = DefaultTOTPSites
+	}
+	totpSites := make(map[string]bool)
+	for _, site := range opts.TOTPSites {
+		totpSites[strings.ToLower(site)] = true
+	}
+
+	// passwords are compared by a keyed hash, so that the report never needs
+	// to hold on to them
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	hash := func(password string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(password))
+		return string(mac.Sum(nil))
+	}
+	current := make(map[string][]AuditItem)
+	history := make(map[string][]AuditItem)
+	var order []string
+
+	r := &AuditReport{
+		Weak:        []AuditWeakPassword{},
+		Reused:      []AuditReusedPassword{},
+		Stale:       []AuditStalePassword{},
+		MissingTOTP: []AuditMissingTOTP{},
+	}
+	if opts.PwnedDB != nil {
+		r.Breached = []AuditBreachedPassword{}
+	}
+	for _, item := range b.Items {
+		if item.Login == nil {
+			continue
+		}
+		password := stringValue(item.Login.Password)
+		if len(password) == 0 {
+			continue
+		}
+		r.Logins++
+		ai := AuditItem{ID: item.ID, Name: item.Name}
+		var hosts []string
+		for _, uri := range item.Login.Uris {
+			if u, err := url.Parse(uri.URI); err == nil && len(u.Hostname()) > 0 {
+				hosts = append(hosts, strings.ToLower(u.Hostname()))
+			}
+		}
+
+		strength := zxcvbn.PasswordStrength(password, append([]string{item.Name, stringValue(item.Login.Username)}, hosts...))
+		if strength.Score < weakScore {
+			r.Weak = append(r.Weak, AuditWeakPassword{AuditItem: ai, Score: strength.Score, CrackTime: strength.CrackTimeDisplay})
+		}
+
+		h := hash(password)
+		if _, ok := current[h]; !ok {
+			order = append(order, h)
+		}
+		current[h] = append(current[h], ai)
+		seen := map[string]bool{h: true}
+		for _, ph := range item.PasswordHistory {
+			if old := hash(ph.Password); !seen[old] {
+				seen[old] = true
+				history[old] = append(history[old], ai)
+			}
+		}
+
+		// Bitwarden leaves passwordRevisionDate empty until the password
+		// changes, so the last change of the item is the best guess then
+		changed := stringValue(item.Login.PasswordRevisionDate)
+		if len(changed) == 0 {
+			changed = item.RevisionDate
+		}
+		if t, err := time.Parse(time.RFC3339, changed); err == nil {
+			if age := int(opts.Now.Sub(t).Hours() / 24); age > opts.MaxAgeDays {
+				r.Stale = append(r.Stale, AuditStalePassword{AuditItem: ai, ChangedDate: t.Format("2006-01-02"), AgeDays: age})
+			}
+		}
+
+		if opts.PwnedDB != nil {
+			if err := auditBreached(r, opts.PwnedDB, ai, item, password); err != nil {
+				return nil, err
+			}
+		}
+
+		if item.Login.Totp == nil || len(*item.Login.Totp) == 0 {
+			for _, host := range hosts {
+				if domain := matchDomain(host, totpSites); len(domain) > 0 {
+					r.MissingTOTP = append(r.MissingTOTP, AuditMissingTOTP{AuditItem: ai, Domain: domain})
+					break
+				}
+			}
+		}
+	}
+
+	for _, h := range order {
+		items := current[h]
+		if len(items) > 1 || len(history[h]) > 0 {
+			r.Reused = append(r.Reused, AuditReusedPassword{Items: items, History: append([]AuditItem{}, history[h]...)})
+		}
+	}
+	sort.SliceStable(r.Weak, func(i, j int) bool { return r.Weak[i].Score < r.Weak[j].Score })
+	sort.SliceStable(r.Stale, func(i, j int) bool { return r.Stale[i].AgeDays > r.Stale[j].AgeDays })
+	sort.SliceStable(r.Breached, func(i, j int) bool { return r.Breached[i].Count > r.Breached[j].Count })
+	return r, nil
+}
+
+func auditBreached(r *AuditReport, db *PwnedDB, ai AuditItem, item PortWardenElement, password string) error {
+	count, err := db.Count(password)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		r.Breached = append(r.Breached, AuditBreachedPassword{AuditItem: ai, Count: count})
+	}
+	checked := map[string]bool{password: true}
+	for _, ph := range item.PasswordHistory {
+		if checked[ph.Password] {
+			continue
+		}
+		checked[ph.Password] = true
+		count, err := db.Count(ph.Password)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			r.Breached = append(r.Breached, AuditBreachedPassword{AuditItem: ai, Count: count, History: true})
+		}
+	}
+	return nil
+}
+
+// matchDomain returns the domain of sites that host belongs to, e.g.
+// google.com for accounts.google.com.
+func matchDomain(host string, sites map[string]bool) string {
+	host = strings.TrimPrefix(host, "www.")
+	for {
+		if sites[host] {
+			return host
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			return ""
+		}
+		host = host[i+1:]
+	}
+}
+
+// ReadTOTPSites reads the domains that support TOTP from the totp.json of
+// https://2fa.directory (api/v3), or from a text file with one domain per
+// line.
+func ReadTOTPSites(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	var sites []string
+	if first[0] != '[' {
+		scanner := bufio.NewScanner(br)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); len(line) > 0 && !strings.HasPrefix(line, "#") {
+				sites = append(sites, line)
+			}
+		}
+		return sites, scanner.Err()
+	}
+	var entries [][]json.RawMessage
+	if err := json.NewDecoder(br).Decode(&entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if len(entry) < 2 {
+			continue
+		}
+		var site struct {
+			Domain            string   `json:"domain"`
+			AdditionalDomains []string `json:"additional-domains"`
+		}
+		if err := json.Unmarshal(entry[1], &site); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site.Domain)
+		sites = append(sites, site.AdditionalDomains...)
+	}
+	return sites, nil
+}
+
+// WriteAuditReport writes r as tables for the console or as JSON.
+func WriteAuditReport(r *AuditReport, w io.Writer, format string) error {
+	switch format {
+	case AuditFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case AuditFormatTable:
+	default:
+		return errors.New(ErrUnknownAuditFormat + ": " + format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	section := func(title string, count int, header string) {
+		fmt.Fprintf(tw, "\n%v (%v)\n", title, count)
+		if count > 0 {
+			fmt.Fprintln(tw, header)
+		}
+	}
+	fmt.Fprintf(tw, "Audited %v logins with passwords\n", r.Logins)
+
+	section("Weak passwords", len(r.Weak), "NAME\tID\tSCORE\tCRACK TIME")
+	for _, weak := range r.Weak {
+		fmt.Fprintf(tw, "%v\t%v\t%v/4\t%v\n", weak.Name, weak.ID, weak.Score, weak.CrackTime)
+	}
+
+	section("Reused passwords", len(r.Reused), "GROUP\tNAME\tID\tUSED")
+	for i, reused := range r.Reused {
+		for _, item := range reused.Items {
+			fmt.Fprintf(tw, "%v\t%v\t%v\tnow\n", i+1, item.Name, item.ID)
+		}
+		for _, item := range reused.History {
+			fmt.Fprintf(tw, "%v\t%v\t%v\tbefore\n", i+1, item.Name, item.ID)
+		}
+	}
+
+	section("Stale passwords", len(r.Stale), "NAME\tID\tCHANGED\tAGE (DAYS)")
+	for _, stale := range r.Stale {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", stale.Name, stale.ID, stale.ChangedDate, stale.AgeDays)
+	}
+
+	section("Logins without TOTP on sites that support it", len(r.MissingTOTP), "NAME\tID\tSITE")
+	for _, missing := range r.MissingTOTP {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", missing.Name, missing.ID, missing.Domain)
+	}
+
+	if r.Breached != nil {
+		section("Breached passwords", len(r.Breached), "NAME\tID\tTIMES SEEN\tUSED")
+		for _, breached := range r.Breached {
+			used := "now"
+			if breached.History {
+				used = "before"
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", breached.Name, breached.ID, breached.Count, used)
+		}
+	}
+	return tw.Flush()
+}
+
+// DefaultTOTPSites are popular sites that support TOTP, after
+// https://2fa.directory. Use ReadTOTPSites for the full list.
+var DefaultTOTPSites = []string{
+	"1password.com", "adobe.com", "airbnb.com", "amazon.com", "amazon.co.uk", "amazon.de",
+	"apple.com", "atlassian.com", "atlassian.net", "autodesk.com", "aws.amazon.com", "azure.com",
+	"binance.com", "bitbucket.org", "bitwarden.com", "box.com", "cloudflare.com", "coinbase.com",
+	"digitalocean.com", "discord.com", "docker.com", "dropbox.com", "ebay.com", "epicgames.com",
+	"evernote.com", "facebook.com", "fastmail.com", "figma.com", "gandi.net", "github.com",
+	"gitlab.com", "godaddy.com", "google.com", "gusto.com", "heroku.com", "hetzner.com",
+	"hubspot.com", "instagram.com", "kraken.com", "lastpass.com", "linkedin.com", "linode.com",
+	"live.com", "mailchimp.com", "microsoft.com", "mozilla.org", "namecheap.com", "netlify.com",
+	"notion.so", "npmjs.com", "nintendo.com", "office.com", "okta.com", "outlook.com", "ovh.com",
+	"paypal.com", "pinterest.com", "playstation.com", "protonmail.com", "proton.me", "pypi.org",
+	"quickbooks.intuit.com", "reddit.com", "robinhood.com", "salesforce.com", "sentry.io",
+	"shopify.com", "slack.com", "snapchat.com", "stripe.com", "tiktok.com", "tumblr.com",
+	"twitch.tv", "twitter.com", "x.com", "ubisoft.com", "vercel.com", "wordpress.com", "xero.com",
+	"yahoo.com", "zoho.com", "zoom.us",
+}
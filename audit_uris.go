@@ -0,0 +1,355 @@
+package portwarden
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Bitwarden's URI match detection modes. A URI without one uses the default
+// of the account, normally URIMatchDomain.
+const (
+	URIMatchDomain = iota
+	URIMatchHost
+	URIMatchStartsWith
+	URIMatchExact
+	URIMatchRegularExpression
+	URIMatchNever
+)
+
+var uriMatchNames = []string{"domain", "host", "starts with", "exact", "regular expression", "never"}
+
+// SharedHosts are sites that host pages of many different owners under one
+// domain, by path or by subdomain, where matching by domain fills the login
+// of one of them in on every other.
+var SharedHosts = []string{
+	"sites.google.com", "script.google.com", "sharepoint.com", "notion.site", "wixsite.com",
+	"wordpress.com", "weebly.com", "squarespace.com", "webflow.io", "carrd.co",
+	"azurewebsites.net", "cloudfront.net", "amazonaws.com", "appspot.com", "firebaseapp.com",
+	"web.app", "herokuapp.com", "netlify.app", "vercel.app", "pages.dev", "workers.dev",
+	"glitch.me", "repl.co", "ngrok.io", "ngrok-free.app",
+}
+
+// AuditURI is a login URI with a problem. Credentials embedded in the URI are
+// replaced with <hidden>.
+type AuditURI struct {
+	AuditItem
+	URI    string `json:"uri"`
+	Match  string `json:"match"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditSharedDomain is a registrable domain that logins with different
+// passwords share, e.g. several accounts or a password changed in only one
+// of the copies.
+type AuditSharedDomain struct {
+	Domain    string      `json:"domain"`
+	Items     []AuditItem `json:"items"`
+	Passwords int         `json:"passwords"`
+}
+
+// URIAuditReport is the result of AuditURIs, by category.
+type URIAuditReport struct {
+	URIs         int                 `json:"uris"`
+	Insecure     []AuditURI          `json:"insecure"`
+	IPAddresses  []AuditURI          `json:"ipAddresses"`
+	Credentials  []AuditURI          `json:"credentials"`
+	Unparseable  []AuditURI          `json:"unparseable"`
+	RiskyMatch   []AuditURI          `json:"riskyMatch"`
+	SharedDomain []AuditSharedDomain `json:"sharedDomain"`
+}
+
+// AuditURIs checks the login URIs of b for plain http, IP addresses,
+// embedded credentials, URIs that can't be parsed and match modes likely to
+// fill a login in on the wrong site. It also lists the registrable domains
+// shared by logins with different passwords.
+func AuditURIs(b *Backup) (*URIAuditReport, error) {
+	r := &URIAuditReport{
+		Insecure:     []AuditURI{},
+		IPAddresses:  []AuditURI{},
+		Credentials:  []AuditURI{},
+		Unparseable:  []AuditURI{},
+		RiskyMatch:   []AuditURI{},
+		SharedDomain: []AuditSharedDomain{},
+	}
+	sharedHosts := make(map[string]bool)
+	for _, host := range SharedHosts {
+		sharedHosts[host] = true
+	}
+
+	// passwords are compared by a keyed hash, as in Audit
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	hash := func(password string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(password))
+		return string(mac.Sum(nil))
+	}
+	domainItems := make(map[string][]AuditItem)
+	domainPasswords := make(map[string]map[string]bool)
+	var domains []string
+
+	for _, item := range b.Items {
+		if item.Login == nil {
+			continue
+		}
+		ai := AuditItem{ID: item.ID, Name: item.Name}
+		itemDomains := make(map[string]bool)
+		for _, uri := range item.Login.Uris {
+			if len(strings.TrimSpace(uri.URI)) == 0 {
+				continue
+			}
+			r.URIs++
+			match, hasMatch := uriMatch(uri.Match)
+			au := AuditURI{AuditItem: ai, URI: uri.URI, Match: uriMatchName(match, hasMatch)}
+			if match == URIMatchNever {
+				continue
+			}
+			if match == URIMatchRegularExpression {
+				if reason := riskyRegex(uri.URI); len(reason) > 0 {
+					au.Reason = reason
+					r.RiskyMatch = append(r.RiskyMatch, au)
+				}
+				continue
+			}
+
+			// Bitwarden reads URIs without a scheme as hosts
+			raw := strings.TrimSpace(uri.URI)
+			explicit := strings.Contains(raw, "://")
+			if !explicit {
+				raw = "http://" + raw
+			}
+			u, err := url.Parse(raw)
+			if err != nil || len(u.Hostname()) == 0 {
+				au.URI = redactRawURI(uri.URI)
+				au.Reason = "no host"
+				// url.Error repeats the URI, which may hold credentials
+				if ue, ok := err.(*url.Error); ok {
+					au.Reason = ue.Err.Error()
+				}
+				r.Unparseable = append(r.Unparseable, au)
+				continue
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				// app ids of the mobile apps and the like aren't sites
+				continue
+			}
+			if u.User != nil {
+				au.URI = redactURI(u)
+				r.Credentials = append(r.Credentials, au)
+			}
+			host := strings.ToLower(u.Hostname())
+			if explicit && strings.EqualFold(u.Scheme, "http") && !isLocalHost(host) {
+				r.Insecure = append(r.Insecure, au)
+			}
+			if net.ParseIP(host) != nil {
+				r.IPAddresses = append(r.IPAddresses, au)
+			}
+
+			if reason := riskyMatch(u, match, sharedHosts); len(reason) > 0 {
+				au.Reason = reason
+				r.RiskyMatch = append(r.RiskyMatch, au)
+			}
+
+			if domain := RegistrableDomain(uri.URI); len(domain) > 0 && !itemDomains[domain] {
+				itemDomains[domain] = true
+				if _, ok := domainPasswords[domain]; !ok {
+					domains = append(domains, domain)
+					domainPasswords[domain] = make(map[string]bool)
+				}
+				domainItems[domain] = append(domainItems[domain], ai)
+				domainPasswords[domain][hash(stringValue(item.Login.Password))] = true
+			}
+		}
+	}
+
+	for _, domain := range domains {
+		if passwords := len(domainPasswords[domain]); passwords > 1 {
+			r.SharedDomain = append(r.SharedDomain, AuditSharedDomain{Domain: domain, Items: domainItems[domain], Passwords: passwords})
+		}
+	}
+	sort.SliceStable(r.SharedDomain, func(i, j int) bool { return len(r.SharedDomain[i].Items) > len(r.SharedDomain[j].Items) })
+	return r, nil
+}
+
+// uriMatch reads the match mode of a URI, which JSON decodes as a float64.
+func uriMatch(v interface{}) (int, bool) {
+	if f, ok := v.(float64); ok {
+		return int(f), true
+	}
+	return URIMatchDomain, false
+}
+
+func uriMatchName(match int, set bool) string {
+	if !set {
+		return "default"
+	}
+	if match >= 0 && match < len(uriMatchNames) {
+		return uriMatchNames[match]
+	}
+	return fmt.Sprint(match)
+}
+
+// riskyMatch tells why matching u with match may fill a login in on another
+// site than intended, or "" if it looks fine.
+func riskyMatch(u *url.URL, match int, sharedHosts map[string]bool) string {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	tenantPath := len(strings.Trim(u.Path, "/")) > 0
+	switch {
+	case match == URIMatchStartsWith && len(u.Path) == 0 && len(u.RawQuery) == 0 && len(u.Fragment) == 0:
+		// https://example.com is also the start of https://example.com.evil.net
+		return "without a / after the host it also matches hosts that start with it"
+	case sharedHosts[host] && tenantPath && (match == URIMatchDomain || match == URIMatchHost):
+		// the login is for one site of the host, not the host itself
+		return "fills in on every site of the shared host " + host
+	case match == URIMatchDomain:
+		// tenants of shared hosts on the public suffix list already are
+		// domains of their own
+		if shared := matchSharedHost(host, sharedHosts); len(shared) > 0 && RegistrableDomain(host) == RegistrableDomain(shared) {
+			return "fills in on every site under " + shared
+		}
+	}
+	return ""
+}
+
+// matchSharedHost returns the shared host that host is a subdomain of.
+func matchSharedHost(host string, sharedHosts map[string]bool) string {
+	for i := strings.Index(host, "."); i >= 0; i = strings.Index(host, ".") {
+		host = host[i+1:]
+		if sharedHosts[host] {
+			return host
+		}
+	}
+	return ""
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// riskyRegex tells why a regular expression match mode may match other sites
+// than intended, or "" if it looks fine.
+func riskyRegex(expr string) string {
+	if _, err := regexp.Compile(expr); err != nil {
+		return "invalid regular expression: " + err.Error()
+	}
+	if !strings.HasPrefix(expr, "^") {
+		return "not anchored with ^, it matches the pattern anywhere in the URL"
+	}
+	host := strings.TrimPrefix(expr, "^")
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+len("://"):]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	for i := 0; i < len(host); i++ {
+		switch host[i] {
+		case '\\':
+			i++
+		case '[':
+			// a . in a character class is literal
+			if j := strings.IndexByte(host[i:], ']'); j >= 0 {
+				i += j
+			}
+		case '.':
+			return "an unescaped . in the host matches any character"
+		}
+	}
+	return ""
+}
+
+// redactURI hides the password of the credentials in u.
+func redactURI(u *url.URL) string {
+	redacted := *u
+	if _, ok := u.User.Password(); ok {
+		redacted.User = url.UserPassword(u.User.Username(), maskedSecret)
+	}
+	return strings.Replace(redacted.String(), url.QueryEscape(maskedSecret), maskedSecret, 1)
+}
+
+// redactRawURI hides what looks like credentials in a URI that can't be
+// parsed.
+func redactRawURI(uri string) string {
+	at := strings.LastIndex(uri, "@")
+	if at < 0 {
+		return uri
+	}
+	start := strings.Index(uri, "://")
+	if start < 0 || start > at {
+		start = 0
+	} else {
+		start += len("://")
+	}
+	return uri[:start] + maskedSecret + uri[at:]
+}
+
+// WriteURIAuditReport writes r as tables or as JSON.
+func WriteURIAuditReport(r *URIAuditReport, w io.Writer, format string) error {
+	switch format {
+	case AuditFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case AuditFormatTable:
+	default:
+		return errors.New(ErrUnknownAuditFormat + ": " + format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	section := func(title string, uris []AuditURI, reason bool) {
+		fmt.Fprintf(tw, "\n%v (%v)\n", title, len(uris))
+		if len(uris) == 0 {
+			return
+		}
+		if reason {
+			fmt.Fprintln(tw, "NAME\tID\tURI\tMATCH\tREASON")
+		} else {
+			fmt.Fprintln(tw, "NAME\tID\tURI\tMATCH")
+		}
+		for _, u := range uris {
+			if reason {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", u.Name, u.ID, u.URI, u.Match, u.Reason)
+			} else {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", u.Name, u.ID, u.URI, u.Match)
+			}
+		}
+	}
+	fmt.Fprintf(tw, "Audited %v login URIs\n", r.URIs)
+	section("Insecure http:// URIs", r.Insecure, false)
+	section("IP address URIs", r.IPAddresses, false)
+	section("URIs with embedded credentials", r.Credentials, false)
+	section("Unparseable URIs", r.Unparseable, true)
+	section("Match modes that may fill in on the wrong site", r.RiskyMatch, true)
+
+	fmt.Fprintf(tw, "\nDomains shared by logins with different passwords (%v)\n", len(r.SharedDomain))
+	if len(r.SharedDomain) > 0 {
+		fmt.Fprintln(tw, "DOMAIN\tPASSWORDS\tNAME\tID")
+	}
+	for _, shared := range r.SharedDomain {
+		for i, item := range shared.Items {
+			domain, passwords := shared.Domain, fmt.Sprint(shared.Passwords)
+			if i > 0 {
+				domain, passwords = "", ""
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", domain, passwords, item.Name, item.ID)
+		}
+	}
+	return tw.Flush()
+}
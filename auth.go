@@ -0,0 +1,123 @@
+package portwarden
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// BWSessionEnv holds the session key of an unlocked Bitwarden CLI
+	BWSessionEnv = "BW_SESSION"
+	// BWClientIDEnv and BWClientSecretEnv hold a personal API key, which
+	// `bw login --apikey` reads by itself
+	BWClientIDEnv     = "BW_CLIENTID"
+	BWClientSecretEnv = "BW_CLIENTSECRET"
+
+	// bwPasswordEnv hands the master password to `bw` through its
+	// environment rather than its arguments, which ps shows
+	bwPasswordEnv = "PORTWARDEN_BW_PASSWORD"
+
+	ErrLoginNeedsAccount     = "logging in with a master password needs the account email of the profile"
+	ErrEmptyMasterPassword   = "the master password source gave an empty password"
+	ErrIncompleteAPIKey      = "set both " + BWClientIDEnv + " and " + BWClientSecretEnv
+	ErrBadPasswordDescriptor = "the master password file descriptor can't be read"
+)
+
+// HasAPIKey tells whether a personal API key is in the environment. Only
+// one half of it is an error.
+func HasAPIKey() (bool, error) {
+	id, secret := os.Getenv(BWClientIDEnv), os.Getenv(BWClientSecretEnv)
+	if len(id) == 0 && len(secret) == 0 {
+		return false, nil
+	}
+	if len(id) == 0 || len(secret) == 0 {
+		return false, errors.New(ErrIncompleteAPIKey)
+	}
+	return true, nil
+}
+
+// BWLoginWithAPIKey logs the Bitwarden CLI in with the API key of the
+// environment. The vault stays locked, see BWUnlockWithPassword.
+func BWLoginWithAPIKey() error {
+	_, err := bwRunWithPassword("", "login", "--apikey")
+	return err
+}
+
+// BWLoginWithPassword logs email in without prompting and returns the
+// session key. Accounts with two-step login need an API key instead.
+func BWLoginWithPassword(email, password string) (string, error) {
+	stdout, err := bwRunWithPassword(password, "login", email, "--passwordenv", bwPasswordEnv)
+	if err != nil {
+		return "", err
+	}
+	return ExtractSessionKey(string(stdout))
+}
+
+// BWUnlockWithPassword unlocks the vault of the logged in account without
+// prompting and returns the session key.
+func BWUnlockWithPassword(password string) (string, error) {
+	stdout, err := bwRunWithPassword(password, "unlock", "--passwordenv", bwPasswordEnv)
+	if err != nil {
+		return "", err
+	}
+	return ExtractSessionKey(string(stdout))
+}
+
+// BWSessionUnlocked tells whether sessionKey still unlocks the vault, e.g.
+// before a daemon reuses it.
+func BWSessionUnlocked(sessionKey string) bool {
+	_, err := bwRunWithPassword("", "unlock", "--check", "--session", sessionKey)
+	return err == nil
+}
+
+// BWLock locks the vault, which makes sessionKey useless, but stays logged
+// in.
+func BWLock(sessionKey string) error {
+	_, err := bwRunWithPassword("", "lock", "--session", sessionKey)
+	return err
+}
+
+// bwRunWithPassword runs `bw` with --nointeraction, so that it fails rather
+// than waiting for input nobody gives, and password in bwPasswordEnv. The
+// error is what `bw` printed to stderr, e.g. BWErrNotLoggedIn.
+func bwRunWithPassword(password string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("bw", append(args, "--nointeraction")...)
+	cmd.Env = os.Environ()
+	if len(password) > 0 {
+		cmd.Env = append(cmd.Env, bwPasswordEnv+"="+password)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// ReadPasswordFD reads a master password from an inherited file descriptor,
+// as in `portwarden --password-fd 3 encrypt 3< password.txt`. A trailing
+// newline is dropped.
+func ReadPasswordFD(fd int) (string, error) {
+	f := os.NewFile(uintptr(fd), "password")
+	if f == nil {
+		return "", errors.New(ErrBadPasswordDescriptor)
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	password := strings.TrimRight(string(content), "\r\n")
+	if len(password) == 0 {
+		return "", errors.New(ErrEmptyMasterPassword)
+	}
+	return password, nil
+}
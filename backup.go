@@ -0,0 +1,216 @@
+package portwarden
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+const (
+	ErrBackupWithoutItems = "the backup doesn't contain " + ItemsJsonFileName
+)
+
+// Backup is the content of a decrypted .portwarden file, read in memory so
+// that nothing has to be written to disk unencrypted.
+type Backup struct {
+	Items       PortWarden
+	Folders     PortWardenFolder
+	Collections PortWardenCollection
+	Manifest    *Manifest
+	// Files holds every file of the archive by its path relative to the
+	// backup folder, e.g. items.json or attachments/<item>/<id>/<name>
+	Files map[string][]byte
+}
+
+func ReadBackupFile(fileName, passphrase string) (*Backup, error) {
+	rawBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ReadBackupBytes(rawBytes, passphrase)
+}
+
+func ReadBackupBytes(encryptedBytes []byte, passphrase string) (*Backup, error) {
+	zipBytes, err := DecryptBytes(encryptedBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		return nil, err
+	}
+	b := &Backup{Files: make(map[string][]byte)}
+	prefix := strings.TrimPrefix(BackupFolderName, "./")
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		b.Files[strings.TrimPrefix(strings.TrimPrefix(f.Name, "./"), prefix)] = content
+	}
+
+	file, ok := b.Files[ItemsJsonFileName]
+	if !ok {
+		return nil, errors.New(ErrBackupWithoutItems)
+	}
+	if err := json.Unmarshal(file, &b.Items); err != nil {
+		return nil, err
+	}
+	if file, ok := b.Files[FoldersJSONFileName]; ok {
+		if err := json.Unmarshal(file, &b.Folders); err != nil {
+			return nil, err
+		}
+	}
+	if file, ok := b.Files[CollectionsJSONFileName]; ok {
+		if err := json.Unmarshal(file, &b.Collections); err != nil {
+			return nil, err
+		}
+	}
+	if file, ok := b.Files[ManifestJSONFileName]; ok {
+		b.Manifest = &Manifest{}
+		if err := json.Unmarshal(file, b.Manifest); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// WriteBackupFile encrypts b into fileName, adding the .portwarden extension
+// if it's missing.
+func WriteBackupFile(fileName, passphrase string, b *Backup, kdf KDFSettings) error {
+	if !strings.HasSuffix(fileName, ".portwarden") {
+		fileName += ".portwarden"
+	}
+	encryptedBytes, err := WriteBackupBytes(b, passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, encryptedBytes, 0600)
+}
+
+// WriteBackupBytes zips b in the layout CreateBackupBytes produces and
+// encrypts it, so that a backup assembled in memory can be decrypted and
+// restored like any other. The JSON files are written from the fields of b,
+// attachments from b.Files.
+func WriteBackupBytes(b *Backup, passphrase string, kdf KDFSettings) ([]byte, error) {
+	files := map[string]interface{}{
+		ItemsJsonFileName:       b.Items,
+		FoldersJSONFileName:     b.Folders,
+		CollectionsJSONFileName: b.Collections,
+		ManifestJSONFileName:    b.Manifest,
+	}
+	if b.Items == nil {
+		files[ItemsJsonFileName] = PortWarden{}
+	}
+	if b.Folders == nil {
+		files[FoldersJSONFileName] = PortWardenFolder{}
+	}
+	if b.Collections == nil {
+		files[CollectionsJSONFileName] = PortWardenCollection{}
+	}
+	if b.Manifest == nil {
+		files[ManifestJSONFileName] = NewManifest(b.Items)
+	}
+
+	var names []string
+	for name := range b.Files {
+		if _, ok := files[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := &backupZipWriter{Writer: zip.NewWriter(&buf), dirs: make(map[string]bool)}
+	prefix := strings.TrimPrefix(BackupFolderName, "./")
+	for _, name := range []string{FoldersJSONFileName, CollectionsJSONFileName, ItemsJsonFileName, ManifestJSONFileName} {
+		rawByte, err := json.Marshal(files[name])
+		if err != nil {
+			return nil, err
+		}
+		if err := zw.writeFile(prefix+name, pretty.Pretty(rawByte)); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		if err := zw.writeFile(prefix+name, b.Files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return EncryptBytesWithKDF(buf.Bytes(), passphrase, kdf)
+}
+
+// backupZipWriter adds an entry for every directory before its files, as
+// Unzip creates missing parents with the mode of the file.
+type backupZipWriter struct {
+	*zip.Writer
+	dirs map[string]bool
+}
+
+func (zw *backupZipWriter) writeFile(name string, content []byte) error {
+	if err := zw.writeDir(path.Dir(name)); err != nil {
+		return err
+	}
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	fh.SetMode(0644)
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+func (zw *backupZipWriter) writeDir(dir string) error {
+	if dir == "." || dir == "/" || zw.dirs[dir] {
+		return nil
+	}
+	if err := zw.writeDir(path.Dir(dir)); err != nil {
+		return err
+	}
+	zw.dirs[dir] = true
+	fh := &zip.FileHeader{Name: dir + "/"}
+	fh.SetMode(os.ModeDir | 0755)
+	_, err := zw.CreateHeader(fh)
+	return err
+}
+
+// Attachment returns the content of an attachment of item, whichever layout
+// the backup uses.
+func (b *Backup) Attachment(item PortWardenElement, attachment Attachment) ([]byte, bool) {
+	content, ok := b.Files[b.Manifest.AttachmentPath(item, attachment)]
+	return content, ok
+}
+
+// FolderName returns the name of the folder with folderID, or "" for items
+// that aren't in a folder.
+func (b *Backup) FolderName(folderID *string) string {
+	if folderID == nil {
+		return ""
+	}
+	for _, folder := range b.Folders {
+		if folder.ID != nil && *folder.ID == *folderID {
+			return folder.Name
+		}
+	}
+	return ""
+}
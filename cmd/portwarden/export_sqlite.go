@@ -0,0 +1,15 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"github.com/vwxyzjn/portwarden"
+	"github.com/vwxyzjn/portwarden/sqlite"
+)
+
+const sqliteExportFormat = sqlite.ExportFormat
+
+func exportSQLite(b *portwarden.Backup, output string) error {
+	return sqlite.Export(b, output, sqlite.Options{IncludeSecrets: includeSecrets})
+}
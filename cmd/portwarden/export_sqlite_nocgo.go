@@ -0,0 +1,18 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+import (
+	"errors"
+
+	"github.com/vwxyzjn/portwarden"
+)
+
+// The SQLite driver needs cgo, so binaries built with CGO_ENABLED=0 leave the
+// sqlite package out and refuse the format instead.
+const sqliteExportFormat = "sqlite"
+
+func exportSQLite(b *portwarden.Backup, output string) error {
+	return errors.New(ErrSQLiteNeedsCgo)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vwxyzjn/portwarden"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+
+	ErrUnknownOutputFormat = "unknown output format"
+	ErrWatchWithJSON       = "--watch can't be used with --output-format json"
+)
+
+// Error codes of a JSON result, so that scripts can tell failures apart
+// without matching messages
+const (
+	CodeError                 = "error"
+	CodeUsage                 = "usage"
+	CodeConfig                = "config"
+	CodeNotFound              = "not_found"
+	CodePermissionDenied      = "permission_denied"
+	CodeNoPassphrase          = "no_passphrase"
+	CodeWrongPassphrase       = "wrong_passphrase"
+	CodeNotLoggedIn           = "not_logged_in"
+	CodeInvalidMasterPassword = "invalid_master_password"
+	CodeVaultLocked           = "vault_locked"
+	CodeVaultNotEmpty         = "vault_not_empty"
+	CodeVerificationFailed    = "verification_failed"
+	CodeUnknownFormat         = "unknown_format"
+	CodeNoMatch               = "no_match"
+)
+
+// errorCodes maps the messages of the errors scripts may want to handle to
+// their code. Errors are often wrapped, like "backup passphrase: ...", so a
+// message only has to contain one.
+var errorCodes = map[string]string{
+	ErrNoFilenameProvided:                  CodeUsage,
+	ErrNoImportFileProvided:                CodeUsage,
+	ErrCollectionMapWithoutOrganization:    CodeUsage,
+	ErrPwnedIndexArguments:                 CodeUsage,
+	ErrDiffArguments:                       CodeUsage,
+	ErrSearchArguments:                     CodeUsage,
+	ErrSearchAmbiguous:                     CodeUsage,
+	ErrTOTPArguments:                       CodeUsage,
+	ErrPruneArguments:                      CodeUsage,
+	ErrWatchWithJSON:                       CodeUsage,
+	portwarden.ErrPassGPGIDMismatch:        CodeUsage,
+	portwarden.ErrInvalidCollectionMapping: CodeUsage,
+
+	portwarden.ErrUnknownProfile:           CodeConfig,
+	portwarden.ErrNoConfigDir:              CodeConfig,
+	portwarden.ErrAmbiguousSecretSource:    CodeConfig,
+	portwarden.ErrEmptySecretSource:        CodeConfig,
+	portwarden.ErrPassNoKeyring:            CodeConfig,
+	portwarden.ErrKDFIterationsTooLow:      CodeConfig,
+	portwarden.ErrKDFIterationsTooHigh:     CodeConfig,
+	portwarden.ErrBWConfigServerNeedLogout: CodeConfig,
+	portwarden.ErrIncompleteAPIKey:         CodeConfig,
+	portwarden.ErrScheduleFields:           CodeConfig,
+	portwarden.ErrScheduleNeverRuns:        CodeConfig,
+	portwarden.ErrScheduleEvery:            CodeConfig,
+	portwarden.ErrEmptyRetentionPolicy:     CodeConfig,
+	ErrNoScheduledProfiles:                 CodeConfig,
+	ErrDaemonNeedsMasterPassword:           CodeConfig,
+	ErrDaemonNeedsLogin:                    CodeConfig,
+
+	ErrNoPhassPhraseProvided:                  CodeNoPassphrase,
+	portwarden.ErrWrongBackupPassphrase:       CodeWrongPassphrase,
+	portwarden.ErrMessageAuthenticationFailed: CodeWrongPassphrase,
+	portwarden.ErrKDBXInvalidKey:              CodeWrongPassphrase,
+
+	portwarden.BWErrNotLoggedIn:           CodeNotLoggedIn,
+	portwarden.ErrLoginNeedsAccount:       CodeNotLoggedIn,
+	portwarden.BWErrInvalidMasterPassword: CodeInvalidMasterPassword,
+	portwarden.ErrEmptyMasterPassword:     CodeInvalidMasterPassword,
+	portwarden.ErrBadPasswordDescriptor:   CodeInvalidMasterPassword,
+	ErrVaultIsLocked:                      CodeVaultLocked,
+	ErrSessionKeyExtractionFailed:         CodeVaultLocked,
+
+	portwarden.ErrVaultNotEmptyForRestore:   CodeVaultNotEmpty,
+	portwarden.ErrRestoreVerificationFailed: CodeVerificationFailed,
+
+	ErrUnknownOutputFormat:           CodeUnknownFormat,
+	ErrUnknownExportFormat:           CodeUnknownFormat,
+	ErrSQLiteNeedsCgo:                CodeUnknownFormat,
+	ErrUnknownImportFormat:           CodeUnknownFormat,
+	ErrUnknownTOTPFormat:             CodeUnknownFormat,
+	portwarden.ErrUnknownAuditFormat: CodeUnknownFormat,
+	portwarden.ErrUnknownDiffFormat:  CodeUnknownFormat,
+
+	ErrSearchNoMatch:                 CodeNoMatch,
+	ErrTOTPNoMatch:                   CodeNoMatch,
+	portwarden.ErrSearchUnknownField: CodeNoMatch,
+}
+
+// errorCode returns the code of err, that of the longest message it
+// contains, or CodeError for any other error.
+func errorCode(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return CodeNotFound
+	case os.IsPermission(err):
+		return CodePermissionDenied
+	}
+	code, matched := CodeError, 0
+	for message, c := range errorCodes {
+		if len(message) > matched && strings.Contains(err.Error(), message) {
+			code, matched = c, len(message)
+		}
+	}
+	return code
+}
+
+// Result is what a command did. With --output-format json it's the only
+// thing the command prints to stdout, everything else goes to stderr.
+type Result struct {
+	Command string `json:"command"`
+	Profile string `json:"profile,omitempty"`
+	OK      bool   `json:"ok"`
+	// Files are the files and directories written, Deleted those pruned
+	Files           []string        `json:"files,omitempty"`
+	Deleted         []string        `json:"deleted,omitempty"`
+	Counts          map[string]int  `json:"counts,omitempty"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Stages          []*StageResult  `json:"stages,omitempty"`
+	Warnings        []string        `json:"warnings,omitempty"`
+	Report          json.RawMessage `json:"report,omitempty"`
+	Error           *ResultError    `json:"error,omitempty"`
+
+	start time.Time
+}
+
+// StageResult is a stage of a backup or restore, like items, and how many
+// of them there were.
+type StageResult struct {
+	Name            string  `json:"name"`
+	Count           int     `json:"count"`
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	start time.Time
+}
+
+type ResultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var (
+	// result is what the command of this run did so far
+	result = newResult("")
+	// resultOutput is the real stdout once StartOutput redirected os.Stdout
+	// for --output-format json, nil otherwise
+	resultOutput io.Writer
+	// progress shows and records the stages of this run
+	progress *cliProgress
+)
+
+func newResult(command string) *Result {
+	return &Result{Command: command, Counts: map[string]int{}, start: time.Now()}
+}
+
+// finish records err and how long the command took. The stages count
+// what was backed up or restored unless the command counted it itself.
+func (r *Result) finish(err error) {
+	r.DurationSeconds = time.Since(r.start).Seconds()
+	for _, stage := range r.Stages {
+		if _, ok := r.Counts[stage.Name]; !ok {
+			r.Counts[stage.Name] = stage.Count
+		}
+	}
+	r.OK = err == nil
+	if err != nil {
+		r.Error = &ResultError{Code: errorCode(err), Message: err.Error()}
+	}
+}
+
+// StartOutput starts the result of the command of this run. With
+// --output-format json stdout is kept for the result, and what commands and
+// cli print goes to stderr instead. Help stays text.
+func StartOutput(c *cli.Context) error {
+	switch outputFormat {
+	case OutputText, OutputJSON:
+	default:
+		return fmt.Errorf("%v: %q", ErrUnknownOutputFormat, outputFormat)
+	}
+	command := c.Args().First()
+	if cmd := c.App.Command(command); cmd != nil {
+		command = cmd.Name
+	}
+	result = newResult(command)
+	if outputFormat == OutputJSON && !helpRequested(c) {
+		resultOutput = os.Stdout
+		os.Stdout = os.Stderr
+		c.App.Writer = os.Stderr
+	}
+	// the daemon logs rather than redrawing a line between its log lines
+	progress = &cliProgress{terminal: isTerminal(os.Stderr) && command != "daemon"}
+	portwarden.Progress = progress
+	return nil
+}
+
+func jsonOutput() bool {
+	return resultOutput != nil
+}
+
+// FinishOutput ends this run with err, printing the result with
+// --output-format json, and exits with 1 if err isn't nil.
+func FinishOutput(err error) {
+	if progress != nil {
+		progress.end()
+	}
+	if !jsonOutput() {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printResult(err)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// printResult finishes the result with err and prints it on a line of its
+// own, so that the results of a daemon can be read line by line.
+func printResult(err error) {
+	if progress != nil {
+		progress.end()
+	}
+	result.finish(err)
+	if err := json.NewEncoder(resultOutput).Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// wroteFile records a file or directory the command wrote.
+func wroteFile(fileName string) {
+	result.Files = append(result.Files, fileName)
+}
+
+// warn prints a problem that doesn't stop the command to stderr and
+// records it.
+func warn(format string, a ...interface{}) {
+	portwarden.Progress.Warn(fmt.Sprintf(format, a...))
+}
+
+// writeReport writes a report of write in format to stdout, or with
+// --output-format json into the result in jsonFormat.
+func writeReport(format, jsonFormat string, write func(w io.Writer, format string) error) error {
+	if !jsonOutput() {
+		return write(os.Stdout, format)
+	}
+	var b bytes.Buffer
+	if err := write(&b, jsonFormat); err != nil {
+		return err
+	}
+	result.Report = json.RawMessage(b.Bytes())
+	return nil
+}
+
+// setReport puts v into the result as its report.
+func setReport(v interface{}) error {
+	rawBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	result.Report = rawBytes
+	return nil
+}
+
+// cliProgress records the stages of a backup or restore into the result.
+// On a terminal it redraws a line on stderr for the current stage, and
+// otherwise prints a line as each stage starts.
+type cliProgress struct {
+	mu       sync.Mutex
+	terminal bool
+	stage    *StageResult
+	done     int
+	name     string
+	// open tells whether the line of the stage is drawn but not ended yet
+	open bool
+}
+
+func (p *cliProgress) Stage(stage string, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stage == nil || p.stage.Name != stage {
+		p.endLine()
+		p.stage = &StageResult{Name: stage, start: time.Now()}
+		p.done, p.name = 0, ""
+		result.Stages = append(result.Stages, p.stage)
+	}
+	if total < 0 {
+		if p.terminal {
+			fmt.Fprintf(os.Stderr, "\r\033[K%v...", stage)
+			p.open = true
+		}
+		return
+	}
+	p.stage.Count = total
+	if !p.terminal {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", stage, total)
+		return
+	}
+	p.draw()
+}
+
+func (p *cliProgress) Step(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stage == nil {
+		return
+	}
+	p.done++
+	p.name = name
+	if p.terminal {
+		p.draw()
+	}
+}
+
+func (p *cliProgress) Info(message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.println(message)
+}
+
+func (p *cliProgress) Warn(message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result.Warnings = append(result.Warnings, message)
+	p.println(message)
+}
+
+// println prints message on a line of its own above the line of the
+// current stage.
+func (p *cliProgress) println(message string) {
+	if p.open {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	fmt.Fprintln(os.Stderr, message)
+	if p.open {
+		p.draw()
+	}
+}
+
+// draw redraws the line of the current stage, and ends it once the stage
+// is done so that whatever is printed next starts on a line of its own.
+func (p *cliProgress) draw() {
+	line := fmt.Sprintf("%v %v/%v", p.stage.Name, p.done, p.stage.Count)
+	if p.done > 0 && p.done < p.stage.Count {
+		line += "  " + truncate(p.name, 40)
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K%v", line)
+	p.open = true
+	if p.done >= p.stage.Count {
+		p.endLine()
+	}
+}
+
+// endLine ends the current stage, and its line on a terminal, e.g. before
+// the next stage starts or an error is printed.
+func (p *cliProgress) endLine() {
+	if p.stage != nil && p.stage.DurationSeconds == 0 {
+		p.stage.DurationSeconds = time.Since(p.stage.start).Seconds()
+	}
+	if p.open {
+		fmt.Fprintln(os.Stderr)
+		p.open = false
+	}
+}
+
+// end ends the current stage, so that the next one starts afresh even if
+// it has the same name, like in the next backup of a daemon.
+func (p *cliProgress) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endLine()
+	p.stage = nil
+}
+
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-1]) + "…"
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -2,12 +2,24 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
 	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/vwxyzjn/portwarden"
 	cli "gopkg.in/urfave/cli.v1"
@@ -20,16 +32,118 @@ const (
 	ErrNoFilenameProvided         = "no filename provided"
 	ErrSessionKeyExtractionFailed = "session key extraction failed"
 
+	ErrCollectionMapWithoutOrganization = "--collection-map requires --organization-id"
+	ErrUnknownExportFormat              = "unknown export format"
+	ErrSQLiteNeedsCgo                   = "this portwarden was built without cgo, which the sqlite export needs"
+	ErrUnknownImportFormat              = "unknown import format"
+	ErrNoImportFileProvided             = "no file to import provided"
+	ErrUnknownTOTPFormat                = "unknown TOTP export format"
+	ErrPwnedIndexArguments              = "expected the dataset and the index to write"
+	ErrDiffArguments                    = "expected the older and the newer backup"
+	ErrSearchArguments                  = "expected the backup and the query"
+	ErrSearchNoMatch                    = "no item matches"
+	ErrSearchAmbiguous                  = "more than one item matches, refine the query to copy a field"
+	ErrNoClipboard                      = "no clipboard tool found, install xclip, xsel or wl-clipboard, or use --stdout"
+	ErrTOTPArguments                    = "expected the backup and the item to generate a code for"
+	ErrTOTPNoMatch                      = "no item with a TOTP secret matches"
+	ErrNoScheduledProfiles              = "no profile in the config file has a schedule"
+	ErrPruneArguments                   = "expected the directories to prune, or a profile"
+	ErrDaemonNeedsMasterPassword        = "the daemon needs a master_password for every scheduled profile"
+	ErrDaemonNeedsLogin                 = "the daemon needs " + portwarden.BWClientIDEnv + " and " + portwarden.BWClientSecretEnv + " or the account of every scheduled profile"
+
 	BWErrInvalidMasterPassword = "Invalid master password."
 	BWEnterEmailAddress        = "? Email address:"
 	BWEnterMasterPassword      = "? Master password:"
+	// BWNoInteractionEnv keeps the Bitwarden CLI from prompting
+	BWNoInteractionEnv = "BW_NOINTERACTION"
 )
 
 var (
 	passphrase        string
 	filename          string
 	sleepMilliseconds int
+	concurrency       int
+	rate              float64
 	noLogout          bool
+	profileName       string
+	configFile        string
+	passphraseFile    string
+	passphraseCommand string
+	passwordFD        int
+	passwordEnv       string
+	outputFormat      string
+
+	// profile is the config file profile of this run, nil without one
+	profile *portwarden.Profile
+	// config is the config file of this run, nil without one
+	config *portwarden.Config
+	// fdPassword is the master password once masterPassword read it from
+	// --password-fd, since a file descriptor can only be read once
+	fdPassword string
+
+	organizationID    string
+	collectionMap     cli.StringSlice
+	collectionMapFile string
+	defaultCollection string
+	verifyRestore     bool
+	deletionPlan      string
+
+	exportFormat   string
+	exportOutput   string
+	kdbxPassphrase string
+	kdbxKeyFile    string
+	kdbxKDF        string
+	gpgIDFile      string
+	gpgKeyring     string
+	includeSecrets bool
+
+	importFormat string
+
+	totpFormat          string
+	totpOutput          string
+	googleAuthenticator bool
+	migrationBatchSize  int
+
+	printOutput        string
+	printTitle         string
+	printFolders       cli.StringSlice
+	printItems         cli.StringSlice
+	printAttachmentMax int
+
+	auditFormat    string
+	auditMaxAge    int
+	auditWeakScore int
+	auditTOTPSites string
+	auditPwnedDB   string
+
+	auditURIsFormat string
+
+	expiryFormat string
+	expiryMonths int
+
+	dedupeFormat        string
+	dedupeCleanedOutput string
+	dedupePlanOutput    string
+
+	diffFormat        string
+	diffShowSecrets   bool
+	diffOldPassphrase string
+
+	searchRegex         bool
+	searchCaseSensitive bool
+	searchCopy          string
+	searchStdout        bool
+
+	totpWatch bool
+
+	daemonNow bool
+
+	pruneKeepLast int
+	pruneDaily    int
+	pruneWeekly   int
+	pruneMonthly  int
+	pruneYearly   int
+	pruneDryRun   bool
 )
 
 func main() {
@@ -50,15 +164,76 @@ func main() {
 		},
 		cli.IntFlag{
 			Name:        "sleep-milliseconds",
-			Usage:       "The number of milliseconds before making another request to download attachment",
+			Usage:       "Deprecated, use --rate. Makes one request every this many milliseconds when set",
 			Destination: &sleepMilliseconds,
-			Value:       300,
+		},
+		cli.IntFlag{
+			Name:        "concurrency",
+			Usage:       "The number of Bitwarden CLI requests that run at the same time",
+			Destination: &concurrency,
+			Value:       portwarden.DefaultConcurrency,
+		},
+		cli.Float64Flag{
+			Name:        "rate",
+			Usage:       "The maximum number of Bitwarden CLI requests per second; requests are retried with backoff when the server rate limits them",
+			Destination: &rate,
+			Value:       portwarden.DefaultRate,
 		},
 		cli.BoolFlag{
 			Name:        "no-logout",
 			Usage:       "If set to true, then Portwarden won't log you out of the Bitwarden CLI",
 			Destination: &noLogout,
 		},
+		cli.StringFlag{
+			Name:        "profile",
+			Usage:       "The profile of the config file to use instead of its default_profile",
+			Destination: &profileName,
+		},
+		cli.StringFlag{
+			Name:        "config",
+			Usage:       "The config file, $XDG_CONFIG_HOME/portwarden/config.yaml by default",
+			Destination: &configFile,
+		},
+		cli.StringFlag{
+			Name:        "passphrase-file",
+			Usage:       "Read the backup passphrase from this file instead of --passphrase",
+			Destination: &passphraseFile,
+		},
+		cli.StringFlag{
+			Name:        "passphrase-command",
+			Usage:       "Run this shell command and use its output as the backup passphrase instead of --passphrase",
+			Destination: &passphraseCommand,
+		},
+		cli.IntFlag{
+			Name:        "password-fd",
+			Usage:       "Read the master password of the Bitwarden account from this file descriptor to unlock without prompting",
+			Value:       -1,
+			Destination: &passwordFD,
+		},
+		cli.StringFlag{
+			Name:        "password-env",
+			Usage:       "Read the master password of the Bitwarden account from this environment variable to unlock without prompting",
+			Destination: &passwordEnv,
+		},
+		cli.StringFlag{
+			Name:        "output-format, output",
+			Usage:       "Print what the command did as text, or as a single JSON object on stdout with everything else on stderr when set to " + OutputJSON,
+			Value:       OutputText,
+			Destination: &outputFormat,
+		},
+	}
+	// cli.v1 prints the whole help before an error of Before, so fail here
+	app.Before = func(c *cli.Context) error {
+		if err := StartOutput(c); err != nil {
+			log.Fatal(err)
+		}
+		if err := LoadProfile(c); err != nil {
+			FinishOutput(err)
+		}
+		if err := ReadPassphrase(c); err != nil {
+			FinishOutput(err)
+		}
+		return nil
 	}
 
 	app.Commands = []cli.Command{
@@ -70,14 +245,96 @@ func main() {
 				if len(passphrase) == 0 {
 					return errors.New(ErrNoPhassPhraseProvided)
 				}
-				err := EncryptBackupController(filename, passphrase)
+				fileName := filename
+				if len(fileName) == 0 && profile != nil {
+					var err error
+					if fileName, err = newProfileBackupFileName(profile); err != nil {
+						return err
+					}
+				}
+				if !strings.HasSuffix(fileName, ".portwarden") {
+					fileName += ".portwarden"
+				}
+				err := EncryptBackupController(fileName, passphrase)
 				if err != nil {
 					return err
 				}
 				fmt.Println("encrypted export successful")
+				if profile != nil {
+					return finishProfileBackup(profile, fileName)
+				}
 				return nil
 			},
 		},
+		{
+			Name:  "daemon",
+			Usage: "Keep running and back up the profiles of the config file that have a schedule, or only the one of --profile, whenever they are due",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "now",
+					Usage:       "Back up every profile right away at the start, too",
+					Destination: &daemonNow,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return DaemonController(c)
+			},
+		},
+		{
+			Name:      "prune",
+			Usage:     "Delete the `.portwarden` backups in the directories that the retention policy doesn't keep, by default those of the profile in its output_dir and destinations",
+			ArgsUsage: "[directory...]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:        "keep-last",
+					Usage:       "Keep this many newest backups",
+					Destination: &pruneKeepLast,
+				},
+				cli.IntFlag{
+					Name:        "keep-daily",
+					Usage:       "Keep the newest backup of each of this many last days with backups",
+					Destination: &pruneDaily,
+				},
+				cli.IntFlag{
+					Name:        "keep-weekly",
+					Usage:       "Keep the newest backup of each of this many last weeks with backups",
+					Destination: &pruneWeekly,
+				},
+				cli.IntFlag{
+					Name:        "keep-monthly",
+					Usage:       "Keep the newest backup of each of this many last months with backups",
+					Destination: &pruneMonthly,
+				},
+				cli.IntFlag{
+					Name:        "keep-yearly",
+					Usage:       "Keep the newest backup of each of this many last years with backups",
+					Destination: &pruneYearly,
+				},
+				cli.BoolFlag{
+					Name:        "dry-run",
+					Usage:       "Only list what would be kept and deleted",
+					Destination: &pruneDryRun,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				var policy portwarden.RetentionPolicy
+				if profile != nil {
+					policy = profile.Retention
+				}
+				for flag, value := range map[string]*int{
+					"keep-last":    &policy.KeepLast,
+					"keep-daily":   &policy.Daily,
+					"keep-weekly":  &policy.Weekly,
+					"keep-monthly": &policy.Monthly,
+					"keep-yearly":  &policy.Yearly,
+				} {
+					if c.IsSet(flag) {
+						*value = c.Int(flag)
+					}
+				}
+				return PruneController(c.Args(), policy, pruneDryRun)
+			},
+		},
 		{
 			Name:    "decrypt",
 			Aliases: []string{"d"},
@@ -101,6 +358,39 @@ func main() {
 			Name:    "restore",
 			Aliases: []string{"d"},
 			Usage:   "restore a `.portwarden` backgup to a Bitwarden Account",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "organization-id",
+					Usage:       "Restore the items as ciphers of this organization instead of the personal vault",
+					Destination: &organizationID,
+				},
+				cli.StringSliceFlag{
+					Name:  "collection-map",
+					Usage: "Map a collection of the backup to a collection of the organization as `old=new`, using ids or names. Can be repeated",
+					Value: &collectionMap,
+				},
+				cli.StringFlag{
+					Name:        "collection-map-file",
+					Usage:       "A file with one `old=new` collection mapping per line",
+					Destination: &collectionMapFile,
+				},
+				cli.StringFlag{
+					Name:        "default-collection",
+					Usage:       "The collection id or name for items that don't belong to any collection",
+					Value:       portwarden.DefaultRestoreCollectionName,
+					Destination: &defaultCollection,
+				},
+				cli.BoolFlag{
+					Name:        "verify",
+					Usage:       "Compare the restored vault with the backup and fail if they differ",
+					Destination: &verifyRestore,
+				},
+				cli.StringFlag{
+					Name:        "deletion-plan",
+					Usage:       "Leave out the items of a deletion plan written by dedupe-report",
+					Destination: &deletionPlan,
+				},
+			},
 			Action: func(c *cli.Context) error {
 				if len(passphrase) == 0 {
 					return errors.New(ErrNoPhassPhraseProvided)
@@ -116,82 +406,1633 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "export",
+			Usage: "Convert a `.portwarden` backup into the format of another password manager",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "One of " + portwarden.ExportFormatBitwardenJSON + ", " + portwarden.ExportFormatBitwardenCSV + ", " + portwarden.ExportFormatKDBX + ", " + portwarden.ExportFormatPass + ", " + portwarden.ExportFormatHTMLViewer + ", " + sqliteExportFormat,
+					Destination: &exportFormat,
+				},
+				cli.StringFlag{
+					Name:        "output",
+					Usage:       "The file (or directory for " + portwarden.ExportFormatPass + ") to write; defaults to the backup's name with the format's extension",
+					Destination: &exportOutput,
+				},
+				cli.StringFlag{
+					Name:        "kdbx-passphrase",
+					Usage:       "The passphrase protecting the KeePass database",
+					Destination: &kdbxPassphrase,
+				},
+				cli.StringFlag{
+					Name:        "kdbx-key-file",
+					Usage:       "A KeePass key file protecting the KeePass database",
+					Destination: &kdbxKeyFile,
+				},
+				cli.StringFlag{
+					Name:        "kdbx-kdf",
+					Usage:       "The key derivation function of the KeePass database, " + portwarden.KDBXKDFAES + " or " + portwarden.KDBXKDFArgon2id,
+					Value:       portwarden.KDBXKDFAES,
+					Destination: &kdbxKDF,
+				},
+				cli.StringFlag{
+					Name:        "gpg-id",
+					Usage:       "A .gpg-id file with the recipients of a pass export; defaults to the store's own .gpg-id",
+					Destination: &gpgIDFile,
+				},
+				cli.StringFlag{
+					Name:        "gpg-keyring",
+					Usage:       "A file with the public keys of the pass recipients, e.g. from `gpg --export --armor`; defaults to exporting them from gpg",
+					Destination: &gpgKeyring,
+				},
+				cli.BoolFlag{
+					Name:        "include-secrets",
+					Usage:       "Include passwords, TOTP seeds, notes, hidden fields and card and identity numbers in the " + sqliteExportFormat + " export",
+					Destination: &includeSecrets,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				if len(filename) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				err := ExportController(filename, passphrase, exportFormat, exportOutput)
+				if err != nil {
+					return err
+				}
+				fmt.Println("export successful")
+				return nil
+			},
+		},
+		{
+			Name:  "export-totp",
+			Usage: "Export the TOTP seeds of a `.portwarden` backup for an authenticator app",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "One of " + portwarden.TOTPFormatURI + " (otpauth:// URIs), " + portwarden.TOTPFormatPNG + " (QR code images) or " + portwarden.TOTPFormatTerminal + " (QR codes printed in the terminal)",
+					Value:       portwarden.TOTPFormatURI,
+					Destination: &totpFormat,
+				},
+				cli.StringFlag{
+					Name:        "output",
+					Usage:       "The file for " + portwarden.TOTPFormatURI + " (defaults to the standard output) or the directory for " + portwarden.TOTPFormatPNG,
+					Destination: &totpOutput,
+				},
+				cli.BoolFlag{
+					Name:        "google-authenticator",
+					Usage:       "Batch the seeds into Google Authenticator's transfer codes instead of one code per account",
+					Destination: &googleAuthenticator,
+				},
+				cli.IntFlag{
+					Name:        "batch-size",
+					Usage:       "The number of accounts per Google Authenticator transfer code",
+					Value:       portwarden.DefaultMigrationBatchSize,
+					Destination: &migrationBatchSize,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				if len(filename) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				return ExportTOTPController(filename, passphrase, totpFormat, totpOutput)
+			},
+		},
+		{
+			Name:  "print",
+			Usage: "Render chosen items of a `.portwarden` backup as an HTML emergency kit to print or save as PDF",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "output",
+					Usage:       "The HTML file to write, by default next to the backup",
+					Destination: &printOutput,
+				},
+				cli.StringFlag{
+					Name:        "title",
+					Usage:       "The title printed on the first page",
+					Destination: &printTitle,
+				},
+				cli.StringSliceFlag{
+					Name:  "folder",
+					Usage: "Print the items of this folder and its subfolders. Can be repeated",
+					Value: &printFolders,
+				},
+				cli.StringSliceFlag{
+					Name:  "item",
+					Usage: "Print the item with this name or id. Can be repeated",
+					Value: &printItems,
+				},
+				cli.IntFlag{
+					Name:        "max-attachment-size",
+					Usage:       "Attachments larger than this many bytes are listed but not printed",
+					Value:       portwarden.DefaultPrintAttachmentSize,
+					Destination: &printAttachmentMax,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				if len(filename) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				return PrintController(filename, passphrase, printOutput, portwarden.PrintOptions{
+					Title:             printTitle,
+					Folders:           printFolders,
+					Items:             printItems,
+					MaxAttachmentSize: printAttachmentMax,
+				})
+			},
+		},
+		{
+			Name:      "audit",
+			Usage:     "Report weak, reused and stale passwords and missing TOTP in a `.portwarden` backup",
+			ArgsUsage: "[backup]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "One of " + portwarden.AuditFormatTable + " or " + portwarden.AuditFormatJSON,
+					Value:       portwarden.AuditFormatTable,
+					Destination: &auditFormat,
+				},
+				cli.IntFlag{
+					Name:        "max-age",
+					Usage:       "Flag passwords that haven't changed for more than this many days",
+					Value:       portwarden.DefaultAuditMaxAge,
+					Destination: &auditMaxAge,
+				},
+				cli.IntFlag{
+					Name:        "weak-score",
+					Usage:       "Flag passwords with a zxcvbn strength score (0 to 4) below this",
+					Value:       portwarden.DefaultAuditWeakScore,
+					Destination: &auditWeakScore,
+				},
+				cli.StringFlag{
+					Name:        "totp-sites",
+					Usage:       "The sites that support TOTP, as the totp.json of 2fa.directory or one domain per line, instead of the built-in list",
+					Destination: &auditTOTPSites,
+				},
+				cli.StringFlag{
+					Name:        "pwned-db",
+					Usage:       "Check the passwords offline against a Have I Been Pwned SHA-1 or NTLM dataset: the sorted text file, a directory of range files or an index from build-pwned-index",
+					Destination: &auditPwnedDB,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				fileName := filename
+				if c.NArg() > 0 {
+					fileName = c.Args().First()
+				}
+				if len(fileName) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				return AuditController(fileName, passphrase)
+			},
+		},
+		{
+			Name:      "audit-uris",
+			Usage:     "Report insecure, malformed and risky login URIs and domains shared by different passwords in a `.portwarden` backup",
+			ArgsUsage: "[backup]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "One of " + portwarden.AuditFormatTable + " or " + portwarden.AuditFormatJSON,
+					Value:       portwarden.AuditFormatTable,
+					Destination: &auditURIsFormat,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				fileName := filename
+				if c.NArg() > 0 {
+					fileName = c.Args().First()
+				}
+				if len(fileName) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				return AuditURIsController(fileName, passphrase)
+			},
+		},
+		{
+			Name:      "expiry",
+			Usage:     "Report expired and expiring cards, malformed card numbers and identity documents in a `.portwarden` backup",
+			ArgsUsage: "[backup]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "One of " + portwarden.AuditFormatTable + " or " + portwarden.AuditFormatJSON,
+					Value:       portwarden.AuditFormatTable,
+					Destination: &expiryFormat,
+				},
+				cli.IntFlag{
+					Name:        "months",
+					Usage:       "Flag cards and documents expiring within this many months",
+					Value:       portwarden.DefaultExpiryMonths,
+					Destination: &expiryMonths,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				fileName := filename
+				if c.NArg() > 0 {
+					fileName = c.Args().First()
+				}
+				if len(fileName) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				return ExpiryController(fileName, passphrase)
+			},
+		},
+		{
+			Name:      "dedupe-report",
+			Usage:     "Find duplicate items in a `.portwarden` backup and propose which to keep",
+			ArgsUsage: "[backup]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "One of " + portwarden.AuditFormatTable + " or " + portwarden.AuditFormatJSON,
+					Value:       portwarden.AuditFormatTable,
+					Destination: &dedupeFormat,
+				},
+				cli.StringFlag{
+					Name:        "cleaned-output",
+					Usage:       "Write a backup without the duplicates, their extra fields, history and attachments merged into the kept items, encrypted with the same passphrase",
+					Destination: &dedupeCleanedOutput,
+				},
+				cli.StringFlag{
+					Name:        "plan-output",
+					Usage:       "Write the duplicates to delete as a JSON deletion plan for `restore --deletion-plan`",
+					Destination: &dedupePlanOutput,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				fileName := filename
+				if c.NArg() > 0 {
+					fileName = c.Args().First()
+				}
+				if len(fileName) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				return DedupeReportController(fileName, passphrase)
+			},
+		},
+		{
+			Name:      "diff",
+			Usage:     "Show the folders and items added, removed, renamed, moved or modified between two `.portwarden` backups",
+			ArgsUsage: "<old backup> <new backup>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "One of " + portwarden.DiffFormatText + " or " + portwarden.DiffFormatJSON,
+					Value:       portwarden.DiffFormatText,
+					Destination: &diffFormat,
+				},
+				cli.BoolFlag{
+					Name:        "show-secrets",
+					Usage:       "Show changed passwords, notes, hidden fields and card and identity numbers instead of masking them",
+					Destination: &diffShowSecrets,
+				},
+				cli.StringFlag{
+					Name:        "old-passphrase",
+					Usage:       "The passphrase of the old backup, if it differs from --passphrase",
+					Destination: &diffOldPassphrase,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				if c.NArg() != 2 {
+					return errors.New(ErrDiffArguments)
+				}
+				oldPassphrase := diffOldPassphrase
+				if len(oldPassphrase) == 0 {
+					oldPassphrase = passphrase
+				}
+				return DiffController(c.Args().Get(0), oldPassphrase, c.Args().Get(1), passphrase)
+			},
+		},
+		{
+			Name:      "search",
+			Usage:     "Find items in a `.portwarden` backup by name, username, URI, notes, custom field name or folder",
+			ArgsUsage: "[backup] <query>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "regex",
+					Usage:       "Treat the query as a regular expression",
+					Destination: &searchRegex,
+				},
+				cli.BoolFlag{
+					Name:        "case-sensitive",
+					Usage:       "Match upper and lower case exactly",
+					Destination: &searchCaseSensitive,
+				},
+				cli.StringFlag{
+					Name:        "copy",
+					Usage:       "Copy a field of the only matching item to the clipboard: username, password, totp, uri, notes, card.number, card.code or a custom field name",
+					Destination: &searchCopy,
+				},
+				cli.BoolFlag{
+					Name:        "stdout",
+					Usage:       "Print the --copy field instead of copying it to the clipboard",
+					Destination: &searchStdout,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				fileName, query := filename, c.Args().First()
+				switch {
+				case c.NArg() == 2:
+					fileName, query = c.Args().Get(0), c.Args().Get(1)
+				case c.NArg() != 1 || len(fileName) == 0:
+					return errors.New(ErrSearchArguments)
+				}
+				return SearchController(fileName, passphrase, query)
+			},
+		},
+		{
+			Name:      "totp",
+			Usage:     "Generate the current TOTP codes of the items matching a query in a `.portwarden` backup, without Bitwarden",
+			ArgsUsage: "[backup] <item-query>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:        "watch",
+					Usage:       "Keep showing the codes with a countdown until interrupted",
+					Destination: &totpWatch,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				fileName, query := filename, c.Args().First()
+				switch {
+				case c.NArg() == 2:
+					fileName, query = c.Args().Get(0), c.Args().Get(1)
+				case c.NArg() != 1 || len(fileName) == 0:
+					return errors.New(ErrTOTPArguments)
+				}
+				return TOTPController(fileName, passphrase, query)
+			},
+		},
+		{
+			Name:      "build-pwned-index",
+			Usage:     "Build an index of a sorted Have I Been Pwned text file for faster `audit --pwned-db` lookups",
+			ArgsUsage: "<pwned-passwords.txt> <index>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 2 {
+					return errors.New(ErrPwnedIndexArguments)
+				}
+				return BuildPwnedIndexController(c.Args().Get(0), c.Args().Get(1))
+			},
+		},
+		{
+			Name:      "import",
+			Usage:     "Convert the export of another password manager into an encrypted `.portwarden` backup",
+			ArgsUsage: "<file>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "from",
+					Usage:       "One of " + portwarden.ImportFormatKeePass + ", " + portwarden.ImportFormatLastPassCSV + ", " + portwarden.ImportFormat1PUX,
+					Destination: &importFormat,
+				},
+				cli.StringFlag{
+					Name:        "kdbx-passphrase",
+					Usage:       "The passphrase protecting the KeePass database",
+					Destination: &kdbxPassphrase,
+				},
+				cli.StringFlag{
+					Name:        "kdbx-key-file",
+					Usage:       "A KeePass key file protecting the KeePass database",
+					Destination: &kdbxKeyFile,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(passphrase) == 0 {
+					return errors.New(ErrNoPhassPhraseProvided)
+				}
+				if len(filename) == 0 {
+					return errors.New(ErrNoFilenameProvided)
+				}
+				if len(c.Args().First()) == 0 {
+					return errors.New(ErrNoImportFileProvided)
+				}
+				err := ImportController(filename, passphrase, importFormat, c.Args().First())
+				if err != nil {
+					return err
+				}
+				fmt.Println("import successful")
+				return nil
+			},
+		},
 	}
 
 	sort.Sort(cli.FlagsByName(app.Flags))
 	sort.Sort(cli.CommandsByName(app.Commands))
 
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+	FinishOutput(app.Run(os.Args))
 }
 
-func EncryptBackupController(fileName, passphrase string) error {
-	sessionKey, err := BWGetSessionKey()
+// LoadProfile reads the profile named by --profile, or the default one, from
+// the config file and fills in the flags that weren't given with it. A
+// missing config file is only an error when --profile or --config is set.
+func LoadProfile(c *cli.Context) error {
+	fileName := configFile
+	if len(fileName) == 0 {
+		var err error
+		if fileName, err = portwarden.ConfigPath(); err != nil {
+			if len(profileName) > 0 {
+				return err
+			}
+			return nil
+		}
+	}
+	var err error
+	config, err = portwarden.ReadConfigFile(fileName)
 	if err != nil {
+		if os.IsNotExist(err) && len(profileName) == 0 && len(configFile) == 0 {
+			return nil
+		}
+		return err
+	}
+	p, err := config.Profile(profileName)
+	if err != nil || p == nil {
 		return err
 	}
-	return portwarden.CreateBackupFile(fileName, passphrase, sessionKey, sleepMilliseconds, noLogout)
+	UseProfile(c, p)
+	return nil
 }
 
-func DecryptBackupController(fileName, passphrase string) error {
-	return portwarden.DecryptBackupFile(fileName, passphrase)
+// UseProfile makes p the profile of this run and fills in the flags that
+// weren't given with it.
+func UseProfile(c *cli.Context, p *portwarden.Profile) {
+	profile = p
+	result.Profile = p.Name
+	if !c.GlobalIsSet("rate") {
+		rate = portwarden.DefaultRate
+		if profile.Rate > 0 {
+			rate = profile.Rate
+		}
+	}
+	if !c.GlobalIsSet("concurrency") {
+		concurrency = portwarden.DefaultConcurrency
+		if profile.Concurrency > 0 {
+			concurrency = profile.Concurrency
+		}
+	}
+	if !c.GlobalIsSet("sleep-milliseconds") {
+		sleepMilliseconds = profile.SleepMilliseconds
+	}
 }
 
-func RestoreBackupController(fileName, passphrase string) error {
+// profileKDF returns the KDF settings new backups are encrypted with, the
+// ones of the profile if there is one.
+func profileKDF() portwarden.KDFSettings {
+	if profile == nil {
+		return portwarden.KDFSettings{}
+	}
+	return profile.KDF
+}
+
+// ReadPassphrase fills in the backup passphrase from --passphrase-file or
+// --passphrase-command, or else the profile, unless --passphrase is given.
+func ReadPassphrase(c *cli.Context) error {
+	if len(passphrase) > 0 || !needsPassphrase(c) {
+		return nil
+	}
 	var err error
-	var sessionKey string
-	err = portwarden.BWLogout()
+	passphrase, err = backupPassphrase()
+	return err
+}
+
+func backupPassphrase() (string, error) {
+	if len(passphrase) > 0 {
+		return passphrase, nil
+	}
+	source := portwarden.SecretSource{File: passphraseFile, Command: passphraseCommand}
+	if !source.IsSet() && profile != nil {
+		source = profile.Passphrase
+	}
+	secret, err := source.Read()
 	if err != nil {
-		if err.Error() != portwarden.BWErrNotLoggedIn {
-			return err
-		}
+		return "", fmt.Errorf("backup passphrase: %v", err)
 	}
-	sessionKey, err = BWGetSessionKey()
+	return secret, nil
+}
+
+// newProfileBackupFileName names a backup of p made now and creates the
+// directory it goes to.
+func newProfileBackupFileName(p *portwarden.Profile) (string, error) {
+	fileName, err := p.BackupFileName(time.Now())
 	if err != nil {
-		return err
+		return "", err
 	}
-	return portwarden.RestoreBackupFile(fileName, passphrase, sessionKey, sleepMilliseconds, noLogout)
+	return fileName, os.MkdirAll(filepath.Dir(fileName), 0700)
 }
 
-func BWGetSessionKey() (string, error) {
-	sessionKey, err := BWUnlockVaultToGetSessionKey()
+// finishProfileBackup copies a new backup of p to its destinations and
+// prunes the old ones by its retention policy.
+func finishProfileBackup(p *portwarden.Profile, fileName string) error {
+	copies, err := p.CopyToDestinations(fileName)
+	for _, target := range copies {
+		fmt.Println("copied the backup to", target)
+		wroteFile(target)
+	}
+	if err != nil || !p.Retention.IsSet() {
+		return err
+	}
+	pattern, err := p.BackupFilePattern()
 	if err != nil {
-		if err.Error() == portwarden.BWErrNotLoggedIn {
-			sessionKey, err = BWLoginGetSessionKey()
-			if err != nil {
-				return "", err
+		return err
+	}
+	for _, dir := range p.BackupDirs() {
+		decisions, err := planPrune(dir, pattern, p.Retention)
+		if err != nil {
+			return err
+		}
+		for _, d := range decisions {
+			if d.Keep() {
+				continue
 			}
-		} else {
-			return "", err
+			if err := os.Remove(d.Name); err != nil {
+				return err
+			}
+			fmt.Println("pruned", d.Name)
+			result.Deleted = append(result.Deleted, d.Name)
 		}
 	}
-	return sessionKey, err
+	return nil
 }
 
-func BWUnlockVaultToGetSessionKey() (string, error) {
-	cmd := exec.Command("bw", "unlock")
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	cmd.Stdin = os.Stdin
+// needsPassphrase tells whether the command of this run may use the backup
+// passphrase, so that a passphrase command doesn't prompt for help output.
+func needsPassphrase(c *cli.Context) bool {
+	if helpRequested(c) {
+		return false
+	}
+	switch c.Args().First() {
+	// the daemon reads the passphrase of each profile when it backs it up
+	case "build-pwned-index", "daemon":
+		return false
+	}
+	return true
+}
 
-	if err := cmd.Start(); err != nil {
-		fmt.Println("An error occurred: ", err)
+// helpRequested tells whether this run only prints help, without a command
+// or with help or --help.
+func helpRequested(c *cli.Context) bool {
+	args := c.Args()
+	if len(args) == 0 {
+		return true
 	}
-	cmd.Wait()
-	sessionKey, err := portwarden.ExtractSessionKey(stdout.String())
-	if err != nil {
-		return "", errors.New(string(stderr.Bytes()))
+	switch args[0] {
+	case "help", "h":
+		return true
 	}
-	return sessionKey, nil
+	for _, arg := range args[1:] {
+		if arg == "--help" || arg == "-h" {
+			return true
+		}
+	}
+	return false
 }
 
-func BWLoginGetSessionKey() (string, error) {
-	cmd := exec.Command("bw", "login")
+// daemonJob is a profile the daemon backs up and the session it keeps
+// between backups.
+type daemonJob struct {
+	profile    *portwarden.Profile
+	schedule   portwarden.Schedule
+	next       time.Time
+	sessionKey string
+}
+
+// DaemonController runs the backups of the scheduled profiles one at a time
+// until SIGINT or SIGTERM. A failed backup is logged and retried when it is
+// due next. Each profile has a Bitwarden CLI data directory of its own where
+// it stays logged in, and its session is reused while `bw` still accepts it;
+// the vaults are locked on the way out. Nothing prompts: a profile that
+// can't be unlocked unattended is refused at the start, and BW_SESSION is
+// ignored since it can't unlock the data directories of all profiles.
+func DaemonController(c *cli.Context) error {
+	os.Unsetenv(portwarden.BWSessionEnv)
+	os.Setenv(BWNoInteractionEnv, "true")
+	var jobs []*daemonJob
+	if config != nil {
+		for _, p := range config.Profiles {
+			if len(p.Schedule) == 0 || (len(profileName) > 0 && p.Name != profileName) {
+				continue
+			}
+			schedule, err := portwarden.ParseSchedule(p.Schedule)
+			if err != nil {
+				return err
+			}
+			if err := checkUnattended(p); err != nil {
+				return fmt.Errorf("profile %v: %v", p.Name, err)
+			}
+			jobs = append(jobs, &daemonJob{profile: p, schedule: schedule})
+		}
+	}
+	if len(jobs) == 0 {
+		return errors.New(ErrNoScheduledProfiles)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].profile.Name < jobs[j].profile.Name })
+	now := time.Now()
+	for _, job := range jobs {
+		job.next = job.schedule.Next(now)
+		if daemonNow {
+			job.next = now
+		}
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer func() {
+		for _, job := range jobs {
+			job.lock()
+		}
+	}()
+	for {
+		job := jobs[0]
+		for _, other := range jobs[1:] {
+			if other.next.Before(job.next) {
+				job = other
+			}
+		}
+		log.Printf("next backup of profile %v at %v", job.profile.Name, job.next.Format("2006-01-02 15:04"))
+		if !waitUntil(job.next, stop) {
+			log.Println("stopping")
+			return nil
+		}
+		start := time.Now()
+		// each backup has a result of its own, a line of JSON with
+		// --output-format json
+		result = newResult("daemon")
+		fileName, err := job.runRecovered(c)
+		if err != nil {
+			log.Printf("backup of profile %v failed: %v", job.profile.Name, err)
+		} else {
+			log.Printf("backed up profile %v to %v in %v", job.profile.Name, fileName, time.Since(start).Round(time.Second))
+		}
+		if jsonOutput() {
+			printResult(err)
+			result = newResult("daemon")
+		}
+		job.next = job.schedule.Next(time.Now())
+	}
+}
+
+// waitUntil returns true at t, or false once stop receives. It checks the
+// clock every minute so that a suspended machine catches up on waking.
+func waitUntil(t time.Time, stop <-chan os.Signal) bool {
+	for {
+		d := time.Until(t)
+		if d <= 0 {
+			return true
+		}
+		if d > time.Minute {
+			d = time.Minute
+		}
+		select {
+		case <-time.After(d):
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// checkUnattended makes sure the daemon can unlock the vault of p without
+// prompting: it needs a master password, and an API key or the account to
+// log in with.
+func checkUnattended(p *portwarden.Profile) error {
+	if !p.MasterPassword.IsSet() && passwordFD < 0 && len(passwordEnv) == 0 {
+		return errors.New(ErrDaemonNeedsMasterPassword)
+	}
+	apiKey, err := portwarden.HasAPIKey()
+	if err != nil {
+		return err
+	}
+	if !apiKey && len(p.Account) == 0 {
+		return errors.New(ErrDaemonNeedsLogin)
+	}
+	return nil
+}
+
+func (job *daemonJob) useAppDataDir() error {
+	dir, err := job.profile.BWAppDataDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.Setenv("BITWARDENCLI_APPDATA_DIR", dir)
+}
+
+func (job *daemonJob) run(c *cli.Context) (string, error) {
+	UseProfile(c, job.profile)
+	if err := job.useAppDataDir(); err != nil {
+		return "", err
+	}
+	jobPassphrase, err := backupPassphrase()
+	if err != nil {
+		return "", err
+	}
+	if len(jobPassphrase) == 0 {
+		return "", errors.New(ErrNoPhassPhraseProvided)
+	}
+	if len(job.sessionKey) == 0 || !portwarden.BWSessionUnlocked(job.sessionKey) {
+		if job.sessionKey, err = job.unlock(); err != nil {
+			job.sessionKey = ""
+			return "", err
+		}
+	}
+	fileName, err := newProfileBackupFileName(job.profile)
+	if err != nil {
+		return "", err
+	}
+	if err := portwarden.CreateBackupFile(fileName, jobPassphrase, job.sessionKey, NewPacerFromFlags(), true, profileKDF()); err != nil {
+		// don't leave an empty backup behind
+		os.Remove(fileName)
+		return "", err
+	}
+	wroteFile(fileName)
+	return fileName, finishProfileBackup(job.profile, fileName)
+}
+
+// runRecovered runs the backup and turns a panic into its error, so that
+// the other profiles keep being backed up.
+func (job *daemonJob) runRecovered(c *cli.Context) (fileName string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("backup of profile %v panicked: %v\n%s", job.profile.Name, r, debug.Stack())
+			fileName, err = "", fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.run(c)
+}
+
+// unlock returns a session of the profile's vault, logging in first if
+// needed. Unlike BWGetSessionKey it never falls back to prompting.
+func (job *daemonJob) unlock() (string, error) {
+	if len(job.profile.Server) > 0 {
+		if err := portwarden.BWConfigServer(job.profile.Server); err != nil {
+			return "", err
+		}
+	}
+	apiKey, err := portwarden.HasAPIKey()
+	if err != nil {
+		return "", err
+	}
+	password, err := masterPassword()
+	if err != nil {
+		return "", err
+	}
+	if len(password) == 0 {
+		return "", errors.New(ErrDaemonNeedsMasterPassword)
+	}
+	return BWUnattendedGetSessionKey(password, apiKey)
+}
+
+func (job *daemonJob) lock() {
+	if len(job.sessionKey) == 0 {
+		return
+	}
+	if err := job.useAppDataDir(); err == nil {
+		portwarden.BWLock(job.sessionKey)
+	}
+}
+
+// PruneController applies policy to the backups in each of dirs on its own,
+// or to those of the profile without dirs, and deletes what it doesn't keep
+// unless dryRun.
+func PruneController(dirs []string, policy portwarden.RetentionPolicy, dryRun bool) error {
+	var pattern *regexp.Regexp
+	if len(dirs) == 0 {
+		if profile == nil {
+			return errors.New(ErrPruneArguments)
+		}
+		var err error
+		if pattern, err = profile.BackupFilePattern(); err != nil {
+			return err
+		}
+		dirs = profile.BackupDirs()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	var report []portwarden.RetentionDecision
+	for _, dir := range dirs {
+		decisions, err := planPrune(dir, pattern, policy)
+		if err != nil {
+			return err
+		}
+		report = append(report, decisions...)
+		fmt.Fprintf(w, "%v\n", dir)
+		for _, d := range decisions {
+			action := "keep"
+			if !d.Keep() {
+				action = "delete"
+				if dryRun {
+					action = "would delete"
+				} else if err := os.Remove(d.Name); err != nil {
+					w.Flush()
+					return err
+				} else {
+					result.Deleted = append(result.Deleted, d.Name)
+				}
+			}
+			fmt.Fprintf(w, "  %v\t%v\t%v\t%v\n", action, d.Time.Local().Format("2006-01-02 15:04"), filepath.Base(d.Name), strings.Join(d.Reasons, ", "))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return setReport(report)
+}
+
+// planPrune applies policy to the backups in dir that match pattern. A
+// destination that doesn't exist yet has nothing to prune.
+func planPrune(dir string, pattern *regexp.Regexp, policy portwarden.RetentionPolicy) ([]portwarden.RetentionDecision, error) {
+	backups, err := portwarden.ListBackupFiles(dir, pattern)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return policy.Apply(backups)
+}
+
+func EncryptBackupController(fileName, passphrase string) error {
+	sessionKey, err := BWGetSessionKey()
+	if err != nil {
+		return err
+	}
+	if err := portwarden.CreateBackupFile(fileName, passphrase, sessionKey, NewPacerFromFlags(), noLogout, profileKDF()); err != nil {
+		return err
+	}
+	wroteFile(fileName)
+	return nil
+}
+
+func DecryptBackupController(fileName, passphrase string) error {
+	if err := portwarden.DecryptBackupFile(fileName, passphrase); err != nil {
+		return err
+	}
+	wroteFile(fileName + ".decrypted.zip")
+	return nil
+}
+
+func RestoreBackupController(fileName, passphrase string) error {
+	var err error
+	var sessionKey string
+	// a BW_SESSION names the account to restore to already
+	if len(os.Getenv(portwarden.BWSessionEnv)) == 0 {
+		err = portwarden.BWLogout()
+		if err != nil {
+			if err.Error() != portwarden.BWErrNotLoggedIn {
+				return err
+			}
+		}
+	}
+	opts, err := RestoreOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	sessionKey, err = BWGetSessionKey()
+	if err != nil {
+		return err
+	}
+	return portwarden.RestoreBackupFile(fileName, passphrase, sessionKey, NewPacerFromFlags(), noLogout, opts)
+}
+
+func NewPacerFromFlags() *portwarden.Pacer {
+	if sleepMilliseconds > 0 {
+		return portwarden.NewPacerFromSleepMilliseconds(sleepMilliseconds)
+	}
+	return portwarden.NewPacer(concurrency, rate)
+}
+
+func ExportController(fileName, passphrase, format, output string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(fileName, ".portwarden")
+	switch format {
+	case portwarden.ExportFormatBitwardenJSON:
+		return writeExportFile(output, base+".json", func(w io.Writer) error {
+			return portwarden.ExportBitwardenJSON(b, w)
+		})
+	case portwarden.ExportFormatBitwardenCSV:
+		return writeExportFile(output, base+".csv", func(w io.Writer) error {
+			skipped, err := portwarden.ExportBitwardenCSV(b, w)
+			for _, item := range skipped {
+				warn("skipping item the CSV format can't hold: %v", item.Name)
+			}
+			return err
+		})
+	case portwarden.ExportFormatKDBX:
+		opts := portwarden.KDBXOptions{Passphrase: kdbxPassphrase, KDF: kdbxKDF}
+		if len(kdbxKeyFile) > 0 {
+			if opts.KeyFile, err = ioutil.ReadFile(kdbxKeyFile); err != nil {
+				return err
+			}
+		}
+		return writeExportFile(output, base+".kdbx", func(w io.Writer) error {
+			return portwarden.ExportKDBX(b, w, opts)
+		})
+	case portwarden.ExportFormatHTMLViewer:
+		encrypted, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		return writeExportFile(output, base+".html", func(w io.Writer) error {
+			return portwarden.ExportHTMLViewer(encrypted, w)
+		})
+	case sqliteExportFormat:
+		if len(output) == 0 {
+			output = base + ".sqlite"
+		}
+		if err := exportSQLite(b, output); err != nil {
+			return err
+		}
+		fmt.Println("wrote", output)
+		wroteFile(output)
+		return nil
+	case portwarden.ExportFormatPass:
+		if len(output) == 0 {
+			output = base + "-password-store"
+		}
+		skipped, err := portwarden.ExportPass(b, output, portwarden.PassOptions{GPGIDFile: gpgIDFile, Keyring: gpgKeyring})
+		for _, item := range skipped {
+			warn("skipping item that isn't a login: %v", item.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("wrote", output)
+		wroteFile(output)
+		return nil
+	}
+	return fmt.Errorf("%v: %q", ErrUnknownExportFormat, format)
+}
+
+// totpURI is an otpauth:// URI that `export-totp` prints.
+type totpURI struct {
+	Label string `json:"label"`
+	URI   string `json:"uri"`
+}
+
+func ExportTOTPController(fileName, passphrase, format, output string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	entries, invalid := portwarden.TOTPEntries(b)
+	for _, item := range invalid {
+		warn("skipping item with an invalid TOTP secret: %v", item.Name)
+	}
+	codes := portwarden.TOTPCodes(entries)
+	if googleAuthenticator {
+		var unsupported []portwarden.TOTPEntry
+		codes, unsupported, err = portwarden.MigrationCodes(entries, migrationBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, entry := range unsupported {
+			warn("skipping TOTP Google Authenticator doesn't support: %v", entry.Item.Name)
+		}
+	}
+
+	result.Counts["codes"] = len(codes)
+	switch format {
+	case portwarden.TOTPFormatURI:
+		if len(output) == 0 && jsonOutput() {
+			uris := make([]totpURI, len(codes))
+			for i, code := range codes {
+				uris[i] = totpURI{Label: code.Label, URI: code.Content}
+			}
+			return setReport(uris)
+		}
+		write := func(w io.Writer) error {
+			for _, code := range codes {
+				if _, err := fmt.Fprintln(w, code.Content); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if len(output) == 0 {
+			return write(os.Stdout)
+		}
+		return writeExportFile(output, output, write)
+	case portwarden.TOTPFormatPNG:
+		if len(output) == 0 {
+			output = strings.TrimSuffix(fileName, ".portwarden") + "-totp"
+		}
+		if err := portwarden.WriteTOTPQRCodes(codes, output); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %v QR codes to %v\n", len(codes), output)
+		wroteFile(output)
+		return nil
+	case portwarden.TOTPFormatTerminal:
+		for _, code := range codes {
+			qr, err := portwarden.TOTPQRCodeTerminal(code.Content)
+			if err != nil {
+				return err
+			}
+			fmt.Println(code.Label)
+			fmt.Println(qr)
+		}
+		return nil
+	}
+	return fmt.Errorf("%v: %q", ErrUnknownTOTPFormat, format)
+}
+
+func PrintController(fileName, passphrase, output string, opts portwarden.PrintOptions) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeExportFile(output, strings.TrimSuffix(fileName, ".portwarden")+"-emergency-kit.html", func(w io.Writer) error {
+		return portwarden.PrintEmergencyKit(b, w, opts)
+	})
+}
+
+func AuditController(fileName, passphrase string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	opts := portwarden.AuditOptions{WeakScore: &auditWeakScore, MaxAgeDays: auditMaxAge}
+	if len(auditTOTPSites) > 0 {
+		f, err := os.Open(auditTOTPSites)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if opts.TOTPSites, err = portwarden.ReadTOTPSites(f); err != nil {
+			return err
+		}
+	}
+	if len(auditPwnedDB) > 0 {
+		if opts.PwnedDB, err = portwarden.OpenPwnedDB(auditPwnedDB); err != nil {
+			return err
+		}
+		defer opts.PwnedDB.Close()
+	}
+	report, err := portwarden.Audit(b, opts)
+	if err != nil {
+		return err
+	}
+	return writeReport(auditFormat, portwarden.AuditFormatJSON, func(w io.Writer, format string) error {
+		return portwarden.WriteAuditReport(report, w, format)
+	})
+}
+
+func AuditURIsController(fileName, passphrase string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	report, err := portwarden.AuditURIs(b)
+	if err != nil {
+		return err
+	}
+	return writeReport(auditURIsFormat, portwarden.AuditFormatJSON, func(w io.Writer, format string) error {
+		return portwarden.WriteURIAuditReport(report, w, format)
+	})
+}
+
+func ExpiryController(fileName, passphrase string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	report := portwarden.AuditExpiry(b, portwarden.ExpiryOptions{Months: expiryMonths})
+	return writeReport(expiryFormat, portwarden.AuditFormatJSON, func(w io.Writer, format string) error {
+		return portwarden.WriteExpiryReport(report, w, format)
+	})
+}
+
+func DedupeReportController(fileName, passphrase string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	report := portwarden.FindDuplicates(b)
+	err = writeReport(dedupeFormat, portwarden.AuditFormatJSON, func(w io.Writer, format string) error {
+		return portwarden.WriteDuplicateReport(report, w, format)
+	})
+	if err != nil {
+		return err
+	}
+	// the report may go to stdout as JSON, so progress goes to stderr
+	if len(dedupePlanOutput) > 0 {
+		rawBytes, err := json.MarshalIndent(report.DeletionPlan(), "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dedupePlanOutput, append(rawBytes, '\n'), 0600); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "wrote", dedupePlanOutput)
+		wroteFile(dedupePlanOutput)
+	}
+	if len(dedupeCleanedOutput) > 0 {
+		if err := portwarden.WriteBackupFile(dedupeCleanedOutput, passphrase, portwarden.RemoveDuplicates(b, report), profileKDF()); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "wrote", dedupeCleanedOutput)
+		wroteFile(dedupeCleanedOutput)
+	}
+	return nil
+}
+
+func DiffController(oldFileName, oldPassphrase, newFileName, newPassphrase string) error {
+	before, err := portwarden.ReadBackupFile(oldFileName, oldPassphrase)
+	if err != nil {
+		return fmt.Errorf("%v: %v", oldFileName, err)
+	}
+	after, err := portwarden.ReadBackupFile(newFileName, newPassphrase)
+	if err != nil {
+		return fmt.Errorf("%v: %v", newFileName, err)
+	}
+	d := portwarden.DiffBackups(before, after, portwarden.DiffOptions{ShowSecrets: diffShowSecrets})
+	return writeReport(diffFormat, portwarden.DiffFormatJSON, func(w io.Writer, format string) error {
+		return portwarden.WriteBackupDiff(d, w, format)
+	})
+}
+
+func SearchController(fileName, passphrase, query string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	matches, err := portwarden.SearchItems(b, query, portwarden.SearchOptions{Regex: searchRegex, CaseSensitive: searchCaseSensitive})
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return errors.New(ErrSearchNoMatch)
+	}
+
+	if len(searchCopy) > 0 {
+		if len(matches) > 1 {
+			printSearchMatches(matches)
+			return errors.New(ErrSearchAmbiguous)
+		}
+		value, err := portwarden.ItemField(matches[0].Item, searchCopy)
+		if err != nil {
+			return err
+		}
+		if searchStdout && jsonOutput() {
+			return setReport(searchField{Item: matches[0].Item.Name, Field: searchCopy, Value: value})
+		}
+		if searchStdout {
+			fmt.Println(value)
+			return nil
+		}
+		if err := copyToClipboard(value); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "copied the %v of %v to the clipboard\n", searchCopy, matches[0].Item.Name)
+		return nil
+	}
+	printSearchMatches(matches)
+	return nil
+}
+
+// searchResult is a search match in the report of --output-format json. Like
+// printSearchMatches, it leaves out secrets.
+type searchResult struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Folder   string   `json:"folder,omitempty"`
+	Username string   `json:"username,omitempty"`
+	URIs     []string `json:"uris,omitempty"`
+	Matched  []string `json:"matched"`
+}
+
+// searchField is the field of an item that `search --copy --stdout` prints.
+type searchField struct {
+	Item  string `json:"item"`
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+func printSearchMatches(matches []portwarden.SearchMatch) {
+	if jsonOutput() {
+		report := make([]searchResult, len(matches))
+		for i, m := range matches {
+			report[i] = searchResult{ID: m.Item.ID, Name: m.Item.Name, Folder: m.Folder, Matched: m.Fields}
+			if m.Item.Login != nil {
+				if username := m.Item.Login.Username; username != nil {
+					report[i].Username = *username
+				}
+				for _, uri := range m.Item.Login.Uris {
+					report[i].URIs = append(report[i].URIs, uri.URI)
+				}
+			}
+		}
+		setReport(report)
+		return
+	}
+	for _, m := range matches {
+		name := m.Item.Name
+		if len(m.Folder) > 0 {
+			name = m.Folder + "/" + name
+		}
+		fmt.Printf("%v (%v)\n", name, m.Item.ID)
+		if m.Item.Login != nil {
+			if username := m.Item.Login.Username; username != nil && len(*username) > 0 {
+				fmt.Println("    username:", *username)
+			}
+			for _, uri := range m.Item.Login.Uris {
+				fmt.Println("    uri:", uri.URI)
+			}
+		}
+		fmt.Println("    matched:", strings.Join(m.Fields, ", "))
+	}
+}
+
+// copyToClipboard pipes value into the clipboard tool of the platform.
+func copyToClipboard(value string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	default:
+		if len(os.Getenv("WAYLAND_DISPLAY")) > 0 {
+			candidates = append(candidates, []string{"wl-copy"})
+		}
+		candidates = append(candidates, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"})
+	}
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(value)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return errors.New(stderr.String())
+		}
+		return nil
+	}
+	return errors.New(ErrNoClipboard)
+}
+
+// totpCode is a code that `totp` prints.
+type totpCode struct {
+	Name             string `json:"name"`
+	Code             string `json:"code"`
+	RemainingSeconds int    `json:"remaining_seconds"`
+}
+
+func TOTPController(fileName, passphrase, query string) error {
+	b, err := portwarden.ReadBackupFile(fileName, passphrase)
+	if err != nil {
+		return err
+	}
+	matches, err := portwarden.SearchItems(b, query, portwarden.SearchOptions{})
+	if err != nil {
+		return err
+	}
+	var names []string
+	var keys []*portwarden.TOTPKey
+	for _, m := range matches {
+		k, err := portwarden.ParseItemTOTP(m.Item)
+		if err != nil {
+			warn("skipping %v: %v", m.Item.Name, err)
+			continue
+		}
+		if k == nil {
+			continue
+		}
+		name := m.Item.Name
+		if len(m.Folder) > 0 {
+			name = m.Folder + "/" + name
+		}
+		if username := k.Account; len(username) > 0 && username != m.Item.Name {
+			name += " (" + username + ")"
+		}
+		names = append(names, name)
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return errors.New(ErrTOTPNoMatch)
+	}
+
+	lines := func(now time.Time) ([]string, error) {
+		var out []string
+		for i, k := range keys {
+			code, err := k.Code(now)
+			if err != nil {
+				return nil, err
+			}
+			remaining := int(k.Remaining(now).Seconds() + 0.5)
+			out = append(out, fmt.Sprintf("%v  %2ds  %v", code, remaining, names[i]))
+		}
+		return out, nil
+	}
+	if jsonOutput() {
+		if totpWatch {
+			return errors.New(ErrWatchWithJSON)
+		}
+		now := time.Now()
+		codes := make([]totpCode, len(keys))
+		for i, k := range keys {
+			code, err := k.Code(now)
+			if err != nil {
+				return err
+			}
+			codes[i] = totpCode{Name: names[i], Code: code, RemainingSeconds: int(k.Remaining(now).Seconds() + 0.5)}
+		}
+		return setReport(codes)
+	}
+	if !totpWatch {
+		if len(keys) == 1 {
+			// a single code is printed alone for scripts
+			code, err := keys[0].Code(time.Now())
+			if err != nil {
+				return err
+			}
+			fmt.Println(code)
+			return nil
+		}
+		out, err := lines(time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(out, "\n"))
+		return nil
+	}
+
+	for first := true; ; first = false {
+		out, err := lines(time.Now())
+		if err != nil {
+			return err
+		}
+		if !first {
+			// move back up and redraw the previous codes in place
+			fmt.Printf("\033[%dA", len(out))
+		}
+		for _, line := range out {
+			fmt.Printf("\r\033[K%v\n", line)
+		}
+		time.Sleep(time.Second - time.Duration(time.Now().Nanosecond()))
+	}
+}
+
+func BuildPwnedIndexController(source, index string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(index)
+	if err != nil {
+		return err
+	}
+	n, err := portwarden.BuildPwnedIndex(in, out)
+	if err != nil {
+		out.Close()
+		os.Remove(index)
+		return err
+	}
+	fmt.Printf("indexed %v hashes into %v\n", n, index)
+	if err := out.Close(); err != nil {
+		return err
+	}
+	wroteFile(index)
+	result.Counts["hashes"] = int(n)
+	return nil
+}
+
+func ImportController(fileName, passphrase, format, input string) error {
+	data, err := ioutil.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	var b *portwarden.Backup
+	switch format {
+	case portwarden.ImportFormatKeePass:
+		var keyFile []byte
+		if len(kdbxKeyFile) > 0 {
+			if keyFile, err = ioutil.ReadFile(kdbxKeyFile); err != nil {
+				return err
+			}
+		}
+		b, err = portwarden.ImportKeePass(data, kdbxPassphrase, keyFile)
+	case portwarden.ImportFormatLastPassCSV:
+		b, err = portwarden.ImportLastPassCSV(bytes.NewReader(data))
+	case portwarden.ImportFormat1PUX:
+		b, err = portwarden.Import1PUX(data)
+	default:
+		return fmt.Errorf("%v: %q", ErrUnknownImportFormat, format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %v items and %v folders\n", len(b.Items), len(b.Folders))
+	result.Counts[portwarden.StageItems] = len(b.Items)
+	result.Counts[portwarden.StageFolders] = len(b.Folders)
+	if err := portwarden.WriteBackupFile(fileName, passphrase, b, profileKDF()); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(fileName, ".portwarden") {
+		fileName += ".portwarden"
+	}
+	wroteFile(fileName)
+	return nil
+}
+
+// writeExportFile creates output, or defaultOutput if it's empty, readable
+// only by the user since exports aren't encrypted.
+func writeExportFile(output, defaultOutput string, write func(w io.Writer) error) error {
+	if len(output) == 0 {
+		output = defaultOutput
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println("wrote", output)
+	wroteFile(output)
+	return nil
+}
+
+func RestoreOptionsFromFlags() (portwarden.RestoreOptions, error) {
+	opts := portwarden.RestoreOptions{
+		OrganizationID:    organizationID,
+		DefaultCollection: defaultCollection,
+		Verify:            verifyRestore,
+	}
+	cm, err := portwarden.ParseCollectionMap(collectionMap)
+	if err != nil {
+		return opts, err
+	}
+	if len(collectionMapFile) > 0 {
+		fileMap, err := portwarden.ReadCollectionMapFile(collectionMapFile)
+		if err != nil {
+			return opts, err
+		}
+		for old, target := range fileMap {
+			if _, ok := cm[old]; !ok {
+				cm[old] = target
+			}
+		}
+	}
+	if len(cm) > 0 && len(organizationID) == 0 {
+		return opts, errors.New(ErrCollectionMapWithoutOrganization)
+	}
+	opts.CollectionMap = cm
+	if len(deletionPlan) > 0 {
+		if opts.SkipItemIDs, err = portwarden.ReadDeletionPlanFile(deletionPlan); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// BWGetSessionKey returns the session key of BW_SESSION, or unlocks the
+// vault, logging in first if needed. With a master password from
+// --password-fd, --password-env or the profile nothing prompts: the login
+// uses the API key of BW_CLIENTID and BW_CLIENTSECRET, or else the account
+// of the profile.
+func BWGetSessionKey() (string, error) {
+	if sessionKey := os.Getenv(portwarden.BWSessionEnv); len(sessionKey) > 0 {
+		// the session belongs to whoever unlocked it, so keep it alive
+		noLogout = true
+		return sessionKey, nil
+	}
+	if profile != nil && len(profile.Server) > 0 {
+		if err := portwarden.BWConfigServer(profile.Server); err != nil {
+			return "", err
+		}
+	}
+	apiKey, err := portwarden.HasAPIKey()
+	if err != nil {
+		return "", err
+	}
+	password, err := masterPassword()
+	if err != nil {
+		return "", err
+	}
+	if len(password) > 0 {
+		return BWUnattendedGetSessionKey(password, apiKey)
+	}
+	sessionKey, err := BWUnlockVaultToGetSessionKey()
+	if err != nil {
+		if err.Error() == portwarden.BWErrNotLoggedIn && apiKey {
+			if err := portwarden.BWLoginWithAPIKey(); err != nil {
+				return "", err
+			}
+			return BWUnlockVaultToGetSessionKey()
+		}
+		if err.Error() == portwarden.BWErrNotLoggedIn {
+			sessionKey, err = BWLoginGetSessionKey()
+			if err != nil {
+				return "", err
+			}
+		} else {
+			return "", err
+		}
+	}
+	return sessionKey, err
+}
+
+// BWUnattendedGetSessionKey unlocks the vault with password, logging in
+// with the API key or the account of the profile first if needed.
+func BWUnattendedGetSessionKey(password string, apiKey bool) (string, error) {
+	sessionKey, err := portwarden.BWUnlockWithPassword(password)
+	if err == nil || err.Error() != portwarden.BWErrNotLoggedIn {
+		return sessionKey, err
+	}
+	if apiKey {
+		if err := portwarden.BWLoginWithAPIKey(); err != nil {
+			return "", err
+		}
+		return portwarden.BWUnlockWithPassword(password)
+	}
+	if profile == nil || len(profile.Account) == 0 {
+		return "", errors.New(portwarden.ErrLoginNeedsAccount)
+	}
+	return portwarden.BWLoginWithPassword(profile.Account, password)
+}
+
+// masterPassword reads the master password from --password-fd,
+// --password-env or the profile, and returns "" without any of them.
+func masterPassword() (string, error) {
+	var password string
+	var err error
+	switch {
+	case passwordFD >= 0:
+		if len(fdPassword) == 0 {
+			fdPassword, err = portwarden.ReadPasswordFD(passwordFD)
+		}
+		password = fdPassword
+	case len(passwordEnv) > 0:
+		password, err = portwarden.SecretSource{Env: passwordEnv}.Read()
+	case profile != nil:
+		password, err = profile.MasterPassword.Read()
+	}
+	if err != nil {
+		return "", fmt.Errorf("master password: %v", err)
+	}
+	return password, nil
+}
+
+func BWUnlockVaultToGetSessionKey() (string, error) {
+	cmd := exec.Command("bw", "unlock")
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Start(); err != nil {
+		fmt.Println("An error occurred: ", err)
+	}
+	cmd.Wait()
+	sessionKey, err := portwarden.ExtractSessionKey(stdout.String())
+	if err != nil {
+		return "", errors.New(string(stderr.Bytes()))
+	}
+	return sessionKey, nil
+}
+
+func BWLoginGetSessionKey() (string, error) {
+	args := []string{"login"}
+	if profile != nil && len(profile.Account) > 0 {
+		args = append(args, profile.Account)
+	}
+	cmd := exec.Command("bw", args...)
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = os.Stderr
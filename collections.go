@@ -0,0 +1,231 @@
+package portwarden
+
+import (
+	"bufio"
+	"bytes"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	ErrInvalidCollectionMapping = "invalid collection mapping, expected old=new"
+
+	DefaultRestoreCollectionName = "Portwarden Restore"
+)
+
+// RestoreOptions controls where RestoreBackupFile puts the restored items and
+// whether it checks them afterwards. The zero value restores everything into
+// the personal vault.
+type RestoreOptions struct {
+	// OrganizationID restores the items as ciphers of that organization
+	OrganizationID string
+	// CollectionMap maps collection ids or names of the backup to collection
+	// ids or names of the target organization. Collections of the backup
+	// that aren't mapped keep their name.
+	CollectionMap map[string]string
+	// DefaultCollection is the collection id or name used for items that
+	// don't belong to any collection, since organization ciphers need one.
+	DefaultCollection string
+	// Verify re-lists the vault after the restore and compares every
+	// restored item with the backup
+	Verify bool
+	// SkipItemIDs are items of the backup not to restore, e.g. the
+	// duplicates of a deletion plan
+	SkipItemIDs map[string]bool
+}
+
+// ParseCollectionMap parses `old=new` mappings as given to --collection-map.
+func ParseCollectionMap(mappings []string) (map[string]string, error) {
+	cm := make(map[string]string)
+	for _, mapping := range mappings {
+		mapping = strings.TrimSpace(mapping)
+		if len(mapping) == 0 || strings.HasPrefix(mapping, "#") {
+			continue
+		}
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("%v: %q", ErrInvalidCollectionMapping, mapping)
+		}
+		cm[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return cm, nil
+}
+
+// ReadCollectionMapFile reads a mapping file with one `old=new` per line.
+// Empty lines and lines starting with # are ignored.
+func ReadCollectionMapFile(fileName string) (map[string]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mappings []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		mappings = append(mappings, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseCollectionMap(mappings)
+}
+
+func BWListCollectionsRawBytes(sessionKey string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("bw", "list", "collections", "--session", sessionKey)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func BWListOrganizationCollections(organizationID, sessionKey string) (PortWardenCollection, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("bw", "list", "collections", "--organizationid", organizationID, "--session", sessionKey)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(stderr.String())
+	}
+	collections := PortWardenCollection{}
+	if err := json.Unmarshal(stdout.Bytes(), &collections); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+func BWListOrganizationItemsRawBytes(organizationID, sessionKey string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("bw", "list", "items", "--organizationid", organizationID, "--session", sessionKey)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func BWCreateOrganizationCollection(organizationID, name, sessionKey string) (PortWardenCollectionElement, error) {
+	newCollection := PortWardenCollectionElement{}
+	collectionBytes, err := json.Marshal(map[string]interface{}{
+		"organizationId": organizationID,
+		"name":           name,
+		"externalId":     nil,
+		"groups":         []interface{}{},
+	})
+	if err != nil {
+		return newCollection, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("bw", "create", "org-collection", "--organizationid", organizationID, "--session", sessionKey, b64.StdEncoding.EncodeToString(collectionBytes))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return newCollection, errors.New(stderr.String())
+	}
+	err = json.Unmarshal(stdout.Bytes(), &newCollection)
+	return newCollection, err
+}
+
+// collectionResolver turns collection ids of the backup into collection ids
+// of the target organization, creating missing collections on the way.
+type collectionResolver struct {
+	opts       RestoreOptions
+	sessionKey string
+	backup     map[string]string // backup collection id -> name
+	targetIDs  map[string]bool
+	targetName map[string]string // target collection name -> id
+}
+
+func newCollectionResolver(opts RestoreOptions, sessionKey string, backupCollections PortWardenCollection) (*collectionResolver, error) {
+	cr := &collectionResolver{
+		opts:       opts,
+		sessionKey: sessionKey,
+		backup:     make(map[string]string),
+		targetIDs:  make(map[string]bool),
+		targetName: make(map[string]string),
+	}
+	for _, c := range backupCollections {
+		cr.backup[c.ID] = c.Name
+	}
+	targetCollections, err := BWListOrganizationCollections(opts.OrganizationID, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range targetCollections {
+		cr.targetIDs[c.ID] = true
+		cr.targetName[c.Name] = c.ID
+	}
+	return cr, nil
+}
+
+// Resolve returns the target collection ids for an item of the backup that
+// belonged to oldIDs.
+func (cr *collectionResolver) Resolve(oldIDs []string) ([]string, error) {
+	var targets []string
+	for _, oldID := range oldIDs {
+		target, ok := cr.opts.CollectionMap[oldID]
+		if !ok {
+			target, ok = cr.opts.CollectionMap[cr.backup[oldID]]
+		}
+		if !ok {
+			target = cr.backup[oldID]
+		}
+		if len(target) == 0 {
+			Progress.Warn(fmt.Sprintf("skipping unknown collection %v; map it with --collection-map", oldID))
+			continue
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		target := cr.opts.DefaultCollection
+		if len(target) == 0 {
+			target = DefaultRestoreCollectionName
+		}
+		targets = append(targets, target)
+	}
+
+	newIDs := []string{}
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		newID, err := cr.targetID(target)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[newID] {
+			seen[newID] = true
+			newIDs = append(newIDs, newID)
+		}
+	}
+	return newIDs, nil
+}
+
+func (cr *collectionResolver) targetID(target string) (string, error) {
+	if cr.targetIDs[target] {
+		return target, nil
+	}
+	if id, ok := cr.targetName[target]; ok {
+		return id, nil
+	}
+	Progress.Info("creating collection " + target)
+	newCollection, err := BWCreateOrganizationCollection(cr.opts.OrganizationID, target, cr.sessionKey)
+	if err != nil {
+		return "", err
+	}
+	cr.targetIDs[newCollection.ID] = true
+	cr.targetName[target] = newCollection.ID
+	return newCollection.ID, nil
+}
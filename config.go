@@ -0,0 +1,398 @@
+package portwarden
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	ConfigDirName       = "portwarden"
+	ConfigFileName      = "config.yaml"
+	DefaultNameTemplate = "portwarden_{{.Profile}}_{{.Date}}_{{.Time}}"
+
+	ErrUnknownProfile           = "no such profile in the config file"
+	ErrNoConfigDir              = "neither XDG_CONFIG_HOME nor HOME is set"
+	ErrAmbiguousSecretSource    = "set only one of file, env and command"
+	ErrEmptySecretSource        = "the file, env or command gave an empty secret"
+	ErrKDFIterationsTooLow      = "kdf iterations must be at least 4096"
+	ErrKDFIterationsTooHigh     = "kdf iterations must be at most 10000000"
+	ErrBWConfigServerNeedLogout = "log out of the Bitwarden CLI before switching to the server of another profile"
+)
+
+// Config is the config file of the CLI, a set of named profiles.
+//
+//	default_profile: home
+//	profiles:
+//	  home:
+//	    server: https://vault.example.com
+//	    account: me@example.com
+//	    output_dir: ~/backups/bitwarden
+//	    name_template: "vault_{{.Date}}"
+//	    passphrase:
+//	      command: pass show portwarden
+type Config struct {
+	DefaultProfile string              `yaml:"default_profile"`
+	Profiles       map[string]*Profile `yaml:"profiles"`
+}
+
+// Profile holds the settings of one Bitwarden account and where its backups
+// go. Flags given on the command line win over the profile.
+type Profile struct {
+	// Name is the key of the profile in the config file
+	Name string `yaml:"-"`
+	// Server is the URL of a self hosted Bitwarden server
+	Server  string `yaml:"server"`
+	Account string `yaml:"account"`
+	// OutputDir is where encrypt writes backups named after NameTemplate
+	OutputDir string `yaml:"output_dir"`
+	// NameTemplate is a text/template for the backup file name with
+	// .Profile, .Account, .Date (2006-01-02) and .Time (15-04-05)
+	NameTemplate string      `yaml:"name_template"`
+	KDF          KDFSettings `yaml:"kdf"`
+	// Destinations are more directories every backup is copied to
+	Destinations []string `yaml:"destinations"`
+	// Passphrase encrypts the backups
+	Passphrase SecretSource `yaml:"passphrase"`
+	// MasterPassword unlocks the vault, and logs Account in unless an API
+	// key is in the environment, without prompting
+	MasterPassword SecretSource `yaml:"master_password"`
+	// Retention prunes the backups of the profile after each backup
+	Retention RetentionPolicy `yaml:"retention"`
+	// Schedule is when `portwarden daemon` backs the profile up, see
+	// ParseSchedule
+	Schedule          string  `yaml:"schedule"`
+	Rate              float64 `yaml:"rate"`
+	Concurrency       int     `yaml:"concurrency"`
+	SleepMilliseconds int     `yaml:"sleep_milliseconds"`
+}
+
+// KDFSettings tune how the backup key is derived from the passphrase. The
+// backup records them in its header, so decrypting it doesn't need them.
+type KDFSettings struct {
+	// Iterations of PBKDF2-SHA256, KeyDerivationIterations by default
+	Iterations int `yaml:"iterations"`
+}
+
+// SecretSource tells where a secret like the backup passphrase comes from
+// instead of a flag, which ends up in the shell history and ps: a file, an
+// environment variable or the output of a command.
+type SecretSource struct {
+	File    string `yaml:"file"`
+	Env     string `yaml:"env"`
+	Command string `yaml:"command"`
+}
+
+// ConfigPath returns $XDG_CONFIG_HOME/portwarden/config.yaml, falling back
+// to ~/.config as the XDG spec says.
+func ConfigPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if len(dir) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.New(ErrNoConfigDir)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, ConfigDirName, ConfigFileName), nil
+}
+
+func ReadConfigFile(fileName string) (*Config, error) {
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(file, c); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	for name, p := range c.Profiles {
+		if p == nil {
+			p = &Profile{}
+			c.Profiles[name] = p
+		}
+		p.Name = name
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%v: profile %v: %v", fileName, name, err)
+		}
+	}
+	return c, nil
+}
+
+// Profile returns the profile called name, or the default profile when name
+// is empty. It returns nil without an error if there is no default.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if len(name) == 0 {
+		name = c.DefaultProfile
+		if len(name) == 0 {
+			return nil, nil
+		}
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%v: %v, expected one of %v", ErrUnknownProfile, name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+func (p *Profile) validate() error {
+	if err := p.Passphrase.validate(); err != nil {
+		return fmt.Errorf("passphrase: %v", err)
+	}
+	if err := p.MasterPassword.validate(); err != nil {
+		return fmt.Errorf("master_password: %v", err)
+	}
+	if p.KDF.Iterations != 0 && p.KDF.Iterations < KeyDerivationIterations {
+		return errors.New(ErrKDFIterationsTooLow)
+	}
+	if p.KDF.Iterations > MaxKDFIterations {
+		return errors.New(ErrKDFIterationsTooHigh)
+	}
+	if _, err := template.New("name").Parse(p.nameTemplate()); err != nil {
+		return err
+	}
+	if len(p.Schedule) > 0 {
+		if _, err := ParseSchedule(p.Schedule); err != nil {
+			return fmt.Errorf("schedule: %v", err)
+		}
+	}
+	return nil
+}
+
+// IsSet tells whether a source is configured.
+func (s SecretSource) IsSet() bool {
+	return len(s.File) > 0 || len(s.Env) > 0 || len(s.Command) > 0
+}
+
+func (s SecretSource) validate() error {
+	sources := 0
+	for _, source := range []string{s.File, s.Env, s.Command} {
+		if len(source) > 0 {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New(ErrAmbiguousSecretSource)
+	}
+	return nil
+}
+
+// Read reads the secret from the source. A trailing newline is dropped.
+func (s SecretSource) Read() (string, error) {
+	if err := s.validate(); err != nil {
+		return "", err
+	}
+	var secret string
+	switch {
+	case len(s.File) > 0:
+		file, err := ioutil.ReadFile(ExpandHome(s.File))
+		if err != nil {
+			return "", err
+		}
+		secret = string(file)
+	case len(s.Env) > 0:
+		secret = os.Getenv(s.Env)
+	case len(s.Command) > 0:
+		var err error
+		if secret, err = runSecretCommand(s.Command); err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	secret = strings.TrimRight(secret, "\r\n")
+	if len(secret) == 0 {
+		return "", errors.New(ErrEmptySecretSource)
+	}
+	return secret, nil
+}
+
+// runSecretCommand runs command through the shell. Its stdin and stderr
+// stay attached so that it can ask for a PIN or touch of a key.
+func runSecretCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %v", command, err)
+	}
+	return stdout.String(), nil
+}
+
+func (p *Profile) nameTemplate() string {
+	if len(p.NameTemplate) == 0 {
+		return DefaultNameTemplate
+	}
+	return p.NameTemplate
+}
+
+// BackupFileName returns where encrypt writes a backup made at t: the name
+// template in the output directory, with the .portwarden extension.
+func (p *Profile) BackupFileName(t time.Time) (string, error) {
+	fileName, err := p.executeNameTemplate(t.Format("2006-01-02"), t.Format("15-04-05"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ExpandHome(p.OutputDir), fileName), nil
+}
+
+// BackupFilePattern matches the names BackupFileName gives, so that pruning
+// leaves the backups of other profiles in the same directory alone.
+func (p *Profile) BackupFilePattern() (*regexp.Regexp, error) {
+	// placeholders QuoteMeta leaves as they are
+	const date, clock = "\x00date\x00", "\x00time\x00"
+	fileName, err := p.executeNameTemplate(date, clock)
+	if err != nil {
+		return nil, err
+	}
+	pattern := regexp.QuoteMeta(fileName)
+	pattern = strings.Replace(pattern, date, `\d{4}-\d{2}-\d{2}`, -1)
+	pattern = strings.Replace(pattern, clock, `\d{2}-\d{2}-\d{2}`, -1)
+	return regexp.Compile("^" + pattern + "$")
+}
+
+func (p *Profile) executeNameTemplate(date, clock string) (string, error) {
+	tmpl, err := template.New("name").Parse(p.nameTemplate())
+	if err != nil {
+		return "", err
+	}
+	var name bytes.Buffer
+	err = tmpl.Execute(&name, map[string]string{
+		"Profile": p.Name,
+		"Account": p.Account,
+		"Date":    date,
+		"Time":    clock,
+	})
+	if err != nil {
+		return "", err
+	}
+	fileName := name.String()
+	if !strings.HasSuffix(fileName, ".portwarden") {
+		fileName += ".portwarden"
+	}
+	return fileName, nil
+}
+
+// BackupDirs returns the output directory and the destinations of the
+// profile, the directories its retention policy applies to.
+func (p *Profile) BackupDirs() []string {
+	dirs := []string{ExpandHome(p.OutputDir)}
+	if len(p.OutputDir) == 0 {
+		dirs[0] = "."
+	}
+	for _, dir := range p.Destinations {
+		dirs = append(dirs, ExpandHome(dir))
+	}
+	return dirs
+}
+
+// iterations returns the PBKDF2 iterations of the settings.
+func (kdf KDFSettings) iterations() int {
+	if kdf.Iterations > 0 {
+		return kdf.Iterations
+	}
+	return KeyDerivationIterations
+}
+
+// BWAppDataDir returns a Bitwarden CLI data directory of the profile's own,
+// ~/.cache/portwarden/bw/<profile> on Linux, so that the login of a daemon
+// neither clashes with other profiles nor with the user's own `bw`.
+func (p *Profile) BWAppDataDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ConfigDirName, "bw", p.Name), nil
+}
+
+// CopyToDestinations copies the backup fileName into the destinations of
+// the profile and returns the copies.
+func (p *Profile) CopyToDestinations(fileName string) ([]string, error) {
+	var copies []string
+	for _, dir := range p.Destinations {
+		dir = ExpandHome(dir)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return copies, err
+		}
+		target := filepath.Join(dir, filepath.Base(fileName))
+		if err := copyFile(fileName, target); err != nil {
+			return copies, err
+		}
+		copies = append(copies, target)
+	}
+	return copies, nil
+}
+
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ExpandHome replaces a leading ~ with the home directory.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// BWConfigServer points the Bitwarden CLI at server unless it already is,
+// which the CLI only allows while logged out.
+func BWConfigServer(server string) error {
+	current, err := bwRun("config", "server")
+	if err != nil {
+		return err
+	}
+	if strings.TrimRight(strings.TrimSpace(string(current)), "/") == strings.TrimRight(server, "/") {
+		return nil
+	}
+	if _, err := bwRun("config", "server", server); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "logout required") {
+			return errors.New(ErrBWConfigServerNeedLogout)
+		}
+		return err
+	}
+	Progress.Info("using the Bitwarden server " + server)
+	return nil
+}
@@ -11,14 +11,14 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	b64 "encoding/base64"
-	"github.com/davecgh/go-spew/spew"
 
 	"github.com/mholt/archiver"
 	"github.com/tidwall/pretty"
@@ -42,8 +42,9 @@ const (
 	LoginCredentialMethodEmail         = 1
 	LoginCredentialMethodYubikey       = 3
 
-	ItemsJsonFileName   = "items.json"
-	FoldersJSONFileName = "folders.json"
+	ItemsJsonFileName       = "items.json"
+	FoldersJSONFileName     = "folders.json"
+	CollectionsJSONFileName = "collections.json"
 )
 
 // LoginCredentials is used to login to the `bw` cli. See documentation
@@ -60,16 +61,16 @@ type LoginCredentials struct {
 	Code     string `json:"code"`
 }
 
-func CreateBackupBytesUsingBitwardenLocalJSON(dataJson []byte, BITWARDENCLI_APPDATA_DIR, passphrase, sessionKey string, sleepMilliseconds int) ([]byte, error) {
+func CreateBackupBytesUsingBitwardenLocalJSON(dataJson []byte, BITWARDENCLI_APPDATA_DIR, passphrase, sessionKey string, pacer *Pacer) ([]byte, error) {
 	// Put data.json in the BITWARDENCLI_APPDATA_DIR
 	defer BWDelete(BITWARDENCLI_APPDATA_DIR)
 	if err := ioutil.WriteFile(filepath.Join(BITWARDENCLI_APPDATA_DIR, "data.json"), dataJson, 0644); err != nil {
 		return nil, err
 	}
-	return CreateBackupBytes(passphrase, sessionKey, sleepMilliseconds)
+	return CreateBackupBytes(passphrase, sessionKey, pacer, KDFSettings{})
 }
 
-func CreateBackupFile(fileName, passphrase, sessionKey string, sleepMilliseconds int, noLogout bool) error {
+func CreateBackupFile(fileName, passphrase, sessionKey string, pacer *Pacer, noLogout bool, kdf KDFSettings) error {
 	if !noLogout {
 		defer BWLogout()
 	}
@@ -81,7 +82,7 @@ func CreateBackupFile(fileName, passphrase, sessionKey string, sleepMilliseconds
 		return err
 	}
 	defer f.Close()
-	encryptedData, err := CreateBackupBytes(passphrase, sessionKey, sleepMilliseconds)
+	encryptedData, err := CreateBackupBytes(passphrase, sessionKey, pacer, kdf)
 	if err != nil {
 		return err
 	}
@@ -89,13 +90,14 @@ func CreateBackupFile(fileName, passphrase, sessionKey string, sleepMilliseconds
 	return nil
 }
 
-func CreateBackupBytes(passphrase, sessionKey string, sleepMilliseconds int) ([]byte, error) {
+func CreateBackupBytes(passphrase, sessionKey string, pacer *Pacer, kdf KDFSettings) ([]byte, error) {
 	if err := os.MkdirAll(BackupFolderName, os.ModePerm); err != nil {
 		return nil, err
 	}
 	defer os.RemoveAll(BackupFolderName)
 
 	// save formmated json to FoldersJSONFileName
+	Progress.Stage(StageFolders, -1)
 	rawByte, err := BWListFoldersRawBytes(sessionKey)
 	if err != nil {
 		return nil, err
@@ -104,8 +106,41 @@ func CreateBackupBytes(passphrase, sessionKey string, sleepMilliseconds int) ([]
 	if err := ioutil.WriteFile(BackupFolderName+FoldersJSONFileName, formattedByte, 0644); err != nil {
 		return nil, err
 	}
+	folders := PortWardenFolder{}
+	if err := json.Unmarshal(rawByte, &folders); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, folder := range folders {
+		// the folder without an id stands for the items in none
+		if folder.ID != nil {
+			names = append(names, folder.Name)
+		}
+	}
+	reportListed(StageFolders, names)
+
+	// save formmated json to CollectionsJSONFileName
+	Progress.Stage(StageCollections, -1)
+	rawByte, err = BWListCollectionsRawBytes(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	formattedByte = pretty.Pretty(rawByte)
+	if err := ioutil.WriteFile(BackupFolderName+CollectionsJSONFileName, formattedByte, 0644); err != nil {
+		return nil, err
+	}
+	collections := PortWardenCollection{}
+	if err := json.Unmarshal(rawByte, &collections); err != nil {
+		return nil, err
+	}
+	names = nil
+	for _, collection := range collections {
+		names = append(names, collection.Name)
+	}
+	reportListed(StageCollections, names)
 
 	// save formmated json to ItemsJsonFileName
+	Progress.Stage(StageItems, -1)
 	rawByte, err = BWListItemsRawBytes(sessionKey)
 	if err != nil {
 		return nil, err
@@ -114,17 +149,31 @@ func CreateBackupBytes(passphrase, sessionKey string, sleepMilliseconds int) ([]
 	if err := ioutil.WriteFile(BackupFolderName+ItemsJsonFileName, formattedByte, 0644); err != nil {
 		return nil, err
 	}
-
-	// download attachments
 	pwes := []PortWardenElement{}
 	if err := json.Unmarshal(rawByte, &pwes); err != nil {
 		return nil, err
 	}
-	err = BWGetAllAttachments(BackupFolderName, sessionKey, pwes, sleepMilliseconds)
+	names = nil
+	for _, item := range pwes {
+		names = append(names, item.Name)
+	}
+	reportListed(StageItems, names)
+
+	// download attachments
+	err = BWGetAllAttachments(BackupFolderName, sessionKey, pwes, pacer)
 	if err != nil {
 		return nil, err
 	}
 
+	// save the manifest describing where attachments are stored
+	manifestBytes, err := json.Marshal(NewManifest(pwes))
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(BackupFolderName+ManifestJSONFileName, pretty.Pretty(manifestBytes), 0644); err != nil {
+		return nil, err
+	}
+
 	var b bytes.Buffer
 	writer := bufio.NewWriter(&b)
 	err = archiver.Zip.Write(writer, []string{BackupFolderName})
@@ -133,7 +182,7 @@ func CreateBackupBytes(passphrase, sessionKey string, sleepMilliseconds int) ([]
 	}
 
 	// derive a key from the master password
-	encryptedBytes, err := EncryptBytes(b.Bytes(), passphrase)
+	encryptedBytes, err := EncryptBytesWithKDF(b.Bytes(), passphrase, kdf)
 	if err != nil {
 		return nil, err
 	}
@@ -148,17 +197,15 @@ func DecryptBackupFile(fileName, passphrase string) error {
 	}
 	tb, err := DecryptBytes(rawBytes, passphrase)
 	if err != nil {
-		fmt.Println("decryption failed: " + err.Error())
 		return err
 	}
 	if err := ioutil.WriteFile(fileName+".decrypted"+".zip", tb, 0644); err != nil {
-		fmt.Println("decryption failed: " + err.Error())
 		return err
 	}
 	return nil
 }
 
-func RestoreBackupFile(fileName, passphrase, sessionKey string, sleepMilliseconds int, noLogout bool) error {
+func RestoreBackupFile(fileName, passphrase, sessionKey string, pacer *Pacer, noLogout bool, opts RestoreOptions) error {
 	// dummy check if the account is not empty, don't restore
 	var err error
 	var rawByte []byte
@@ -175,7 +222,11 @@ func RestoreBackupFile(fileName, passphrase, sessionKey string, sleepMillisecond
 	defer os.RemoveAll(BackupFolderName)
 	defer os.Remove(fileName + ".decrypted" + ".zip")
 
-	rawByte, err = BWListItemsRawBytes(sessionKey)
+	if len(opts.OrganizationID) > 0 {
+		rawByte, err = BWListOrganizationItemsRawBytes(opts.OrganizationID, sessionKey)
+	} else {
+		rawByte, err = BWListItemsRawBytes(sessionKey)
+	}
 	if err != nil {
 		return err
 	}
@@ -196,31 +247,63 @@ func RestoreBackupFile(fileName, passphrase, sessionKey string, sleepMillisecond
 	if err != nil {
 		return err
 	}
+	// folders go first so that items can be put into them
 	oldToNewFolderID := make(map[string]string)
-	var itemBytes []byte
+	var mu sync.Mutex
+	folderCount := 0
 	for _, item := range folderData {
-		time.Sleep(time.Millisecond * time.Duration(sleepMilliseconds))
 		if item.ID != nil {
-			itemBytes, err = json.Marshal(item)
-			cmd := exec.Command("bw", "create", "folder", "--session", sessionKey, b64.StdEncoding.EncodeToString(itemBytes))
-			var stdout bytes.Buffer
-			var stderr bytes.Buffer
-			cmd.Stdout = &stdout
-			cmd.Stderr = &stderr
-			cmd.Stdin = os.Stdin
-			if err := cmd.Run(); err != nil {
-				fmt.Println("An error occurred: ", err)
-				spew.Dump(stdout, stderr)
-			}
-			fmt.Println("restoring folder", item.Name)
-			newItem := PortWardenFolderElement{}
-			err = json.Unmarshal(stdout.Bytes(), &newItem)
-			if err != nil {
-				return err
-			}
-			oldToNewFolderID[*item.ID] = *newItem.ID
+			folderCount++
 		}
 	}
+	Progress.Stage(StageFolders, folderCount)
+	// newFolderIDs are the folders restored so far, which a lost create
+	// mustn't take for its own
+	newFolderIDs := make(map[string]bool)
+	restoredFolder := func(item PortWardenFolderElement, newID string) {
+		mu.Lock()
+		oldToNewFolderID[*item.ID] = newID
+		newFolderIDs[newID] = true
+		mu.Unlock()
+		Progress.Step(item.Name)
+	}
+	err = pacer.RunCreates(len(folderData), func(i int) error {
+		item := folderData[i]
+		if item.ID == nil {
+			return nil
+		}
+		itemBytes, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		stdout, err := bwRun("create", "folder", "--session", sessionKey, b64.StdEncoding.EncodeToString(itemBytes))
+		if err != nil {
+			return err
+		}
+		newItem := PortWardenFolderElement{}
+		if err := json.Unmarshal(stdout, &newItem); err != nil {
+			return err
+		}
+		restoredFolder(item, *newItem.ID)
+		return nil
+	}, func(i int) (bool, error) {
+		item := folderData[i]
+		ids, err := bwFindFolders(item.Name, sessionKey)
+		if err != nil {
+			return false, err
+		}
+		mu.Lock()
+		newID := firstNotIn(ids, newFolderIDs)
+		mu.Unlock()
+		if len(newID) == 0 {
+			return false, nil
+		}
+		restoredFolder(item, newID)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
 
 	// restore items
 	if file, err = ioutil.ReadFile(BackupFolderName + ItemsJsonFileName); err != nil {
@@ -231,37 +314,99 @@ func RestoreBackupFile(fileName, passphrase, sessionKey string, sleepMillisecond
 	if err != nil {
 		return err
 	}
+	if len(opts.SkipItemIDs) > 0 {
+		restored := withoutItems(itemData, opts.SkipItemIDs)
+		Progress.Info(fmt.Sprintf("skipping %v items of the deletion plan", len(itemData)-len(restored)))
+		itemData = restored
+	}
+	var collections *collectionResolver
+	if len(opts.OrganizationID) > 0 {
+		backupCollections := PortWardenCollection{}
+		if file, err = ioutil.ReadFile(BackupFolderName + CollectionsJSONFileName); err == nil {
+			if err := json.Unmarshal(file, &backupCollections); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		collections, err = newCollectionResolver(opts, sessionKey, backupCollections)
+		if err != nil {
+			return err
+		}
+	}
 	oldToNewItemID := make(map[string]string)
-	for _, item := range itemData {
-		time.Sleep(time.Millisecond * time.Duration(sleepMilliseconds))
+	newItemIDs := make(map[string]bool)
+	restoredItem := func(item PortWardenElement, newID string) {
+		mu.Lock()
+		oldToNewItemID[item.ID] = newID
+		newItemIDs[newID] = true
+		mu.Unlock()
+		Progress.Step(item.Name)
+	}
+	// restoredItems holds the items as they are sent to create, so that a
+	// lost create can be looked for in their folder
+	restoredItems := make([]PortWardenElement, len(itemData))
+	Progress.Stage(StageItems, len(itemData))
+	err = pacer.RunCreates(len(itemData), func(i int) error {
+		item := itemData[i]
 		// deal with attachments separately
 		item.Attachments = nil
 		if item.FolderID != nil {
-			*item.FolderID = oldToNewFolderID[*item.FolderID]
-		}
-		item.OrganizationID = nil
-		item.CollectionIDS = nil
-
-		itemBytes, err = json.Marshal(item)
-		cmd := exec.Command("bw", "create", "item", "--session", sessionKey, b64.StdEncoding.EncodeToString(itemBytes))
-		var stdout bytes.Buffer
-		var stderr bytes.Buffer
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
-		cmd.Stdin = os.Stdin
-		if err := cmd.Run(); err != nil {
-			fmt.Println("An error occurred: ", err)
-			spew.Dump(stdout, stderr)
-		}
-		fmt.Println("restoring item", item.Name)
-		newItem := PortWardenElement{}
-		err = json.Unmarshal(stdout.Bytes(), &newItem)
+			newFolderID := oldToNewFolderID[*item.FolderID]
+			item.FolderID = &newFolderID
+		}
+		if collections != nil {
+			mu.Lock()
+			collectionIDs, err := collections.Resolve(item.CollectionIDS)
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+			item.OrganizationID = &opts.OrganizationID
+			item.CollectionIDS = collectionIDs
+		} else {
+			item.OrganizationID = nil
+			item.CollectionIDS = nil
+		}
+
+		mu.Lock()
+		restoredItems[i] = item
+		mu.Unlock()
+
+		itemBytes, err := json.Marshal(item)
 		if err != nil {
 			return err
 		}
-		oldToNewItemID[item.ID] = newItem.ID
+		stdout, err := bwRun("create", "item", "--session", sessionKey, b64.StdEncoding.EncodeToString(itemBytes))
+		if err != nil {
+			return err
+		}
+		newItem := PortWardenElement{}
+		if err := json.Unmarshal(stdout, &newItem); err != nil {
+			return err
+		}
+		restoredItem(item, newItem.ID)
+		return nil
+	}, func(i int) (bool, error) {
+		mu.Lock()
+		item := restoredItems[i]
+		mu.Unlock()
+		ids, err := bwFindItems(item, sessionKey)
+		if err != nil {
+			return false, err
+		}
+		mu.Lock()
+		newID := firstNotIn(ids, newItemIDs)
+		mu.Unlock()
+		if len(newID) == 0 {
+			return false, nil
+		}
+		restoredItem(item, newID)
+		return true, nil
+	})
+	if err != nil {
+		return err
 	}
-	fmt.Println("restoring item finished")
 
 	// restore item's attachments
 	if file, err = ioutil.ReadFile(BackupFolderName + ItemsJsonFileName); err != nil {
@@ -272,24 +417,77 @@ func RestoreBackupFile(fileName, passphrase, sessionKey string, sleepMillisecond
 	if err != nil {
 		return err
 	}
-	for _, item := range itemData {
-		if len(item.Attachments) > 0 {
-			time.Sleep(time.Millisecond * time.Duration(sleepMilliseconds))
-			for _, innerItem := range item.Attachments {
-				itemBytes, err = json.Marshal(item)
-				cmd := exec.Command("bw", "create", "attachment", "--itemid", oldToNewItemID[item.ID], "--session", sessionKey, "--file", BackupFolderName+item.Name+"/"+innerItem.FileName)
-				var stdout bytes.Buffer
-				var stderr bytes.Buffer
-				cmd.Stdout = &stdout
-				cmd.Stderr = &stderr
-				cmd.Stdin = os.Stdin
-				if err := cmd.Run(); err != nil {
-					fmt.Println("An error occurred: ", err)
-					spew.Dump(stdout, stderr)
-				}
-				fmt.Println("restoring item's attachment", item.Name, innerItem.FileName)
-			}
+	itemData = withoutItems(itemData, opts.SkipItemIDs)
+	manifest, err := ReadManifest(BackupFolderName)
+	if err != nil {
+		return err
+	}
+	// attachments go last since they need the new item ids
+	attachments := itemAttachments(itemData)
+	Progress.Stage(StageAttachments, len(attachments))
+	// attached counts the attachments restored so far by new item id and
+	// file name, since a lost create can only be told by their number
+	attached := make(map[string]int)
+	attachmentKey := func(i int) string {
+		return oldToNewItemID[attachments[i].item.ID] + "/" + path.Base(attachments[i].attachment.FileName)
+	}
+	restoredAttachment := func(i int) {
+		mu.Lock()
+		attached[attachmentKey(i)]++
+		mu.Unlock()
+		Progress.Step(attachments[i].item.Name + "/" + attachments[i].attachment.FileName)
+	}
+	err = pacer.RunCreates(len(attachments), func(i int) error {
+		item, innerItem := attachments[i].item, attachments[i].attachment
+		_, err := bwRun("create", "attachment", "--itemid", oldToNewItemID[item.ID], "--session", sessionKey, "--file", BackupFolderName+manifest.AttachmentPath(item, innerItem))
+		if err != nil {
+			return err
+		}
+		restoredAttachment(i)
+		return nil
+	}, func(i int) (bool, error) {
+		item, innerItem := attachments[i].item, attachments[i].attachment
+		n, err := bwCountAttachments(oldToNewItemID[item.ID], path.Base(innerItem.FileName), sessionKey)
+		if err != nil {
+			return false, err
+		}
+		mu.Lock()
+		ok := n > attached[attachmentKey(i)]
+		mu.Unlock()
+		if ok {
+			restoredAttachment(i)
 		}
+		return ok, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.Verify {
+		Progress.Info("verifying restored items")
+		if err := BWSync(sessionKey); err != nil {
+			return err
+		}
+		if len(opts.OrganizationID) > 0 {
+			rawByte, err = BWListOrganizationItemsRawBytes(opts.OrganizationID, sessionKey)
+		} else {
+			rawByte, err = BWListItemsRawBytes(sessionKey)
+		}
+		if err != nil {
+			return err
+		}
+		liveItems := PortWarden{}
+		if err := json.Unmarshal(rawByte, &liveItems); err != nil {
+			return err
+		}
+		discrepancies := VerifyRestore(itemData, liveItems, oldToNewItemID, oldToNewFolderID)
+		for _, d := range discrepancies {
+			Progress.Warn(d.String())
+		}
+		if len(discrepancies) > 0 {
+			return errors.New(ErrRestoreVerificationFailed)
+		}
+		Progress.Info(fmt.Sprintf("verified %v restored items", len(itemData)))
 	}
 	return nil
 }
@@ -332,31 +530,122 @@ func BWListFoldersRawBytes(sessionKey string) ([]byte, error) {
 	return stdout.Bytes(), nil
 }
 
-func BWGetAttachment(outputDir, itemID, attachmentID, sessionKey string) error {
-	cmd := exec.Command("bw", "get", "attachment", attachmentID, "--itemid", itemID,
-		"--session", sessionKey, "--output", outputDir)
-	err := cmd.Run()
+// bwFindFolders returns the ids of the folders called name, after syncing
+// so that a folder whose create response got lost shows up.
+func bwFindFolders(name, sessionKey string) ([]string, error) {
+	if err := BWSync(sessionKey); err != nil {
+		return nil, err
+	}
+	stdout, err := bwRun("list", "folders", "--search", name, "--session", sessionKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	folders := PortWardenFolder{}
+	if err := json.Unmarshal(stdout, &folders); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, folder := range folders {
+		if folder.ID != nil && folder.Name == name {
+			ids = append(ids, *folder.ID)
+		}
+	}
+	return ids, nil
 }
 
-func BWGetAllAttachments(outputDir, sessionKey string, pws []PortWardenElement, sleepMilliseconds int) error {
+// bwFindItems returns the ids of the items with the name, type and folder
+// of item, after syncing like bwFindFolders.
+func bwFindItems(item PortWardenElement, sessionKey string) ([]string, error) {
+	if err := BWSync(sessionKey); err != nil {
+		return nil, err
+	}
+	args := []string{"list", "items", "--search", item.Name, "--session", sessionKey}
+	if item.OrganizationID != nil {
+		args = append(args, "--organizationid", *item.OrganizationID)
+	}
+	stdout, err := bwRun(args...)
+	if err != nil {
+		return nil, err
+	}
+	items := PortWarden{}
+	if err := json.Unmarshal(stdout, &items); err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, live := range items {
+		if live.Name == item.Name && live.Type == item.Type && stringValue(live.FolderID) == stringValue(item.FolderID) {
+			ids = append(ids, live.ID)
+		}
+	}
+	return ids, nil
+}
+
+// bwCountAttachments counts the attachments called fileName of the item
+// with itemID, after syncing like bwFindFolders.
+func bwCountAttachments(itemID, fileName, sessionKey string) (int, error) {
+	if err := BWSync(sessionKey); err != nil {
+		return 0, err
+	}
+	stdout, err := bwRun("get", "item", itemID, "--session", sessionKey)
+	if err != nil {
+		return 0, err
+	}
+	item := PortWardenElement{}
+	if err := json.Unmarshal(stdout, &item); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, attachment := range item.Attachments {
+		if attachment.FileName == fileName {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func firstNotIn(ids []string, taken map[string]bool) string {
+	for _, id := range ids {
+		if !taken[id] {
+			return id
+		}
+	}
+	return ""
+}
+
+func BWGetAttachment(outputDir, itemID, attachmentID, sessionKey string) error {
+	_, err := bwRun("get", "attachment", attachmentID, "--itemid", itemID,
+		"--session", sessionKey, "--output", outputDir)
+	return err
+}
+
+func BWGetAllAttachments(outputDir, sessionKey string, pws []PortWardenElement, pacer *Pacer) error {
+	attachments := itemAttachments(pws)
+	Progress.Stage(StageAttachments, len(attachments))
+	return pacer.Run(len(attachments), func(i int) error {
+		item, innerItem := attachments[i].item, attachments[i].attachment
+		ourputDir := path.Dir(outputDir + AttachmentPath(item.ID, innerItem.ID, innerItem.FileName))
+		err := BWGetAttachment(ourputDir+"/", item.ID, innerItem.ID, sessionKey)
+		if err != nil {
+			return fmt.Errorf("attachment %v (%v) of item %v (%v): %v", innerItem.FileName, innerItem.ID, item.Name, item.ID, err)
+		}
+		Progress.Step(item.Name + "/" + innerItem.FileName)
+		return nil
+	})
+}
+
+type itemAttachment struct {
+	item       PortWardenElement
+	attachment Attachment
+}
+
+func itemAttachments(pws []PortWardenElement) []itemAttachment {
+	var attachments []itemAttachment
 	for _, item := range pws {
-		if len(item.Attachments) > 0 {
-			for _, innerItem := range item.Attachments {
-				ourputDir := strings.TrimSpace(outputDir + item.Name) // Keep this line. See https://github.com/vwxyzjn/portwarden/issues/10
-				err := BWGetAttachment(ourputDir+"/", item.ID, innerItem.ID, sessionKey)
-				time.Sleep(time.Millisecond * time.Duration(sleepMilliseconds))
-				if err != nil {
-					spew.Dump(err, "failed item ids are ", item.ID, innerItem.ID, item.Name)
-					return err
-				}
-			}
+		for _, innerItem := range item.Attachments {
+			attachments = append(attachments, itemAttachment{item, innerItem})
 		}
 	}
-	return nil
+	return attachments
 }
 
 func BWLoginGetSessionKey(lc *LoginCredentials) (string, error) {
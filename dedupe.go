@@ -0,0 +1,514 @@
+package portwarden
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// DuplicateExact items share the domain, username and password
+	DuplicateExact = "exact"
+	// DuplicateNear items share the domain and username but not the password
+	DuplicateNear = "near"
+
+	DeletionPlanVersion = 1
+
+	ErrUnknownDeletionPlanVersion = "unknown deletion plan version"
+)
+
+// DuplicateItem is an item of a cluster of duplicates. Extras lists what a
+// duplicate holds that the canonical item doesn't, e.g. field:PIN,
+// attachment:scan.pdf or its passwords, current and old.
+type DuplicateItem struct {
+	AuditItem
+	Folder       string   `json:"folder"`
+	RevisionDate string   `json:"revisionDate"`
+	Extras       []string `json:"extras,omitempty"`
+}
+
+// DuplicateCluster is a group of items that look like copies of one another.
+// Canonical is the one to keep, the most recently changed.
+type DuplicateCluster struct {
+	Kind       string          `json:"kind"`
+	Domain     string          `json:"domain,omitempty"`
+	Username   string          `json:"username,omitempty"`
+	Canonical  DuplicateItem   `json:"canonical"`
+	Duplicates []DuplicateItem `json:"duplicates"`
+}
+
+// DuplicateReport is the result of FindDuplicates. It never contains
+// passwords.
+type DuplicateReport struct {
+	Items    int                `json:"items"`
+	Clusters []DuplicateCluster `json:"clusters"`
+}
+
+// DeletionPlan lists the items to leave out of a restore, as written by
+// dedupe-report and read by `restore --deletion-plan`.
+type DeletionPlan struct {
+	Version int                `json:"version"`
+	Items   []DeletionPlanItem `json:"items"`
+}
+
+type DeletionPlanItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// KeptID is the canonical item that replaces it
+	KeptID string `json:"keptId"`
+}
+
+// FindDuplicates clusters the logins of b by the registrable domain of their
+// first URI and their username. A cluster whose items also share the
+// password is an exact duplicate, otherwise a near duplicate. Logins without
+// a domain, like those of routers and SSH boxes that all use "admin", and
+// other items are only clustered when their type, name and content are
+// identical.
+func FindDuplicates(b *Backup) *DuplicateReport {
+	r := &DuplicateReport{Items: len(b.Items), Clusters: []DuplicateCluster{}}
+	groups := make(map[string][]PortWardenElement)
+	var order []string
+	for _, item := range b.Items {
+		key := duplicateKey(item)
+		if len(key) == 0 {
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], item)
+	}
+
+	for _, key := range order {
+		items := groups[key]
+		if len(items) < 2 {
+			continue
+		}
+		canonical := canonicalItem(items)
+		c := DuplicateCluster{Kind: DuplicateExact, Duplicates: []DuplicateItem{}}
+		if canonical.Login != nil {
+			c.Domain = RegistrableDomain(loginPrimaryURI(canonical))
+			c.Username = stringValue(canonical.Login.Username)
+		}
+		c.Canonical = DuplicateItem{
+			AuditItem:    AuditItem{ID: canonical.ID, Name: canonical.Name},
+			Folder:       b.FolderName(canonical.FolderID),
+			RevisionDate: canonical.RevisionDate,
+		}
+		for _, item := range items {
+			if item.ID == canonical.ID {
+				continue
+			}
+			if item.Login != nil && stringValue(item.Login.Password) != stringValue(canonical.Login.Password) {
+				c.Kind = DuplicateNear
+			}
+			c.Duplicates = append(c.Duplicates, DuplicateItem{
+				AuditItem:    AuditItem{ID: item.ID, Name: item.Name},
+				Folder:       b.FolderName(item.FolderID),
+				RevisionDate: item.RevisionDate,
+				Extras:       duplicateExtras(b, canonical, item),
+			})
+		}
+		r.Clusters = append(r.Clusters, c)
+	}
+	return r
+}
+
+// duplicateKey returns what items have in common to be duplicates, or "" if
+// the item can't be compared.
+func duplicateKey(item PortWardenElement) string {
+	if item.Login != nil {
+		if domain := RegistrableDomain(loginPrimaryURI(item)); len(domain) > 0 {
+			username := strings.ToLower(strings.TrimSpace(stringValue(item.Login.Username)))
+			return "login\x00" + domain + "\x00" + username
+		}
+	}
+	content, err := json.Marshal(struct {
+		Login    *Login
+		Notes    *string
+		Card     *Card
+		Identity *Identity
+		Fields   []Field
+	}{item.Login, item.Notes, item.Card, item.Identity, item.Fields})
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d\x00%v\x00%x", item.Type, strings.ToLower(strings.TrimSpace(item.Name)), sha256.Sum256(content))
+}
+
+// canonicalItem picks the most recently changed item, then the one holding
+// the most.
+func canonicalItem(items []PortWardenElement) PortWardenElement {
+	sorted := append([]PortWardenElement{}, items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339, sorted[i].RevisionDate)
+		tj, _ := time.Parse(time.RFC3339, sorted[j].RevisionDate)
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return itemRichness(sorted[i]) > itemRichness(sorted[j])
+	})
+	return sorted[0]
+}
+
+func itemRichness(item PortWardenElement) int {
+	n := len(item.Fields) + len(item.PasswordHistory) + len(item.Attachments)
+	if item.Login != nil {
+		n += len(item.Login.Uris)
+	}
+	return n
+}
+
+func loginPrimaryURI(item PortWardenElement) string {
+	if item.Login == nil || len(item.Login.Uris) == 0 {
+		return ""
+	}
+	return item.Login.Uris[0].URI
+}
+
+// RegistrableDomain returns the domain of uri one level below its public
+// suffix, e.g. example.co.uk for https://www.login.example.co.uk/. IP
+// addresses and hosts without a known suffix are returned as they are, and
+// URIs without a scheme are read as hosts.
+func RegistrableDomain(uri string) string {
+	uri = strings.TrimSpace(uri)
+	if len(uri) == 0 {
+		return ""
+	}
+	if !strings.Contains(uri, "://") {
+		uri = "http://" + uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if len(host) == 0 || net.ParseIP(host) != nil {
+		return host
+	}
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+// duplicateExtras lists what item holds that canonical doesn't.
+func duplicateExtras(b *Backup, canonical, item PortWardenElement) []string {
+	var extras []string
+	if item.Login != nil && canonical.Login != nil {
+		if len(stringValue(item.Login.Totp)) > 0 && len(stringValue(canonical.Login.Totp)) == 0 {
+			extras = append(extras, "totp")
+		}
+		for _, uri := range mergedURIs(canonical, item) {
+			extras = append(extras, "uri:"+uri.URI)
+		}
+	}
+	if n := len(mergedPasswordHistory(canonical, item)); n > 0 {
+		extras = append(extras, fmt.Sprintf("passwords missing from the history: %d", n))
+	}
+	if notes := strings.TrimSpace(stringValue(item.Notes)); len(notes) > 0 && !strings.Contains(stringValue(canonical.Notes), notes) {
+		extras = append(extras, "notes")
+	}
+	for _, field := range mergedFields(canonical, item) {
+		extras = append(extras, "field:"+stringValue(field.Name))
+	}
+	for _, attachment := range mergedAttachments(b, canonical, item) {
+		extras = append(extras, "attachment:"+attachment.FileName)
+	}
+	return extras
+}
+
+func mergedURIs(canonical, item PortWardenElement) []Uris {
+	have := make(map[string]bool)
+	for _, uri := range canonical.Login.Uris {
+		have[strings.ToLower(uri.URI)] = true
+	}
+	var uris []Uris
+	for _, uri := range item.Login.Uris {
+		if !have[strings.ToLower(uri.URI)] {
+			have[strings.ToLower(uri.URI)] = true
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// mergedPasswordHistory returns the passwords item used that canonical
+// doesn't know, including the current password of item if it differs.
+func mergedPasswordHistory(canonical, item PortWardenElement) []PasswordHistory {
+	have := make(map[string]bool)
+	if canonical.Login != nil {
+		have[stringValue(canonical.Login.Password)] = true
+	}
+	for _, ph := range canonical.PasswordHistory {
+		have[ph.Password] = true
+	}
+	var history []PasswordHistory
+	if item.Login != nil {
+		if password := stringValue(item.Login.Password); len(password) > 0 && !have[password] {
+			have[password] = true
+			history = append(history, PasswordHistory{LastUsedDate: item.RevisionDate, Password: password})
+		}
+	}
+	for _, ph := range item.PasswordHistory {
+		if !have[ph.Password] {
+			have[ph.Password] = true
+			history = append(history, ph)
+		}
+	}
+	return history
+}
+
+func mergedFields(canonical, item PortWardenElement) []Field {
+	have := make(map[string]bool)
+	for _, field := range canonical.Fields {
+		have[strings.ToLower(stringValue(field.Name))+"\x00"+stringValue(field.Value)] = true
+	}
+	var fields []Field
+	for _, field := range item.Fields {
+		key := strings.ToLower(stringValue(field.Name)) + "\x00" + stringValue(field.Value)
+		if !have[key] {
+			have[key] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// mergedAttachments compares attachments by content when the backup has
+// them, by file name otherwise.
+func mergedAttachments(b *Backup, canonical, item PortWardenElement) []Attachment {
+	key := func(item PortWardenElement, attachment Attachment) string {
+		if content, ok := b.Attachment(item, attachment); ok {
+			return fmt.Sprintf("%x", sha256.Sum256(content))
+		}
+		return "name:" + attachment.FileName
+	}
+	have := make(map[string]bool)
+	for _, attachment := range canonical.Attachments {
+		have[key(canonical, attachment)] = true
+		have["name:"+attachment.FileName] = true
+	}
+	var attachments []Attachment
+	for _, attachment := range item.Attachments {
+		if k := key(item, attachment); !have[k] {
+			have[k] = true
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+// DeletionPlan lists the duplicates of r to delete in favor of their
+// canonical items.
+func (r *DuplicateReport) DeletionPlan() *DeletionPlan {
+	plan := &DeletionPlan{Version: DeletionPlanVersion, Items: []DeletionPlanItem{}}
+	for _, c := range r.Clusters {
+		for _, d := range c.Duplicates {
+			plan.Items = append(plan.Items, DeletionPlanItem{ID: d.ID, Name: d.Name, KeptID: c.Canonical.ID})
+		}
+	}
+	return plan
+}
+
+// ReadDeletionPlanFile reads a deletion plan and returns the ids of the items
+// to delete.
+func ReadDeletionPlanFile(fileName string) (map[string]bool, error) {
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	plan := DeletionPlan{}
+	if err := json.Unmarshal(file, &plan); err != nil {
+		return nil, err
+	}
+	if plan.Version != DeletionPlanVersion {
+		return nil, errors.New(ErrUnknownDeletionPlanVersion + ": " + strconv.Itoa(plan.Version))
+	}
+	ids := make(map[string]bool)
+	for _, item := range plan.Items {
+		ids[item.ID] = true
+	}
+	return ids, nil
+}
+
+// withoutItems returns the items whose ids aren't in ids.
+func withoutItems(items PortWarden, ids map[string]bool) PortWarden {
+	if len(ids) == 0 {
+		return items
+	}
+	kept := PortWarden{}
+	for _, item := range items {
+		if !ids[item.ID] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// RemoveDuplicates returns a copy of b without the duplicates of r. What a
+// duplicate holds that its canonical item doesn't (URIs, TOTP, notes, custom
+// fields, old passwords and attachments) is merged into the canonical item
+// first, so nothing is lost.
+func RemoveDuplicates(b *Backup, r *DuplicateReport) *Backup {
+	keep := make(map[string]string)
+	for _, c := range r.Clusters {
+		for _, d := range c.Duplicates {
+			keep[d.ID] = c.Canonical.ID
+		}
+	}
+	byID := make(map[string]PortWardenElement)
+	for _, item := range b.Items {
+		byID[item.ID] = item
+	}
+
+	cleaned := *b
+	cleaned.Manifest = &Manifest{Version: ManifestVersion, Attachments: []ManifestAttachment{}}
+	cleaned.Items = PortWarden{}
+	cleaned.Files = make(map[string][]byte)
+	for name, content := range b.Files {
+		cleaned.Files[name] = content
+	}
+	merged := make(map[string]PortWardenElement)
+	// attachments stay where they are in the archive, the manifest points
+	// the items they end up in at them
+	paths := make(map[string]string)
+	for _, item := range b.Items {
+		canonicalID, ok := keep[item.ID]
+		if !ok {
+			continue
+		}
+		canonical, ok := merged[canonicalID]
+		if !ok {
+			canonical = copyItem(byID[canonicalID])
+		}
+		for _, attachment := range mergedAttachments(b, canonical, item) {
+			paths[canonicalID+"\x00"+attachment.ID] = b.Manifest.AttachmentPath(item, attachment)
+		}
+		merged[canonicalID] = mergeDuplicate(b, canonical, item)
+	}
+
+	for _, item := range b.Items {
+		if _, ok := keep[item.ID]; ok {
+			continue
+		}
+		original := item
+		if m, ok := merged[item.ID]; ok {
+			item = m
+		}
+		cleaned.Items = append(cleaned.Items, item)
+		for _, attachment := range item.Attachments {
+			path, ok := paths[item.ID+"\x00"+attachment.ID]
+			if !ok {
+				path = b.Manifest.AttachmentPath(original, attachment)
+			}
+			cleaned.Manifest.Attachments = append(cleaned.Manifest.Attachments, ManifestAttachment{
+				ItemID:       item.ID,
+				ItemName:     item.Name,
+				AttachmentID: attachment.ID,
+				FileName:     attachment.FileName,
+				Path:         path,
+			})
+		}
+	}
+
+	kept := make(map[string]bool)
+	for _, ma := range cleaned.Manifest.Attachments {
+		kept[ma.Path] = true
+	}
+	for _, item := range b.Items {
+		if _, ok := keep[item.ID]; !ok {
+			continue
+		}
+		for _, attachment := range item.Attachments {
+			if path := b.Manifest.AttachmentPath(item, attachment); !kept[path] {
+				delete(cleaned.Files, path)
+			}
+		}
+	}
+	return &cleaned
+}
+
+// copyItem copies the parts of item that mergeDuplicate changes.
+func copyItem(item PortWardenElement) PortWardenElement {
+	if item.Login != nil {
+		login := *item.Login
+		login.Uris = append([]Uris{}, login.Uris...)
+		item.Login = &login
+	}
+	item.Fields = append([]Field{}, item.Fields...)
+	item.PasswordHistory = append([]PasswordHistory{}, item.PasswordHistory...)
+	item.Attachments = append([]Attachment{}, item.Attachments...)
+	return item
+}
+
+func mergeDuplicate(b *Backup, canonical, item PortWardenElement) PortWardenElement {
+	if canonical.Login != nil && item.Login != nil {
+		canonical.Login.Uris = append(canonical.Login.Uris, mergedURIs(canonical, item)...)
+		if len(stringValue(canonical.Login.Totp)) == 0 {
+			canonical.Login.Totp = item.Login.Totp
+		}
+	}
+	canonical.PasswordHistory = append(canonical.PasswordHistory, mergedPasswordHistory(canonical, item)...)
+	if notes := strings.TrimSpace(stringValue(item.Notes)); len(notes) > 0 && !strings.Contains(stringValue(canonical.Notes), notes) {
+		merged := notes
+		if existing := stringValue(canonical.Notes); len(existing) > 0 {
+			merged = existing + "\n\n" + notes
+		}
+		canonical.Notes = &merged
+	}
+	canonical.Fields = append(canonical.Fields, mergedFields(canonical, item)...)
+	canonical.Attachments = append(canonical.Attachments, mergedAttachments(b, canonical, item)...)
+	return canonical
+}
+
+// WriteDuplicateReport writes r as tables or as JSON.
+func WriteDuplicateReport(r *DuplicateReport, w io.Writer, format string) error {
+	switch format {
+	case AuditFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case AuditFormatTable:
+	default:
+		return errors.New(ErrUnknownAuditFormat + ": " + format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	duplicates := 0
+	for i, c := range r.Clusters {
+		duplicates += len(c.Duplicates)
+		title := c.Canonical.Name
+		if len(c.Domain) > 0 || len(c.Username) > 0 {
+			title = strings.Trim(c.Username+" @ "+c.Domain, " @")
+		}
+		fmt.Fprintf(tw, "%v. %v (%v duplicates)\n", i+1, title, c.Kind)
+		fmt.Fprintln(tw, "\tNAME\tID\tFOLDER\tCHANGED\tEXTRAS")
+		fmt.Fprintf(tw, "  keep\t%v\t%v\t%v\t%v\t\n", c.Canonical.Name, c.Canonical.ID, c.Canonical.Folder, revisionDay(c.Canonical.RevisionDate))
+		for _, d := range c.Duplicates {
+			fmt.Fprintf(tw, "  delete\t%v\t%v\t%v\t%v\t%v\n", d.Name, d.ID, d.Folder, revisionDay(d.RevisionDate), strings.Join(d.Extras, ", "))
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintf(tw, "%v items, %v clusters, %v duplicates\n", r.Items, len(r.Clusters), duplicates)
+	return tw.Flush()
+}
+
+func revisionDay(revisionDate string) string {
+	if t, err := time.Parse(time.RFC3339, revisionDate); err == nil {
+		return t.Format("2006-01-02")
+	}
+	return revisionDate
+}
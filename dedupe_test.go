@@ -0,0 +1,166 @@
+package portwarden
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func testLogin(id, name, uri, username, password, revisionDate string) PortWardenElement {
+	item := PortWardenElement{ID: id, Type: ItemTypeLogin, Name: name, RevisionDate: revisionDate,
+		Login: &Login{Username: strPtr(username), Password: strPtr(password)}}
+	if len(uri) > 0 {
+		item.Login.Uris = []Uris{{URI: uri}}
+	}
+	return item
+}
+
+func TestFindDuplicates(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		items PortWarden
+		want  []DuplicateCluster
+	}{
+		{
+			"logins without a URI that share a username",
+			PortWarden{
+				testLogin("router", "Router", "", "admin", "r0uter", "2026-10-01T00:00:00Z"),
+				testLogin("nas", "NAS", "", "admin", "n4s", "2026-10-02T00:00:00Z"),
+			},
+			[]DuplicateCluster{},
+		},
+		{
+			"identical logins without a URI",
+			PortWarden{
+				testLogin("router", "Router", "", "admin", "r0uter", "2026-10-01T00:00:00Z"),
+				testLogin("copy", "Router", "", "admin", "r0uter", "2026-10-02T00:00:00Z"),
+			},
+			[]DuplicateCluster{{Kind: DuplicateExact, Username: "admin",
+				Canonical:  DuplicateItem{AuditItem: AuditItem{ID: "copy", Name: "Router"}, RevisionDate: "2026-10-02T00:00:00Z"},
+				Duplicates: []DuplicateItem{{AuditItem: AuditItem{ID: "router", Name: "Router"}, RevisionDate: "2026-10-01T00:00:00Z"}}}},
+		},
+		{
+			"logins of the same domain",
+			PortWarden{
+				testLogin("old", "Example", "https://www.example.com/login", "alice", "s3cret", "2026-10-01T00:00:00Z"),
+				testLogin("new", "example.com", "https://example.com", "Alice", "s3cret", "2026-10-02T00:00:00Z"),
+				testLogin("other", "Example", "https://example.com", "bob", "s3cret", "2026-10-02T00:00:00Z"),
+			},
+			[]DuplicateCluster{{Kind: DuplicateExact, Domain: "example.com", Username: "Alice",
+				Canonical: DuplicateItem{AuditItem: AuditItem{ID: "new", Name: "example.com"}, RevisionDate: "2026-10-02T00:00:00Z"},
+				Duplicates: []DuplicateItem{{AuditItem: AuditItem{ID: "old", Name: "Example"}, RevisionDate: "2026-10-01T00:00:00Z",
+					Extras: []string{"uri:https://www.example.com/login"}}}}},
+		},
+		{
+			"logins of the same domain with other passwords",
+			PortWarden{
+				testLogin("old", "Example", "https://example.com", "alice", "0ld", "2026-10-01T00:00:00Z"),
+				testLogin("new", "Example", "https://example.com", "alice", "n3w", "2026-10-02T00:00:00Z"),
+			},
+			[]DuplicateCluster{{Kind: DuplicateNear, Domain: "example.com", Username: "alice",
+				Canonical: DuplicateItem{AuditItem: AuditItem{ID: "new", Name: "Example"}, RevisionDate: "2026-10-02T00:00:00Z"},
+				Duplicates: []DuplicateItem{{AuditItem: AuditItem{ID: "old", Name: "Example"}, RevisionDate: "2026-10-01T00:00:00Z",
+					Extras: []string{"passwords missing from the history: 1"}}}}},
+		},
+	} {
+		r := FindDuplicates(&Backup{Items: tc.items})
+		if !reflect.DeepEqual(r.Clusters, tc.want) {
+			t.Errorf("%v: FindDuplicates = %+v, want %+v", tc.name, r.Clusters, tc.want)
+		}
+	}
+}
+
+func TestRemoveDuplicates(t *testing.T) {
+	canonical := testLogin("a", "Example", "https://example.com", "alice", "n3w", "2026-10-01T00:00:00Z")
+	canonical.PasswordHistory = []PasswordHistory{{LastUsedDate: "2026-01-01T00:00:00Z", Password: "0ld"}}
+	canonical.Attachments = []Attachment{{ID: "a1", FileName: "scan.pdf"}}
+	duplicate := testLogin("b", "Example (old)", "https://www.example.com", "alice", "0lder", "2026-05-01T00:00:00Z")
+	duplicate.PasswordHistory = []PasswordHistory{
+		{LastUsedDate: "2026-01-01T00:00:00Z", Password: "0ld"},
+		{LastUsedDate: "2025-01-01T00:00:00Z", Password: "0ldest"},
+	}
+	duplicate.Attachments = []Attachment{{ID: "b1", FileName: "scan.pdf"}, {ID: "b2", FileName: "receipt.pdf"}}
+	other := testLogin("c", "Other", "https://other.org", "alice", "n3w", "2026-10-01T00:00:00Z")
+	other.Attachments = []Attachment{{ID: "c1", FileName: "scan.pdf"}}
+	items := PortWarden{canonical, duplicate, other}
+	wantHistory := []PasswordHistory{
+		{LastUsedDate: "2026-01-01T00:00:00Z", Password: "0ld"},
+		{LastUsedDate: "2026-05-01T00:00:00Z", Password: "0lder"},
+		{LastUsedDate: "2025-01-01T00:00:00Z", Password: "0ldest"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		manifest *Manifest
+		path     func(item PortWardenElement, attachment Attachment) string
+	}{
+		{"manifest", NewManifest(items), func(item PortWardenElement, attachment Attachment) string {
+			return AttachmentPath(item.ID, attachment.ID, attachment.FileName)
+		}},
+		{"legacy", nil, func(item PortWardenElement, attachment Attachment) string {
+			return LegacyAttachmentPath(item.Name, attachment.FileName)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &Backup{Items: items, Manifest: tc.manifest, Files: map[string][]byte{
+				tc.path(canonical, canonical.Attachments[0]): []byte("scan"),
+				tc.path(duplicate, duplicate.Attachments[0]): []byte("scan"),
+				tc.path(duplicate, duplicate.Attachments[1]): []byte("receipt"),
+				tc.path(other, other.Attachments[0]):         []byte("other scan"),
+			}}
+			cleaned := RemoveDuplicates(b, FindDuplicates(b))
+
+			var ids []string
+			for _, item := range cleaned.Items {
+				ids = append(ids, item.ID)
+			}
+			if !reflect.DeepEqual(ids, []string{"a", "c"}) {
+				t.Fatalf("kept items %v, want [a c]", ids)
+			}
+			merged := cleaned.Items[0]
+			if !reflect.DeepEqual(merged.PasswordHistory, wantHistory) {
+				t.Errorf("PasswordHistory = %v, want %v", merged.PasswordHistory, wantHistory)
+			}
+			if len(merged.Login.Uris) != 2 || merged.Login.Uris[1].URI != "https://www.example.com" {
+				t.Errorf("URIs = %v, want those of both items", merged.Login.Uris)
+			}
+			if len(b.Items[0].PasswordHistory) != 1 || len(b.Items[0].Attachments) != 1 {
+				t.Error("RemoveDuplicates changed the items of the original backup")
+			}
+
+			wantManifest := []ManifestAttachment{
+				{ItemID: "a", ItemName: "Example", AttachmentID: "a1", FileName: "scan.pdf", Path: tc.path(canonical, canonical.Attachments[0])},
+				{ItemID: "a", ItemName: "Example", AttachmentID: "b2", FileName: "receipt.pdf", Path: tc.path(duplicate, duplicate.Attachments[1])},
+				{ItemID: "c", ItemName: "Other", AttachmentID: "c1", FileName: "scan.pdf", Path: tc.path(other, other.Attachments[0])},
+			}
+			if !reflect.DeepEqual(cleaned.Manifest.Attachments, wantManifest) {
+				t.Errorf("manifest = %+v, want %+v", cleaned.Manifest.Attachments, wantManifest)
+			}
+			for _, ma := range cleaned.Manifest.Attachments {
+				item := PortWardenElement{ID: ma.ItemID, Name: ma.ItemName}
+				if _, ok := cleaned.Attachment(item, Attachment{ID: ma.AttachmentID, FileName: ma.FileName}); !ok {
+					t.Errorf("attachment %v of item %v is missing from the files", ma.FileName, ma.ItemID)
+				}
+			}
+
+			// the copy of scan.pdf in the duplicate is dropped
+			var files []string
+			for name := range cleaned.Files {
+				files = append(files, name)
+			}
+			sort.Strings(files)
+			wantFiles := []string{wantManifest[0].Path, wantManifest[1].Path, wantManifest[2].Path}
+			sort.Strings(wantFiles)
+			if !reflect.DeepEqual(files, wantFiles) {
+				t.Errorf("files = %v, want %v", files, wantFiles)
+			}
+			if len(b.Files) != 4 {
+				t.Error("RemoveDuplicates changed the files of the original backup")
+			}
+		})
+	}
+}
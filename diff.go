@@ -0,0 +1,280 @@
+package portwarden
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	DiffFormatText = "text"
+	DiffFormatJSON = "json"
+
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffRenamed  = "renamed"
+	DiffMoved    = "moved"
+	DiffModified = "modified"
+
+	ErrUnknownDiffFormat = "unknown diff format"
+)
+
+// DiffOptions configures DiffBackups.
+type DiffOptions struct {
+	// ShowSecrets shows the values of passwords, notes, hidden fields and
+	// the like instead of masking them
+	ShowSecrets bool
+}
+
+// BackupDiff is what changed between two backups of the same vault.
+type BackupDiff struct {
+	Folders []FolderChange `json:"folders"`
+	Items   []ItemChange   `json:"items"`
+}
+
+type FolderChange struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	OldName string `json:"oldName,omitempty"`
+	Change  string `json:"change"`
+}
+
+// ItemChange is an item that was added, removed or changed. Changes lists
+// what happened to a changed item: renamed, moved between folders and/or
+// modified, with the details in Fields.
+type ItemChange struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Folder  string        `json:"folder"`
+	Changes []string      `json:"changes"`
+	Fields  []FieldChange `json:"fields,omitempty"`
+	// RevisionDate is when the item last changed in the newer backup
+	RevisionDate string `json:"revisionDate,omitempty"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type diffValue struct {
+	field  string
+	value  string
+	secret bool
+}
+
+// DiffBackups compares the folders and items of two backups, matching them
+// by their IDs.
+func DiffBackups(before, after *Backup, opts DiffOptions) *BackupDiff {
+	d := &BackupDiff{Folders: []FolderChange{}, Items: []ItemChange{}}
+
+	oldFolders := make(map[string]string)
+	for _, folder := range before.Folders {
+		if folder.ID != nil {
+			oldFolders[*folder.ID] = folder.Name
+		}
+	}
+	newFolders := make(map[string]bool)
+	for _, folder := range after.Folders {
+		if folder.ID == nil {
+			continue
+		}
+		newFolders[*folder.ID] = true
+		oldName, ok := oldFolders[*folder.ID]
+		switch {
+		case !ok:
+			d.Folders = append(d.Folders, FolderChange{ID: *folder.ID, Name: folder.Name, Change: DiffAdded})
+		case oldName != folder.Name:
+			d.Folders = append(d.Folders, FolderChange{ID: *folder.ID, Name: folder.Name, OldName: oldName, Change: DiffRenamed})
+		}
+	}
+	for _, folder := range before.Folders {
+		if folder.ID != nil && !newFolders[*folder.ID] {
+			d.Folders = append(d.Folders, FolderChange{ID: *folder.ID, Name: folder.Name, Change: DiffRemoved})
+		}
+	}
+
+	oldItems := make(map[string]PortWardenElement)
+	for _, item := range before.Items {
+		oldItems[item.ID] = item
+	}
+	newItems := make(map[string]bool)
+	for _, item := range after.Items {
+		newItems[item.ID] = true
+		change := ItemChange{ID: item.ID, Name: item.Name, Folder: after.FolderName(item.FolderID), RevisionDate: item.RevisionDate}
+		oldItem, ok := oldItems[item.ID]
+		if !ok {
+			change.Changes = []string{DiffAdded}
+			d.Items = append(d.Items, change)
+			continue
+		}
+		// folders are compared by ID, renaming one doesn't move its items
+		if stringValue(oldItem.FolderID) != stringValue(item.FolderID) {
+			change.Fields = append(change.Fields, FieldChange{Field: "folder", Old: before.FolderName(oldItem.FolderID), New: change.Folder})
+		}
+		change.Fields = append(change.Fields, diffValues(diffItemValues(before, oldItem), diffItemValues(after, item), opts)...)
+		modified := false
+		for _, f := range change.Fields {
+			switch f.Field {
+			case "name":
+				change.Changes = append(change.Changes, DiffRenamed)
+			case "folder":
+				change.Changes = append(change.Changes, DiffMoved)
+			default:
+				modified = true
+			}
+		}
+		if modified {
+			change.Changes = append(change.Changes, DiffModified)
+		}
+		if len(change.Changes) > 0 {
+			d.Items = append(d.Items, change)
+		}
+	}
+	for _, item := range before.Items {
+		if !newItems[item.ID] {
+			d.Items = append(d.Items, ItemChange{ID: item.ID, Name: item.Name, Folder: before.FolderName(item.FolderID), Changes: []string{DiffRemoved}})
+		}
+	}
+	return d
+}
+
+// diffValues lists the fields whose value differs, in the order of the newer
+// item followed by the fields it no longer has.
+func diffValues(oldValues, newValues []diffValue, opts DiffOptions) []FieldChange {
+	oldByField := make(map[string]diffValue)
+	for _, v := range oldValues {
+		oldByField[v.field] = v
+	}
+	var changes []FieldChange
+	add := func(field, oldValue, newValue string, secret bool) {
+		if secret && !opts.ShowSecrets {
+			if len(oldValue) > 0 {
+				oldValue = maskedSecret
+			}
+			if len(newValue) > 0 {
+				newValue = maskedSecret
+			}
+		}
+		changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+	}
+	seen := make(map[string]bool)
+	for _, v := range newValues {
+		seen[v.field] = true
+		if o := oldByField[v.field]; o.value != v.value {
+			add(v.field, o.value, v.value, v.secret || o.secret)
+		}
+	}
+	for _, o := range oldValues {
+		if !seen[o.field] && len(o.value) > 0 {
+			add(o.field, o.value, "", o.secret)
+		}
+	}
+	return changes
+}
+
+// diffItemValues flattens an item into named values. Custom fields and
+// attachments are named after their names so that reordering them isn't a
+// change.
+func diffItemValues(b *Backup, item PortWardenElement) []diffValue {
+	var values []diffValue
+	add := func(field, value string, secret bool) {
+		values = append(values, diffValue{field: field, value: value, secret: secret})
+	}
+	unique := make(map[string]bool)
+	uniqueField := func(field string) string {
+		name := field
+		for i := 2; unique[name]; i++ {
+			name = field + "#" + strconv.Itoa(i)
+		}
+		unique[name] = true
+		return name
+	}
+
+	add("name", item.Name, false)
+	add("type", strconv.FormatInt(item.Type, 10), false)
+	add("favorite", strconv.FormatBool(item.Favorite), false)
+	add("organization", stringValue(item.OrganizationID), false)
+	add("collections", strings.Join(item.CollectionIDS, ", "), false)
+	add("notes", stringValue(item.Notes), true)
+	if item.Login != nil {
+		add("login.username", stringValue(item.Login.Username), false)
+		add("login.password", stringValue(item.Login.Password), true)
+		add("login.totp", stringValue(item.Login.Totp), true)
+		var uris []string
+		for _, uri := range item.Login.Uris {
+			uris = append(uris, uri.URI)
+		}
+		add("login.uris", strings.Join(uris, ", "), false)
+	}
+	if item.Card != nil {
+		add("card.cardholderName", item.Card.CardholderName, false)
+		add("card.brand", item.Card.Brand, false)
+		add("card.number", item.Card.Number, true)
+		add("card.expiration", strings.Trim(item.Card.ExpMonth+"/"+item.Card.ExpYear, "/"), false)
+		add("card.code", stringValue(item.Card.Code), true)
+	}
+	for _, f := range IdentityFields(item.Identity) {
+		add("identity."+f.Name, f.Value, f.Name == "SSN" || f.Name == "Passport Number" || f.Name == "License Number")
+	}
+	for _, field := range item.Fields {
+		add(uniqueField("fields["+stringValue(field.Name)+"]"), stringValue(field.Value), field.Type == FieldTypeHidden)
+	}
+	for _, attachment := range item.Attachments {
+		value := attachment.SizeName
+		if content, ok := b.Attachment(item, attachment); ok {
+			value += fmt.Sprintf(", sha256 %x", sha256.Sum256(content))
+		} else {
+			value += ", missing from the backup"
+		}
+		add(uniqueField("attachments["+attachment.FileName+"]"), value, false)
+	}
+	return values
+}
+
+// WriteBackupDiff writes d as text or as JSON.
+func WriteBackupDiff(d *BackupDiff, w io.Writer, format string) error {
+	switch format {
+	case DiffFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	case DiffFormatText:
+	default:
+		return errors.New(ErrUnknownDiffFormat + ": " + format)
+	}
+
+	counts := make(map[string]int)
+	for _, folder := range d.Folders {
+		switch folder.Change {
+		case DiffRenamed:
+			fmt.Fprintf(w, "folder renamed: %q -> %q (%v)\n", folder.OldName, folder.Name, folder.ID)
+		default:
+			fmt.Fprintf(w, "folder %v: %q (%v)\n", folder.Change, folder.Name, folder.ID)
+		}
+	}
+	for _, item := range d.Items {
+		for _, change := range item.Changes {
+			counts[change]++
+		}
+		fmt.Fprintf(w, "%v: %q (%v)", strings.Join(item.Changes, ", "), item.Name, item.ID)
+		if len(item.Folder) > 0 {
+			fmt.Fprintf(w, " in %q", item.Folder)
+		}
+		if len(item.Fields) > 0 && len(item.RevisionDate) > 0 {
+			fmt.Fprintf(w, ", last changed %v", item.RevisionDate)
+		}
+		fmt.Fprintln(w)
+		for _, f := range item.Fields {
+			fmt.Fprintf(w, "    %v: %q -> %q\n", f.Field, f.Old, f.New)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%v folders changed; items: %v added, %v removed, %v renamed, %v moved, %v modified\n",
+		len(d.Folders), counts[DiffAdded], counts[DiffRemoved], counts[DiffRenamed], counts[DiffMoved], counts[DiffModified])
+	return err
+}
@@ -0,0 +1,137 @@
+package portwarden
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffBackups(t *testing.T) {
+	folders := PortWardenFolder{{ID: strPtr("f1"), Name: "Personal"}, {ID: strPtr("f2"), Name: "Work"}}
+	withFolder := func(item PortWardenElement, folderID string) PortWardenElement {
+		item.FolderID = strPtr(folderID)
+		return item
+	}
+	withFields := func(item PortWardenElement, fields ...Field) PortWardenElement {
+		item.Fields = fields
+		return item
+	}
+	withAttachment := func(item PortWardenElement) PortWardenElement {
+		item.Attachments = []Attachment{{ID: "a1", FileName: "scan.pdf", SizeName: "3 Bytes"}}
+		return item
+	}
+	login := testLogin("l1", "Example", "https://example.com", "alice", "0ld", "2026-10-01T00:00:00Z")
+	changed := testLogin("l1", "Example", "https://example.com", "alice", "n3w", "2026-10-02T00:00:00Z")
+	hash := func(content string) string {
+		return fmt.Sprintf("3 Bytes, sha256 %x", sha256.Sum256([]byte(content)))
+	}
+	for _, tc := range []struct {
+		name          string
+		before, after *Backup
+		opts          DiffOptions
+		wantFolders   []FolderChange
+		wantItems     []ItemChange
+	}{
+		{
+			"unchanged",
+			&Backup{Items: PortWarden{login}},
+			&Backup{Items: PortWarden{login}},
+			DiffOptions{},
+			[]FolderChange{},
+			[]ItemChange{},
+		},
+		{
+			"masked password",
+			&Backup{Items: PortWarden{login}},
+			&Backup{Items: PortWarden{changed}},
+			DiffOptions{},
+			[]FolderChange{},
+			[]ItemChange{{ID: "l1", Name: "Example", Changes: []string{DiffModified}, RevisionDate: "2026-10-02T00:00:00Z",
+				Fields: []FieldChange{{Field: "login.password", Old: maskedSecret, New: maskedSecret}}}},
+		},
+		{
+			"shown password",
+			&Backup{Items: PortWarden{login}},
+			&Backup{Items: PortWarden{changed}},
+			DiffOptions{ShowSecrets: true},
+			[]FolderChange{},
+			[]ItemChange{{ID: "l1", Name: "Example", Changes: []string{DiffModified}, RevisionDate: "2026-10-02T00:00:00Z",
+				Fields: []FieldChange{{Field: "login.password", Old: "0ld", New: "n3w"}}}},
+		},
+		{
+			"removed password",
+			&Backup{Items: PortWarden{login}},
+			&Backup{Items: PortWarden{testLogin("l1", "Example", "https://example.com", "alice", "", "")}},
+			DiffOptions{},
+			[]FolderChange{},
+			[]ItemChange{{ID: "l1", Name: "Example", Changes: []string{DiffModified},
+				Fields: []FieldChange{{Field: "login.password", Old: maskedSecret, New: ""}}}},
+		},
+		{
+			"hidden and text fields",
+			&Backup{Items: PortWarden{withFields(login, Field{Name: strPtr("pin"), Value: strPtr("1234"), Type: FieldTypeHidden}, Field{Name: strPtr("id"), Value: strPtr("42")})}},
+			&Backup{Items: PortWarden{withFields(login, Field{Name: strPtr("id"), Value: strPtr("43")}, Field{Name: strPtr("pin"), Value: strPtr("4321"), Type: FieldTypeHidden})}},
+			DiffOptions{},
+			[]FolderChange{},
+			[]ItemChange{{ID: "l1", Name: "Example", Changes: []string{DiffModified}, RevisionDate: "2026-10-01T00:00:00Z",
+				Fields: []FieldChange{{Field: "fields[id]", Old: "42", New: "43"}, {Field: "fields[pin]", Old: maskedSecret, New: maskedSecret}}}},
+		},
+		{
+			"renamed and moved",
+			&Backup{Items: PortWarden{withFolder(login, "f1")}, Folders: folders},
+			&Backup{Items: PortWarden{withFolder(testLogin("l1", "Example Inc", "https://example.com", "alice", "0ld", ""), "f2")}, Folders: folders},
+			DiffOptions{},
+			[]FolderChange{},
+			[]ItemChange{{ID: "l1", Name: "Example Inc", Folder: "Work", Changes: []string{DiffMoved, DiffRenamed},
+				Fields: []FieldChange{{Field: "folder", Old: "Personal", New: "Work"}, {Field: "name", Old: "Example", New: "Example Inc"}}}},
+		},
+		{
+			"renamed folder",
+			&Backup{Items: PortWarden{withFolder(login, "f1")}, Folders: folders},
+			&Backup{Items: PortWarden{withFolder(login, "f1")}, Folders: PortWardenFolder{{ID: strPtr("f1"), Name: "Home"}}},
+			DiffOptions{},
+			[]FolderChange{{ID: "f1", Name: "Home", OldName: "Personal", Change: DiffRenamed}, {ID: "f2", Name: "Work", Change: DiffRemoved}},
+			[]ItemChange{},
+		},
+		{
+			"added and removed",
+			&Backup{Items: PortWarden{login}},
+			&Backup{Items: PortWarden{testLogin("l2", "Mail", "", "bob", "s3cret", "2026-10-02T00:00:00Z")}, Folders: folders[:1]},
+			DiffOptions{},
+			[]FolderChange{{ID: "f1", Name: "Personal", Change: DiffAdded}},
+			[]ItemChange{
+				{ID: "l2", Name: "Mail", Changes: []string{DiffAdded}, RevisionDate: "2026-10-02T00:00:00Z"},
+				{ID: "l1", Name: "Example", Changes: []string{DiffRemoved}},
+			},
+		},
+		{
+			"attachment content",
+			&Backup{Items: PortWarden{withAttachment(login)}, Files: map[string][]byte{"Example/scan.pdf": []byte("old")}},
+			&Backup{Items: PortWarden{withAttachment(login)}, Files: map[string][]byte{"Example/scan.pdf": []byte("new")}},
+			DiffOptions{},
+			[]FolderChange{},
+			[]ItemChange{{ID: "l1", Name: "Example", Changes: []string{DiffModified}, RevisionDate: "2026-10-01T00:00:00Z",
+				Fields: []FieldChange{{Field: "attachments[scan.pdf]", Old: hash("old"), New: hash("new")}}}},
+		},
+		{
+			"attachment missing",
+			&Backup{Items: PortWarden{withAttachment(login)}, Files: map[string][]byte{"Example/scan.pdf": []byte("old")}},
+			&Backup{Items: PortWarden{withAttachment(login)}},
+			DiffOptions{},
+			[]FolderChange{},
+			[]ItemChange{{ID: "l1", Name: "Example", Changes: []string{DiffModified}, RevisionDate: "2026-10-01T00:00:00Z",
+				Fields: []FieldChange{{Field: "attachments[scan.pdf]", Old: hash("old"), New: "3 Bytes, missing from the backup"}}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := DiffBackups(tc.before, tc.after, tc.opts)
+			if !reflect.DeepEqual(d.Folders, tc.wantFolders) {
+				t.Errorf("Folders = %+v, want %+v", d.Folders, tc.wantFolders)
+			}
+			if !reflect.DeepEqual(d.Items, tc.wantItems) {
+				t.Errorf("Items = %+v, want %+v", d.Items, tc.wantItems)
+			}
+		})
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/pbkdf2"
@@ -16,29 +18,109 @@ import (
 const (
 	ErrMessageAuthenticationFailed = "cipher: message authentication failed"
 	ErrWrongBackupPassphrase       = "wrong backup passphrase entered"
+	ErrBackupHeaderIterations      = "the backup header has an iteration count out of range"
+
+	KeyDerivationIterations = 4096
+	// MaxKDFIterations bounds the iterations a backup header may ask for, so
+	// that a corrupted or crafted file can't keep PBKDF2 busy for hours
+	MaxKDFIterations = 10000000
+
+	// BackupHeaderMagic starts the header in front of the nonce that tells
+	// how the key of a backup was derived. Only backups derived with other
+	// iterations than KeyDerivationIterations have it, so that the others
+	// stay readable by versions of Portwarden from before it was added.
+	BackupHeaderMagic   = "PWKD"
+	BackupHeaderVersion = 1
+	backupHeaderSize    = len(BackupHeaderMagic) + 1 + 4
 )
 
 // derive a key from the master password
-func DeriveKey(passphrase string) []byte {
-	return pbkdf2.Key([]byte(passphrase), []byte(Salt), 4096, 32, sha256.New)
+func DeriveKey(passphrase string, iterations int) []byte {
+	return pbkdf2.Key([]byte(passphrase), []byte(Salt), iterations, 32, sha256.New)
+}
+
+// backupHeader returns the header of a backup whose key was derived with
+// iterations: the magic, the version and the iterations as a big endian
+// uint32.
+func backupHeader(iterations int) []byte {
+	header := make([]byte, backupHeaderSize)
+	copy(header, BackupHeaderMagic)
+	header[len(BackupHeaderMagic)] = BackupHeaderVersion
+	binary.BigEndian.PutUint32(header[len(BackupHeaderMagic)+1:], uint32(iterations))
+	return header
 }
 
+// parseBackupHeader returns the iterations in the header of data, and false
+// if data doesn't start with one. The iterations may be out of range.
+func parseBackupHeader(data []byte) (uint32, bool) {
+	if len(data) < backupHeaderSize || string(data[:len(BackupHeaderMagic)]) != BackupHeaderMagic || data[len(BackupHeaderMagic)] != BackupHeaderVersion {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[len(BackupHeaderMagic)+1 : backupHeaderSize]), true
+}
+
+// validKDFIterations tells whether iterations are between
+// KeyDerivationIterations and MaxKDFIterations.
+func validKDFIterations(iterations uint32) bool {
+	return iterations >= KeyDerivationIterations && iterations <= MaxKDFIterations
+}
+
+// EncryptBytes encrypts data with the default KDF settings.
 func EncryptBytes(data []byte, passphrase string) ([]byte, error) {
-	block, _ := aes.NewCipher(DeriveKey(passphrase))
+	return EncryptBytesWithKDF(data, passphrase, KDFSettings{})
+}
+
+// EncryptBytesWithKDF encrypts data with a key derived as kdf says. With
+// other iterations than KeyDerivationIterations the result starts with a
+// header recording them, so DecryptBytes doesn't need to be told them. The
+// header is authenticated along with data.
+func EncryptBytesWithKDF(data []byte, passphrase string, kdf KDFSettings) ([]byte, error) {
+	iterations := kdf.iterations()
+	if iterations < KeyDerivationIterations {
+		return []byte{}, errors.New(ErrKDFIterationsTooLow)
+	}
+	if iterations > MaxKDFIterations {
+		return []byte{}, errors.New(ErrKDFIterationsTooHigh)
+	}
+	block, _ := aes.NewCipher(DeriveKey(passphrase, iterations))
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		return []byte{}, err
 	}
+	var header []byte
+	if iterations != KeyDerivationIterations {
+		header = backupHeader(iterations)
+	}
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
 		return []byte{}, err
 	}
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
+	ciphertext := gcm.Seal(append(header, nonce...), nonce, data, header)
 	return ciphertext, nil
 }
 
+// DecryptBytes decrypts data made by EncryptBytesWithKDF, or by a version
+// of Portwarden that didn't write the header yet.
 func DecryptBytes(data []byte, passphrase string) ([]byte, error) {
-	key := DeriveKey(passphrase)
+	if iterations, ok := parseBackupHeader(data); ok {
+		err := fmt.Errorf("%v: %v", ErrBackupHeaderIterations, iterations)
+		if validKDFIterations(iterations) {
+			var plaintext []byte
+			if plaintext, err = decryptBytes(data[backupHeaderSize:], data[:backupHeaderSize], passphrase, int(iterations)); err == nil {
+				return plaintext, nil
+			}
+		}
+		// the random nonce of a backup without a header may start like one
+		if legacy, legacyErr := decryptBytes(data, nil, passphrase, KeyDerivationIterations); legacyErr == nil {
+			return legacy, nil
+		}
+		return []byte{}, err
+	}
+	return decryptBytes(data, nil, passphrase, KeyDerivationIterations)
+}
+
+func decryptBytes(data, additionalData []byte, passphrase string, iterations int) ([]byte, error) {
+	key := DeriveKey(passphrase, iterations)
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return []byte{}, err
@@ -48,8 +130,11 @@ func DecryptBytes(data []byte, passphrase string) ([]byte, error) {
 		return []byte{}, err
 	}
 	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return []byte{}, errors.New(ErrWrongBackupPassphrase)
+	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		if err.Error() == ErrMessageAuthenticationFailed {
 			return []byte{}, errors.New(ErrWrongBackupPassphrase)
@@ -0,0 +1,79 @@
+package portwarden
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecryptBytesReadsIterationsFromHeader(t *testing.T) {
+	data := []byte("backup")
+	encrypted, err := EncryptBytesWithKDF(data, "passphrase", KDFSettings{Iterations: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iterations, ok := parseBackupHeader(encrypted); !ok || iterations != 5000 {
+		t.Fatalf("parseBackupHeader = %v, %v, want 5000, true", iterations, ok)
+	}
+	decrypted, err := DecryptBytes(encrypted, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("DecryptBytes = %q, want %q", decrypted, data)
+	}
+	if _, err := DecryptBytes(encrypted, "wrong"); err == nil || err.Error() != ErrWrongBackupPassphrase {
+		t.Errorf("DecryptBytes with the wrong passphrase = %v, want %v", err, ErrWrongBackupPassphrase)
+	}
+	// the iterations are authenticated
+	tampered := append([]byte{}, encrypted...)
+	tampered[backupHeaderSize-1]++
+	if _, err := DecryptBytes(tampered, "passphrase"); err == nil {
+		t.Error("DecryptBytes accepted a tampered header")
+	}
+}
+
+func TestEncryptBytesDefaultHasNoHeader(t *testing.T) {
+	encrypted, err := EncryptBytes([]byte("backup"), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parseBackupHeader(encrypted); ok {
+		t.Error("a backup with the default iterations has a header, older versions can't read it")
+	}
+	if _, err := EncryptBytesWithKDF([]byte("backup"), "passphrase", KDFSettings{Iterations: MaxKDFIterations + 1}); err == nil {
+		t.Error("EncryptBytesWithKDF accepted too many iterations")
+	}
+}
+
+func TestDecryptBytesRejectsIterationsOutOfRange(t *testing.T) {
+	for _, iterations := range []uint32{1, KeyDerivationIterations - 1, MaxKDFIterations + 1, 0xFFFFFFFF} {
+		data := append(backupHeader(int(iterations)), make([]byte, 64)...)
+		start := time.Now()
+		_, err := DecryptBytes(data, "passphrase")
+		if err == nil || !strings.Contains(err.Error(), ErrBackupHeaderIterations) {
+			t.Errorf("DecryptBytes with %v iterations = %v, want %v", iterations, err, ErrBackupHeaderIterations)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("DecryptBytes with %v iterations took %v", iterations, time.Since(start))
+		}
+	}
+}
+
+func TestDecryptBytesWithoutHeader(t *testing.T) {
+	data := []byte("backup")
+	block, _ := aes.NewCipher(DeriveKey("passphrase", KeyDerivationIterations))
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	legacy := gcm.Seal(nonce, nonce, data, nil)
+	decrypted, err := DecryptBytes(legacy, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("DecryptBytes = %q, want %q", decrypted, data)
+	}
+}
@@ -0,0 +1,359 @@
+package portwarden
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	DefaultExpiryMonths = 3
+
+	ExpiryExpired  = "expired"
+	ExpiryExpiring = "expiring"
+	ExpiryUnknown  = "no expiration"
+
+	CardProblemInvalidNumber = "invalid number"
+	CardProblemBrandMismatch = "brand mismatch"
+	CardProblemExpiration    = "invalid expiration"
+
+	DocumentPassport = "Passport"
+	DocumentLicense  = "License"
+)
+
+// ExpiryOptions configures AuditExpiry.
+type ExpiryOptions struct {
+	// Months flags cards and documents expiring within this many months
+	Months int
+	Now    time.Time
+}
+
+// ExpiringCard is a card that expired or expires within ExpiryOptions.Months.
+type ExpiringCard struct {
+	AuditItem
+	Brand      string `json:"brand"`
+	Last4      string `json:"last4"`
+	Expiration string `json:"expiration"`
+	Status     string `json:"status"`
+	// MonthsLeft is negative for expired cards, 0 for the current month
+	MonthsLeft int `json:"monthsLeft"`
+}
+
+// InvalidCard is a card whose number fails the Luhn check, doesn't match
+// its brand or whose expiration can't be read.
+type InvalidCard struct {
+	AuditItem
+	Brand   string `json:"brand"`
+	Last4   string `json:"last4"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// IdentityDocument is a passport or license number of an identity. Bitwarden
+// has no expiration for them, so it's read from a custom field with
+// "expir" in its name, e.g. "Passport Expiration".
+type IdentityDocument struct {
+	AuditItem
+	Document   string `json:"document"`
+	Last4      string `json:"last4"`
+	Expiration string `json:"expiration,omitempty"`
+	Status     string `json:"status"`
+}
+
+// ExpiryReport is the result of AuditExpiry. It only holds the last four
+// digits of card and document numbers.
+type ExpiryReport struct {
+	Cards        []ExpiringCard     `json:"cards"`
+	InvalidCards []InvalidCard      `json:"invalidCards"`
+	Documents    []IdentityDocument `json:"documents"`
+}
+
+// Empty tells whether there is nothing to report.
+func (r *ExpiryReport) Empty() bool {
+	return len(r.Cards) == 0 && len(r.InvalidCards) == 0 && len(r.Documents) == 0
+}
+
+// AuditExpiry lists the cards of b that expired or expire soon, cards with
+// malformed numbers, and passports and licenses that expired, expire soon or
+// have no recorded expiration.
+func AuditExpiry(b *Backup, opts ExpiryOptions) *ExpiryReport {
+	if opts.Months == 0 {
+		opts.Months = DefaultExpiryMonths
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	r := &ExpiryReport{Cards: []ExpiringCard{}, InvalidCards: []InvalidCard{}, Documents: []IdentityDocument{}}
+	for _, item := range b.Items {
+		ai := AuditItem{ID: item.ID, Name: item.Name}
+		if item.Card != nil {
+			auditCard(r, ai, item.Card, opts)
+		}
+		if item.Identity != nil {
+			auditDocuments(r, ai, item, opts)
+		}
+	}
+	return r
+}
+
+func auditCard(r *ExpiryReport, ai AuditItem, card *Card, opts ExpiryOptions) {
+	number := strings.Map(func(c rune) rune {
+		if c == ' ' || c == '-' {
+			return -1
+		}
+		return c
+	}, card.Number)
+	last4 := lastDigits(number, 4)
+	invalid := func(problem, detail string) {
+		r.InvalidCards = append(r.InvalidCards, InvalidCard{AuditItem: ai, Brand: card.Brand, Last4: last4, Problem: problem, Detail: detail})
+	}
+
+	if len(number) > 0 {
+		if !Luhn(number) {
+			invalid(CardProblemInvalidNumber, "fails the Luhn check")
+		} else if brand := CardBrand(number); len(brand) > 0 && len(card.Brand) > 0 && card.Brand != "Other" && !strings.EqualFold(brand, card.Brand) {
+			invalid(CardProblemBrandMismatch, "the number looks like "+brand)
+		}
+	}
+
+	if len(card.ExpMonth) == 0 && len(card.ExpYear) == 0 {
+		return
+	}
+	month, errMonth := strconv.Atoi(card.ExpMonth)
+	year, errYear := strconv.Atoi(card.ExpYear)
+	if errMonth != nil || errYear != nil || month < 1 || month > 12 {
+		invalid(CardProblemExpiration, strings.Trim(card.ExpMonth+"/"+card.ExpYear, "/"))
+		return
+	}
+	if year < 100 {
+		year += 2000
+	}
+	monthsLeft := (year*12 + month) - (opts.Now.Year()*12 + int(opts.Now.Month()))
+	status := expiryStatus(monthsLeft, opts.Months)
+	if len(status) > 0 {
+		r.Cards = append(r.Cards, ExpiringCard{
+			AuditItem:  ai,
+			Brand:      card.Brand,
+			Last4:      last4,
+			Expiration: fmt.Sprintf("%02d/%d", month, year),
+			Status:     status,
+			MonthsLeft: monthsLeft,
+		})
+	}
+}
+
+// expiryStatus classifies something valid through the end of the month
+// monthsLeft months from now. It is expiring when that month is at most
+// months away, so with months 3 a card expiring in January counts in October.
+func expiryStatus(monthsLeft, months int) string {
+	switch {
+	case monthsLeft < 0:
+		return ExpiryExpired
+	case monthsLeft <= months:
+		return ExpiryExpiring
+	}
+	return ""
+}
+
+func auditDocuments(r *ExpiryReport, ai AuditItem, item PortWardenElement, opts ExpiryOptions) {
+	documents := map[string]string{
+		DocumentPassport: identityText(item.Identity.PassportNumber),
+		DocumentLicense:  identityText(item.Identity.LicenseNumber),
+	}
+	for _, document := range []string{DocumentPassport, DocumentLicense} {
+		number := documents[document]
+		if len(number) == 0 {
+			continue
+		}
+		doc := IdentityDocument{AuditItem: ai, Document: document, Last4: lastDigits(number, 4), Status: ExpiryUnknown}
+		if expiration, ok := documentExpiration(item, document); ok {
+			doc.Expiration = expiration.Format("2006-01-02")
+			monthsLeft := (expiration.Year()*12 + int(expiration.Month())) - (opts.Now.Year()*12 + int(opts.Now.Month()))
+			if monthsLeft == 0 && expiration.Before(opts.Now) {
+				monthsLeft = -1
+			}
+			doc.Status = expiryStatus(monthsLeft, opts.Months)
+			if len(doc.Status) == 0 {
+				continue
+			}
+		}
+		r.Documents = append(r.Documents, doc)
+	}
+}
+
+var (
+	documentDayLayouts   = []string{"2006-01-02", "02.01.2006", "2 January 2006", "January 2, 2006", "2 Jan 2006", "Jan 2, 2006"}
+	documentMonthLayouts = []string{"2006-01", "01/2006", "01/06", "January 2006", "Jan 2006"}
+)
+
+// documentExpiration reads the expiration of a document from the custom
+// fields of item. A field naming the document wins over a generic one.
+func documentExpiration(item PortWardenElement, document string) (time.Time, bool) {
+	keyword := strings.ToLower(document)[:5] // "passp" or "licen"
+	var generic, specific *time.Time
+	for _, field := range item.Fields {
+		name := strings.ToLower(stringValue(field.Name))
+		if !strings.Contains(name, "expir") {
+			continue
+		}
+		t, ok := parseDocumentDate(stringValue(field.Value))
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.Contains(name, keyword):
+			specific = &t
+		case !strings.Contains(name, "passp") && !strings.Contains(name, "licen"):
+			generic = &t
+		}
+	}
+	if specific != nil {
+		return *specific, true
+	}
+	if generic != nil {
+		return *generic, true
+	}
+	return time.Time{}, false
+}
+
+func parseDocumentDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range documentDayLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	for _, layout := range documentMonthLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			// a month is valid through its last day
+			return t.AddDate(0, 1, -1), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func lastDigits(number string, n int) string {
+	number = strings.TrimSpace(number)
+	if len(number) <= n {
+		return number
+	}
+	return number[len(number)-n:]
+}
+
+// Luhn tells whether number, digits only, passes the Luhn checksum of card
+// numbers.
+func Luhn(number string) bool {
+	if len(number) < 12 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range number {
+		c := number[len(number)-1-i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// CardBrand tells the brand of a card number by its prefix, named the way
+// Bitwarden names brands, or "" if it doesn't know it.
+func CardBrand(number string) string {
+	prefix := func(n int) int {
+		if len(number) < n {
+			return -1
+		}
+		p, err := strconv.Atoi(number[:n])
+		if err != nil {
+			return -1
+		}
+		return p
+	}
+	between := func(n, lo, hi int) bool {
+		p := prefix(n)
+		return p >= lo && p <= hi
+	}
+	switch {
+	case prefix(1) == 4:
+		return "Visa"
+	case between(2, 51, 55), between(4, 2221, 2720):
+		return "Mastercard"
+	case prefix(2) == 34, prefix(2) == 37:
+		return "Amex"
+	case prefix(4) == 6011, prefix(2) == 65, between(3, 644, 649):
+		return "Discover"
+	case between(4, 3528, 3589):
+		return "JCB"
+	case between(3, 300, 305), prefix(2) == 36, prefix(2) == 38, prefix(2) == 39:
+		return "Diners Club"
+	case prefix(2) == 62:
+		return "UnionPay"
+	case prefix(2) == 50, between(2, 56, 58), prefix(1) == 6:
+		return "Maestro"
+	}
+	return ""
+}
+
+// WriteExpiryReport writes r as tables or as JSON.
+func WriteExpiryReport(r *ExpiryReport, w io.Writer, format string) error {
+	switch format {
+	case AuditFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case AuditFormatTable:
+	default:
+		return errors.New(ErrUnknownAuditFormat + ": " + format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	section := func(title string, count int, header string) {
+		fmt.Fprintf(tw, "%v (%v)\n", title, count)
+		if count > 0 {
+			fmt.Fprintln(tw, header)
+		}
+	}
+
+	section("Expired and expiring cards", len(r.Cards), "NAME\tID\tBRAND\tNUMBER\tEXPIRES\tSTATUS")
+	for _, card := range r.Cards {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t*%v\t%v\t%v\n", card.Name, card.ID, card.Brand, card.Last4, card.Expiration, card.Status)
+	}
+
+	fmt.Fprintln(tw)
+	section("Invalid cards", len(r.InvalidCards), "NAME\tID\tBRAND\tNUMBER\tPROBLEM")
+	for _, card := range r.InvalidCards {
+		problem := card.Problem
+		if len(card.Detail) > 0 {
+			problem += ": " + card.Detail
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t*%v\t%v\n", card.Name, card.ID, card.Brand, card.Last4, problem)
+	}
+
+	fmt.Fprintln(tw)
+	section("Identity documents", len(r.Documents), "NAME\tID\tDOCUMENT\tNUMBER\tEXPIRES\tSTATUS")
+	for _, doc := range r.Documents {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t*%v\t%v\t%v\n", doc.Name, doc.ID, doc.Document, doc.Last4, doc.Expiration, doc.Status)
+	}
+	return tw.Flush()
+}
+
+// identityText returns an untyped value of an identity as text.
+func identityText(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,122 @@
+package portwarden
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLuhn(t *testing.T) {
+	for _, tc := range []struct {
+		number string
+		want   bool
+	}{
+		{"4111111111111111", true},
+		{"378282246310005", true},
+		{"6011111111111117", true},
+		{"4111111111111112", false},
+		{"41111111111111a1", false},
+		// too short or too long for a card, even with a valid checksum
+		{"00", false},
+		{"00000000000000000000", false},
+	} {
+		if got := Luhn(tc.number); got != tc.want {
+			t.Errorf("Luhn(%q) = %v, want %v", tc.number, got, tc.want)
+		}
+	}
+}
+
+func TestCardBrand(t *testing.T) {
+	for _, tc := range []struct {
+		number string
+		want   string
+	}{
+		{"4111111111111111", "Visa"},
+		{"5555555555554444", "Mastercard"},
+		{"2223003122003222", "Mastercard"},
+		{"378282246310005", "Amex"},
+		{"6011111111111117", "Discover"},
+		{"6445644564456445", "Discover"},
+		{"3530111333300000", "JCB"},
+		{"30569309025904", "Diners Club"},
+		{"6200000000000005", "UnionPay"},
+		{"6759649826438453", "Maestro"},
+		{"1234567812345670", ""},
+		{"", ""},
+	} {
+		if got := CardBrand(tc.number); got != tc.want {
+			t.Errorf("CardBrand(%q) = %q, want %q", tc.number, got, tc.want)
+		}
+	}
+}
+
+func TestExpiryStatus(t *testing.T) {
+	for _, tc := range []struct {
+		monthsLeft, months int
+		want               string
+	}{
+		{-1, 3, ExpiryExpired},
+		{0, 3, ExpiryExpiring},
+		{2, 3, ExpiryExpiring},
+		{3, 3, ExpiryExpiring},
+		{4, 3, ""},
+		{12, 12, ExpiryExpiring},
+	} {
+		if got := expiryStatus(tc.monthsLeft, tc.months); got != tc.want {
+			t.Errorf("expiryStatus(%v, %v) = %q, want %q", tc.monthsLeft, tc.months, got, tc.want)
+		}
+	}
+}
+
+func TestAuditExpiry(t *testing.T) {
+	card := func(id, brand, number, month, year string) PortWardenElement {
+		return PortWardenElement{ID: id, Name: id, Type: ItemTypeCard,
+			Card: &Card{Brand: brand, Number: number, ExpMonth: month, ExpYear: year}}
+	}
+	identity := func(id string, passport, license interface{}, fields ...Field) PortWardenElement {
+		return PortWardenElement{ID: id, Name: id, Type: ItemTypeIdentity, Fields: fields,
+			Identity: &Identity{PassportNumber: passport, LicenseNumber: license}}
+	}
+	b := &Backup{Items: PortWarden{
+		card("expired", "Visa", "4111 1111 1111 1111", "9", "2026"),
+		card("this-month", "Visa", "4111-1111-1111-1111", "10", "26"),
+		card("in-3-months", "Mastercard", "5555555555554444", "1", "27"),
+		card("in-4-months", "Mastercard", "5555555555554444", "02", "2027"),
+		card("mismatch", "Mastercard", "4111111111111111", "", ""),
+		card("other", "Other", "4111111111111111", "", ""),
+		card("bad-number", "Visa", "4111111111111112", "", ""),
+		card("bad-month", "Visa", "4111111111111111", "13", "2027"),
+		identity("traveller", "X1234567", "D99",
+			Field{Name: strPtr("Passport Expiration"), Value: strPtr("2027-01-10")}),
+		identity("expired-passport", "P7654321", nil,
+			Field{Name: strPtr("Expires"), Value: strPtr("2026-10-01")}),
+		identity("far-off", "P5555", nil,
+			Field{Name: strPtr("Expiry"), Value: strPtr("03/2030")}),
+	}}
+	r := AuditExpiry(b, ExpiryOptions{Now: time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)})
+
+	wantCards := []ExpiringCard{
+		{AuditItem: AuditItem{ID: "expired", Name: "expired"}, Brand: "Visa", Last4: "1111", Expiration: "09/2026", Status: ExpiryExpired, MonthsLeft: -1},
+		{AuditItem: AuditItem{ID: "this-month", Name: "this-month"}, Brand: "Visa", Last4: "1111", Expiration: "10/2026", Status: ExpiryExpiring, MonthsLeft: 0},
+		{AuditItem: AuditItem{ID: "in-3-months", Name: "in-3-months"}, Brand: "Mastercard", Last4: "4444", Expiration: "01/2027", Status: ExpiryExpiring, MonthsLeft: 3},
+	}
+	if !reflect.DeepEqual(r.Cards, wantCards) {
+		t.Errorf("Cards = %+v, want %+v", r.Cards, wantCards)
+	}
+	wantInvalid := []InvalidCard{
+		{AuditItem: AuditItem{ID: "mismatch", Name: "mismatch"}, Brand: "Mastercard", Last4: "1111", Problem: CardProblemBrandMismatch, Detail: "the number looks like Visa"},
+		{AuditItem: AuditItem{ID: "bad-number", Name: "bad-number"}, Brand: "Visa", Last4: "1112", Problem: CardProblemInvalidNumber, Detail: "fails the Luhn check"},
+		{AuditItem: AuditItem{ID: "bad-month", Name: "bad-month"}, Brand: "Visa", Last4: "1111", Problem: CardProblemExpiration, Detail: "13/2027"},
+	}
+	if !reflect.DeepEqual(r.InvalidCards, wantInvalid) {
+		t.Errorf("InvalidCards = %+v, want %+v", r.InvalidCards, wantInvalid)
+	}
+	wantDocuments := []IdentityDocument{
+		{AuditItem: AuditItem{ID: "traveller", Name: "traveller"}, Document: DocumentPassport, Last4: "4567", Expiration: "2027-01-10", Status: ExpiryExpiring},
+		{AuditItem: AuditItem{ID: "traveller", Name: "traveller"}, Document: DocumentLicense, Last4: "D99", Status: ExpiryUnknown},
+		{AuditItem: AuditItem{ID: "expired-passport", Name: "expired-passport"}, Document: DocumentPassport, Last4: "4321", Expiration: "2026-10-01", Status: ExpiryExpired},
+	}
+	if !reflect.DeepEqual(r.Documents, wantDocuments) {
+		t.Errorf("Documents = %+v, want %+v", r.Documents, wantDocuments)
+	}
+}
@@ -0,0 +1,149 @@
+package portwarden
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	ExportFormatBitwardenJSON = "bitwarden-json"
+	ExportFormatBitwardenCSV  = "bitwarden-csv"
+
+	ItemTypeLogin      = 1
+	ItemTypeSecureNote = 2
+	ItemTypeCard       = 3
+	ItemTypeIdentity   = 4
+
+	FieldTypeText    = 0
+	FieldTypeHidden  = 1
+	FieldTypeBoolean = 2
+)
+
+// bitwardenExport is the unencrypted JSON format accepted by Bitwarden's
+// importer ("Bitwarden (json)").
+type bitwardenExport struct {
+	Encrypted bool                    `json:"encrypted"`
+	Folders   []bitwardenExportFolder `json:"folders"`
+	Items     []bitwardenExportItem   `json:"items"`
+}
+
+type bitwardenExportFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenExportItem struct {
+	ID              string            `json:"id"`
+	OrganizationID  *string           `json:"organizationId"`
+	FolderID        *string           `json:"folderId"`
+	Type            int64             `json:"type"`
+	Reprompt        int               `json:"reprompt"`
+	Name            string            `json:"name"`
+	Notes           *string           `json:"notes"`
+	Favorite        bool              `json:"favorite"`
+	Login           *Login            `json:"login,omitempty"`
+	SecureNote      *SecureNote       `json:"secureNote,omitempty"`
+	Card            *Card             `json:"card,omitempty"`
+	Identity        *Identity         `json:"identity,omitempty"`
+	Fields          []Field           `json:"fields,omitempty"`
+	PasswordHistory []PasswordHistory `json:"passwordHistory"`
+	CollectionIDS   []string          `json:"collectionIds"`
+}
+
+// ExportBitwardenJSON writes the items and folders of b in the format of
+// Bitwarden's own unencrypted JSON export. Attachments aren't part of that
+// format and are left out.
+func ExportBitwardenJSON(b *Backup, w io.Writer) error {
+	export := bitwardenExport{
+		Folders: []bitwardenExportFolder{},
+		Items:   []bitwardenExportItem{},
+	}
+	for _, folder := range b.Folders {
+		if folder.ID == nil {
+			continue
+		}
+		export.Folders = append(export.Folders, bitwardenExportFolder{ID: *folder.ID, Name: folder.Name})
+	}
+	for _, item := range b.Items {
+		export.Items = append(export.Items, bitwardenExportItem{
+			ID:              item.ID,
+			FolderID:        item.FolderID,
+			Type:            item.Type,
+			Name:            item.Name,
+			Notes:           item.Notes,
+			Favorite:        item.Favorite,
+			Login:           item.Login,
+			SecureNote:      item.SecureNote,
+			Card:            item.Card,
+			Identity:        item.Identity,
+			Fields:          item.Fields,
+			PasswordHistory: item.PasswordHistory,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// ExportBitwardenCSV writes the logins and secure notes of b in the format
+// of Bitwarden's CSV export. The CSV format has no room for cards and
+// identities, so they are skipped and returned.
+func ExportBitwardenCSV(b *Backup, w io.Writer) ([]PortWardenElement, error) {
+	var skipped []PortWardenElement
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"folder", "favorite", "type", "name", "notes", "fields", "reprompt", "login_uri", "login_username", "login_password", "login_totp"})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range b.Items {
+		var itemType string
+		switch item.Type {
+		case ItemTypeLogin:
+			itemType = "login"
+		case ItemTypeSecureNote:
+			itemType = "note"
+		default:
+			skipped = append(skipped, item)
+			continue
+		}
+		favorite := ""
+		if item.Favorite {
+			favorite = "1"
+		}
+		var fields []string
+		for _, field := range item.Fields {
+			fields = append(fields, fmt.Sprintf("%v: %v", stringValue(field.Name), stringValue(field.Value)))
+		}
+		var uris []string
+		var username, password, totp string
+		if item.Login != nil {
+			for _, uri := range item.Login.Uris {
+				uris = append(uris, uri.URI)
+			}
+			username = stringValue(item.Login.Username)
+			password = stringValue(item.Login.Password)
+			totp = stringValue(item.Login.Totp)
+		}
+		err := cw.Write([]string{
+			b.FolderName(item.FolderID),
+			favorite,
+			itemType,
+			item.Name,
+			stringValue(item.Notes),
+			strings.Join(fields, "\n"),
+			"0",
+			strings.Join(uris, ","),
+			username,
+			password,
+			totp,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	cw.Flush()
+	return skipped, cw.Error()
+}
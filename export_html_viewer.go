@@ -0,0 +1,461 @@
+package portwarden
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+)
+
+const (
+	ExportFormatHTMLViewer = "html-viewer"
+)
+
+type htmlViewer struct {
+	Backup           string
+	Salt             string
+	Magic            string
+	Version          int
+	LegacyIterations int
+	MaxIterations    int
+}
+
+// ExportHTMLViewer writes a single HTML page that embeds encrypted, the
+// content of a .portwarden file, as it is. The page derives the key and
+// decrypts the archive in the browser with WebCrypto, the same PBKDF2 and
+// AES-GCM as DeriveKey and DecryptBytes with the iterations from the header
+// of the backup, unzips it and shows a searchable,
+// read-only list of the items. It works offline and never sends anything.
+func ExportHTMLViewer(encrypted []byte, w io.Writer) error {
+	return htmlViewerTemplate.Execute(w, htmlViewer{
+		Backup:           base64.StdEncoding.EncodeToString(encrypted),
+		Salt:             base64.StdEncoding.EncodeToString([]byte(Salt)),
+		Magic:            BackupHeaderMagic,
+		Version:          BackupHeaderVersion,
+		LegacyIterations: KeyDerivationIterations,
+		MaxIterations:    MaxKDFIterations,
+	})
+}
+
+var htmlViewerTemplate = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="Content-Security-Policy" content="default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'">
+<title>Portwarden Backup</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+input { font-size: 1.1em; padding: 0.3em; width: 100%; box-sizing: border-box; margin: 0.5em 0; }
+button { font-size: 1em; }
+.error { color: #b00; }
+.folder { color: #666; font-size: 0.85em; margin-left: 0.5em; }
+details { border-bottom: 1px solid #ddd; padding: 0.4em 0; }
+summary { cursor: pointer; }
+table { border-collapse: collapse; margin: 0.5em 0; }
+th { text-align: left; vertical-align: top; padding: 0.1em 1em 0.1em 0; white-space: nowrap; }
+td { font-family: monospace; word-break: break-all; }
+.secret { filter: blur(4px); cursor: pointer; }
+pre { white-space: pre-wrap; background: #f5f5f5; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>Portwarden Backup</h1>
+<form id="unlock">
+<label for="passphrase">Backup passphrase</label>
+<input id="passphrase" type="password" autocomplete="off" autofocus>
+<button type="submit">Open</button>
+<p id="error" class="error"></p>
+</form>
+<div id="vault" hidden>
+<input id="search" type="search" placeholder="Search">
+<p id="count"></p>
+<div id="items"></div>
+</div>
+<script>
+"use strict";
+var BACKUP = {{.Backup}};
+var SALT = {{.Salt}};
+var HEADER_MAGIC = {{.Magic}};
+var HEADER_VERSION = {{.Version}};
+var LEGACY_ITERATIONS = {{.LegacyIterations}};
+var MAX_ITERATIONS = {{.MaxIterations}};
+var BACKUP_FOLDER = "portwarden_backup/";
+var FIELD_TYPE_HIDDEN = 1;
+var IDENTITY_FIELDS = [["title", "Title"], ["firstName", "First Name"], ["middleName", "Middle Name"],
+  ["lastName", "Last Name"], ["username", "Username"], ["company", "Company"], ["ssn", "SSN"],
+  ["passportNumber", "Passport Number"], ["licenseNumber", "License Number"], ["email", "Email"],
+  ["phone", "Phone"], ["address1", "Address 1"], ["address2", "Address 2"], ["address3", "Address 3"],
+  ["city", "City"], ["state", "State"], ["postalCode", "Postal Code"], ["country", "Country"]];
+
+function fromBase64(s) {
+  var raw = atob(s), bytes = new Uint8Array(raw.length);
+  for (var i = 0; i < raw.length; i++) {
+    bytes[i] = raw.charCodeAt(i);
+  }
+  return bytes;
+}
+
+// parseHeader mirrors parseBackupHeader: the magic, the version and the
+// iterations as a big endian uint32, authenticated along with the archive.
+// Backups without it were derived with LEGACY_ITERATIONS, and iterations out
+// of range aren't tried.
+function parseHeader(data) {
+  var size = HEADER_MAGIC.length + 5;
+  if (data.length >= size && String.fromCharCode.apply(null, data.subarray(0, HEADER_MAGIC.length)) === HEADER_MAGIC &&
+      data[HEADER_MAGIC.length] === HEADER_VERSION) {
+    var iterations = new DataView(data.buffer, data.byteOffset + HEADER_MAGIC.length + 1, 4).getUint32(0);
+    if (iterations >= LEGACY_ITERATIONS && iterations <= MAX_ITERATIONS) {
+      return {iterations: iterations, header: data.subarray(0, size), body: data.subarray(size)};
+    }
+  }
+  return {iterations: LEGACY_ITERATIONS, header: new Uint8Array(0), body: data};
+}
+
+// decrypt mirrors DeriveKey and DecryptBytes: PBKDF2-SHA256 and AES-256-GCM
+// with the nonce in front of the ciphertext.
+function decrypt(passphrase) {
+  var parsed = parseHeader(fromBase64(BACKUP)), data = parsed.body;
+  return crypto.subtle.importKey("raw", new TextEncoder().encode(passphrase), "PBKDF2", false, ["deriveKey"]).then(function (material) {
+    return crypto.subtle.deriveKey({name: "PBKDF2", salt: fromBase64(SALT), iterations: parsed.iterations, hash: "SHA-256"},
+      material, {name: "AES-GCM", length: 256}, false, ["decrypt"]);
+  }).then(function (key) {
+    return crypto.subtle.decrypt({name: "AES-GCM", iv: data.subarray(0, 12), additionalData: parsed.header}, key, data.subarray(12));
+  }).then(function (plain) {
+    return new Uint8Array(plain);
+  });
+}
+
+var LENGTH_BASE = [3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258];
+var LENGTH_EXTRA = [0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0];
+var DIST_BASE = [1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577];
+var DIST_EXTRA = [0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13];
+var CODE_LENGTH_ORDER = [16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15];
+
+function huffman(lengths) {
+  var counts = new Uint16Array(16), offsets = new Uint16Array(16), symbols = new Uint16Array(lengths.length), i;
+  for (i = 0; i < lengths.length; i++) {
+    counts[lengths[i]]++;
+  }
+  counts[0] = 0;
+  for (i = 1; i < 16; i++) {
+    offsets[i] = offsets[i - 1] + counts[i - 1];
+  }
+  for (i = 0; i < lengths.length; i++) {
+    if (lengths[i]) {
+      symbols[offsets[lengths[i]]++] = i;
+    }
+  }
+  return {counts: counts, symbols: symbols};
+}
+
+// inflate decompresses raw DEFLATE data (RFC 1951), which is how the backup
+// archive stores its files.
+function inflate(data) {
+  var out = new Uint8Array(data.length * 3 + 1024), outLen = 0, pos = 0, bitBuf = 0, bitCount = 0;
+  function bits(n) {
+    while (bitCount < n) {
+      if (pos >= data.length) {
+        throw new Error("corrupted archive");
+      }
+      bitBuf |= data[pos++] << bitCount;
+      bitCount += 8;
+    }
+    var v = bitBuf & ((1 << n) - 1);
+    bitBuf >>>= n;
+    bitCount -= n;
+    return v;
+  }
+  function put(b) {
+    if (outLen === out.length) {
+      var grown = new Uint8Array(out.length * 2);
+      grown.set(out);
+      out = grown;
+    }
+    out[outLen++] = b;
+  }
+  function decode(h) {
+    var code = 0, first = 0, index = 0;
+    for (var len = 1; len < 16; len++) {
+      code |= bits(1);
+      var count = h.counts[len];
+      if (code - count < first) {
+        return h.symbols[index + code - first];
+      }
+      index += count;
+      first = (first + count) << 1;
+      code <<= 1;
+    }
+    throw new Error("corrupted archive");
+  }
+
+  var fixedLengths = new Uint8Array(288), i;
+  for (i = 0; i < 288; i++) {
+    fixedLengths[i] = i < 144 ? 8 : i < 256 ? 9 : i < 280 ? 7 : 8;
+  }
+  var fixedLit = huffman(fixedLengths), fixedDist = huffman(new Uint8Array(30).fill(5));
+
+  var last;
+  do {
+    last = bits(1);
+    var type = bits(2), lit, dist, sym;
+    if (type === 0) {
+      bitBuf = 0;
+      bitCount = 0;
+      var stored = data[pos] | data[pos + 1] << 8;
+      pos += 4;
+      for (i = 0; i < stored; i++) {
+        put(data[pos++]);
+      }
+      continue;
+    } else if (type === 1) {
+      lit = fixedLit;
+      dist = fixedDist;
+    } else if (type === 2) {
+      var nlen = bits(5) + 257, ndist = bits(5) + 1, ncode = bits(4) + 4;
+      var lengths = new Uint8Array(19);
+      for (i = 0; i < ncode; i++) {
+        lengths[CODE_LENGTH_ORDER[i]] = bits(3);
+      }
+      var lengthCode = huffman(lengths);
+      lengths = new Uint8Array(nlen + ndist);
+      for (i = 0; i < nlen + ndist;) {
+        sym = decode(lengthCode);
+        if (sym < 16) {
+          lengths[i++] = sym;
+          continue;
+        }
+        var repeat = 0, n;
+        if (sym === 16) {
+          repeat = lengths[i - 1];
+          n = 3 + bits(2);
+        } else if (sym === 17) {
+          n = 3 + bits(3);
+        } else {
+          n = 11 + bits(7);
+        }
+        while (n--) {
+          lengths[i++] = repeat;
+        }
+      }
+      lit = huffman(lengths.subarray(0, nlen));
+      dist = huffman(lengths.subarray(nlen));
+    } else {
+      throw new Error("corrupted archive");
+    }
+    for (;;) {
+      sym = decode(lit);
+      if (sym < 256) {
+        put(sym);
+      } else if (sym === 256) {
+        break;
+      } else {
+        sym -= 257;
+        var length = LENGTH_BASE[sym] + bits(LENGTH_EXTRA[sym]);
+        var d = decode(dist);
+        var distance = DIST_BASE[d] + bits(DIST_EXTRA[d]);
+        for (i = 0; i < length; i++) {
+          put(out[outLen - distance]);
+        }
+      }
+    }
+  } while (!last);
+  return out.subarray(0, outLen);
+}
+
+// unzip reads the central directory of the archive and returns a function
+// giving the content of a file by its path relative to the backup folder.
+function unzip(zip) {
+  var view = new DataView(zip.buffer, zip.byteOffset, zip.byteLength), decoder = new TextDecoder();
+  var end = zip.length - 22;
+  while (end >= 0 && view.getUint32(end, true) !== 0x06054b50) {
+    end--;
+  }
+  if (end < 0) {
+    throw new Error("not a backup archive");
+  }
+  var entries = {}, count = view.getUint16(end + 10, true), offset = view.getUint32(end + 16, true);
+  for (var i = 0; i < count; i++) {
+    var nameLength = view.getUint16(offset + 28, true);
+    var name = decoder.decode(zip.subarray(offset + 46, offset + 46 + nameLength));
+    var local = view.getUint32(offset + 42, true);
+    var start = local + 30 + view.getUint16(local + 26, true) + view.getUint16(local + 28, true);
+    if (name.indexOf(BACKUP_FOLDER) === 0) {
+      name = name.substring(BACKUP_FOLDER.length);
+    }
+    entries[name] = {method: view.getUint16(offset + 10, true), data: zip.subarray(start, start + view.getUint32(offset + 20, true))};
+    offset += 46 + nameLength + view.getUint16(offset + 30, true) + view.getUint16(offset + 32, true);
+  }
+  return function (name) {
+    var entry = entries[name];
+    if (!entry) {
+      return null;
+    }
+    return entry.method === 8 ? inflate(entry.data) : entry.data;
+  };
+}
+
+function readJSON(file, name) {
+  var content = file(name);
+  return content ? JSON.parse(new TextDecoder().decode(content)) : null;
+}
+
+// attachmentPath follows Manifest.AttachmentPath, including the layout of
+// backups made before the manifest existed.
+function attachmentPath(manifest, item, attachment) {
+  var found = ((manifest && manifest.attachments) || []).filter(function (ma) {
+    return ma.itemId === item.id && ma.attachmentId === attachment.id;
+  });
+  return found.length ? found[0].path : item.name.trim() + "/" + attachment.fileName;
+}
+
+function element(tag, text, className) {
+  var e = document.createElement(tag);
+  if (text !== undefined) {
+    e.textContent = text;
+  }
+  if (className) {
+    e.className = className;
+  }
+  return e;
+}
+
+function itemRows(item) {
+  var rows = [];
+  function add(name, value, secret) {
+    if (value !== null && value !== undefined && value !== "") {
+      rows.push({name: name, value: String(value), secret: secret});
+    }
+  }
+  if (item.login) {
+    (item.login.uris || []).forEach(function (u) {
+      add("URI", u.uri);
+    });
+    add("Username", item.login.username);
+    add("Password", item.login.password, true);
+    add("TOTP", item.login.totp, true);
+  }
+  if (item.card) {
+    add("Cardholder Name", item.card.cardholderName);
+    add("Brand", item.card.brand);
+    add("Number", item.card.number, true);
+    add("Expiration", [item.card.expMonth, item.card.expYear].filter(Boolean).join(" / "));
+    add("Security Code", item.card.code, true);
+  }
+  if (item.identity) {
+    IDENTITY_FIELDS.forEach(function (f) {
+      add(f[1], item.identity[f[0]], f[0] === "ssn");
+    });
+  }
+  (item.fields || []).forEach(function (f) {
+    add(f.name || "", f.value, f.type === FIELD_TYPE_HIDDEN);
+  });
+  return rows;
+}
+
+function renderItem(file, manifest, item, folderName, rows) {
+  var details = element("details"), summary = element("summary", item.name);
+  if (folderName) {
+    summary.appendChild(element("span", folderName, "folder"));
+  }
+  details.appendChild(summary);
+  var table = element("table");
+  rows.forEach(function (row) {
+    var tr = element("tr"), td = element("td", row.value, row.secret ? "secret" : "");
+    if (row.secret) {
+      td.title = "Click to show";
+      td.onclick = function () {
+        td.classList.toggle("secret");
+      };
+    }
+    tr.appendChild(element("th", row.name));
+    tr.appendChild(td);
+    table.appendChild(tr);
+  });
+  details.appendChild(table);
+  if (item.notes) {
+    details.appendChild(element("pre", item.notes));
+  }
+  (item.attachments || []).forEach(function (attachment) {
+    var p = element("p"), a = element("a", attachment.fileName + " (" + attachment.sizeName + ")");
+    a.href = "#";
+    a.onclick = function (e) {
+      e.preventDefault();
+      var content = file(attachmentPath(manifest, item, attachment));
+      if (!content) {
+        alert("The attachment is missing from the backup.");
+        return;
+      }
+      var link = element("a");
+      link.href = URL.createObjectURL(new Blob([content]));
+      link.download = attachment.fileName;
+      link.click();
+      setTimeout(function () {
+        URL.revokeObjectURL(link.href);
+      }, 1000);
+    };
+    p.appendChild(a);
+    details.appendChild(p);
+  });
+  return details;
+}
+
+function showVault(zip) {
+  var file = unzip(zip);
+  var items = readJSON(file, "items.json") || [], folders = readJSON(file, "folders.json") || [];
+  var manifest = readJSON(file, "manifest.json"), folderNames = {};
+  folders.forEach(function (f) {
+    folderNames[f.id] = f.name;
+  });
+  items.sort(function (a, b) {
+    return a.name.toLowerCase() < b.name.toLowerCase() ? -1 : 1;
+  });
+  var entries = items.map(function (item) {
+    var folderName = folderNames[item.folderId] || "", rows = itemRows(item);
+    var text = [item.name, folderName, item.notes || ""].concat(rows.filter(function (row) {
+      return !row.secret;
+    }).map(function (row) {
+      return row.value;
+    })).join("\n").toLowerCase();
+    return {node: renderItem(file, manifest, item, folderName, rows), text: text};
+  });
+  var list = document.getElementById("items"), count = document.getElementById("count");
+  entries.forEach(function (entry) {
+    list.appendChild(entry.node);
+  });
+  function filter() {
+    var query = document.getElementById("search").value.toLowerCase(), shown = 0;
+    entries.forEach(function (entry) {
+      entry.node.hidden = entry.text.indexOf(query) < 0;
+      shown += entry.node.hidden ? 0 : 1;
+    });
+    count.textContent = shown + " of " + entries.length + " items";
+  }
+  document.getElementById("search").oninput = filter;
+  filter();
+  document.getElementById("unlock").hidden = true;
+  document.getElementById("vault").hidden = false;
+  document.getElementById("search").focus();
+}
+
+document.getElementById("unlock").onsubmit = function (e) {
+  e.preventDefault();
+  var error = document.getElementById("error");
+  if (!window.crypto || !crypto.subtle) {
+    error.textContent = "This browser can't decrypt the backup here, open the file from your disk in a current browser.";
+    return;
+  }
+  error.textContent = "Decrypting...";
+  decrypt(document.getElementById("passphrase").value).then(function (zip) {
+    document.getElementById("passphrase").value = "";
+    error.textContent = "";
+    showVault(zip);
+  }, function () {
+    error.textContent = "Wrong backup passphrase.";
+  }).catch(function (err) {
+    error.textContent = "Can't read the backup: " + err.message;
+  });
+};
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,315 @@
+package portwarden
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+const (
+	ExportFormatPass = "pass"
+
+	PassGPGIDFileName = ".gpg-id"
+
+	ErrPassNoRecipients    = "no recipients found in " + PassGPGIDFileName
+	ErrPassUnknownRecipent = "no public key found for recipient"
+	ErrPassGPGIDMismatch   = "the password store already has a different " + PassGPGIDFileName
+	ErrPassNoKeyring       = "the public keys of the recipients are needed, from a keyring file or gpg"
+)
+
+// PassOptions configures an export to a password-store directory.
+type PassOptions struct {
+	// GPGIDFile lists the recipients, one key id, fingerprint or email per
+	// line. It defaults to the .gpg-id of the store and is copied there if
+	// the store doesn't have one yet.
+	GPGIDFile string
+	// Keyring is a file with the recipients' public keys, armored or binary,
+	// e.g. from `gpg --export --armor`. Without it the keys are exported from
+	// the keyring of gpg.
+	Keyring string
+}
+
+// PassEntry is one file of a password-store export.
+type PassEntry struct {
+	Item PortWardenElement
+	// Path is relative to the store and ends in .gpg
+	Path string
+}
+
+// PassEntries returns where every login of b goes in a password store. Items
+// are placed in their folder, and items whose paths collide all get the start
+// of their id appended, so their names don't depend on the order of the
+// backup.
+func PassEntries(b *Backup) (entries []PassEntry, skipped []PortWardenElement) {
+	byPath := make(map[string][]PortWardenElement)
+	for _, item := range b.Items {
+		if item.Type != ItemTypeLogin {
+			skipped = append(skipped, item)
+			continue
+		}
+		p := strings.ToLower(passPath(b, item))
+		byPath[p] = append(byPath[p], item)
+	}
+	for _, items := range byPath {
+		for _, item := range items {
+			p := passPath(b, item)
+			if len(items) > 1 {
+				id := strings.Replace(item.ID, "-", "", -1)
+				if len(id) > 8 {
+					id = id[:8]
+				}
+				p += "-" + id
+			}
+			entries = append(entries, PassEntry{Item: item, Path: p + ".gpg"})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, skipped
+}
+
+// passPath returns the path of item in the store without the extension.
+func passPath(b *Backup, item PortWardenElement) string {
+	var parts []string
+	for _, part := range strings.Split(b.FolderName(item.FolderID), "/") {
+		if part = passFileName(part); len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	name := passFileName(item.Name)
+	if len(name) == 0 {
+		name = item.ID
+	}
+	return strings.Join(append(parts, name), "/")
+}
+
+// passFileName makes name usable as a file name in the store.
+func passFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == 0:
+			return '-'
+		case r < ' ':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	return strings.TrimLeft(name, ".")
+}
+
+// PassContent formats a login the way pass and its extensions read it: the
+// password on the first line, then `key: value` lines, the otpauth:// URI
+// and the notes.
+func PassContent(item PortWardenElement) []byte {
+	var buf bytes.Buffer
+	login := item.Login
+	if login == nil {
+		login = &Login{}
+	}
+	buf.WriteString(stringValue(login.Password) + "\n")
+	if username := stringValue(login.Username); len(username) > 0 {
+		buf.WriteString("username: " + username + "\n")
+	}
+	for _, uri := range login.Uris {
+		buf.WriteString("url: " + uri.URI + "\n")
+	}
+	for _, field := range item.Fields {
+		buf.WriteString(stringValue(field.Name) + ": " + stringValue(field.Value) + "\n")
+	}
+	if k, err := ParseItemTOTP(item); err == nil && k != nil {
+		buf.WriteString(k.URI() + "\n")
+	}
+	if notes := stringValue(item.Notes); len(notes) > 0 {
+		buf.WriteString(notes + "\n")
+	}
+	return buf.Bytes()
+}
+
+// ExportPass writes the logins of b into the password store at dir, each
+// encrypted to the recipients of the nearest .gpg-id like pass does.
+func ExportPass(b *Backup, dir string, opts PassOptions) ([]PortWardenElement, error) {
+	var keyring openpgp.EntityList
+	var err error
+	if len(opts.Keyring) > 0 {
+		if keyring, err = readKeyring(opts.Keyring); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if len(opts.GPGIDFile) > 0 {
+		if err := copyGPGIDFile(opts.GPGIDFile, filepath.Join(dir, PassGPGIDFileName)); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, skipped := PassEntries(b)
+	recipientsByDir := make(map[string][]*openpgp.Entity)
+	for _, entry := range entries {
+		fileName := filepath.Join(dir, filepath.FromSlash(entry.Path))
+		entryDir := filepath.Dir(fileName)
+		recipients, ok := recipientsByDir[entryDir]
+		if !ok {
+			recipients, err = passRecipients(dir, entryDir, keyring)
+			if err != nil {
+				return nil, err
+			}
+			recipientsByDir[entryDir] = recipients
+		}
+		if err := os.MkdirAll(entryDir, 0700); err != nil {
+			return nil, err
+		}
+		var encrypted bytes.Buffer
+		plaintext, err := openpgp.Encrypt(&encrypted, recipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := plaintext.Write(PassContent(entry.Item)); err != nil {
+			return nil, err
+		}
+		if err := plaintext.Close(); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(fileName, encrypted.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+	}
+	return skipped, nil
+}
+
+// copyGPGIDFile makes the recipients of fileName those of the store, whose
+// .gpg-id is rootGPGID. A store that has other recipients already is an
+// error, its entries would be encrypted to those instead.
+func copyGPGIDFile(fileName, rootGPGID string) error {
+	ids, err := readGPGIDFile(fileName)
+	if err != nil {
+		return err
+	}
+	existing, err := readGPGIDFile(rootGPGID)
+	if err == nil {
+		if strings.Join(existing, "\n") != strings.Join(ids, "\n") {
+			return fmt.Errorf("%v: %v", ErrPassGPGIDMismatch, rootGPGID)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(rootGPGID, []byte(strings.Join(ids, "\n")+"\n"), 0600)
+}
+
+// passRecipients finds the .gpg-id closest to entryDir within the store and
+// looks its recipients up in keyring, or in the keyring of gpg if it is nil.
+func passRecipients(storeDir, entryDir string, keyring openpgp.EntityList) ([]*openpgp.Entity, error) {
+	storeDir = filepath.Clean(storeDir)
+	for d := entryDir; ; d = filepath.Dir(d) {
+		ids, err := readGPGIDFile(filepath.Join(d, PassGPGIDFileName))
+		if err == nil && keyring == nil {
+			if keyring, err = gpgExportKeys(ids); err != nil {
+				return nil, err
+			}
+		}
+		if err == nil {
+			var recipients []*openpgp.Entity
+			for _, id := range ids {
+				entity := findEntity(keyring, id)
+				if entity == nil {
+					return nil, fmt.Errorf("%v %v", ErrPassUnknownRecipent, id)
+				}
+				recipients = append(recipients, entity)
+			}
+			return recipients, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if d == storeDir || d == filepath.Dir(d) {
+			return nil, errors.New(ErrPassNoRecipients)
+		}
+	}
+}
+
+func readGPGIDFile(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 && !strings.HasPrefix(line, "#") {
+			ids = append(ids, line)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New(ErrPassNoRecipients)
+	}
+	return ids, scanner.Err()
+}
+
+// gpgExportKeys exports the public keys of ids from the keyring of gpg.
+func gpgExportKeys(ids []string) (openpgp.EntityList, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("gpg", append([]string{"--batch", "--export"}, ids...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: gpg --export: %v %v", ErrPassNoKeyring, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%v: gpg has no public key for %v", ErrPassNoKeyring, strings.Join(ids, ", "))
+	}
+	return openpgp.ReadKeyRing(&stdout)
+}
+
+func readKeyring(fileName string) (openpgp.EntityList, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(content, []byte("-----BEGIN PGP")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(content))
+}
+
+// findEntity looks up a recipient of a .gpg-id by key id, fingerprint or
+// email address.
+func findEntity(keyring openpgp.EntityList, id string) *openpgp.Entity {
+	hexID := strings.ToUpper(strings.TrimPrefix(strings.Replace(id, " ", "", -1), "0x"))
+	if _, err := hex.DecodeString(hexID); err == nil && len(hexID) >= 8 {
+		for _, entity := range keyring {
+			keys := []string{strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]))}
+			for _, subkey := range entity.Subkeys {
+				keys = append(keys, strings.ToUpper(hex.EncodeToString(subkey.PublicKey.Fingerprint[:])))
+			}
+			for _, key := range keys {
+				if strings.HasSuffix(key, hexID) {
+					return entity
+				}
+			}
+		}
+		return nil
+	}
+	email := strings.ToLower(strings.Trim(id, "<>"))
+	for _, entity := range keyring {
+		for _, identity := range entity.Identities {
+			// a user id that is only an email address has no Email
+			if identity.UserId != nil && (strings.ToLower(identity.UserId.Email) == email || strings.ToLower(strings.TrimSpace(identity.UserId.Id)) == email) {
+				return entity
+			}
+		}
+	}
+	return nil
+}
@@ -24,13 +24,16 @@ require (
 	github.com/json-iterator/go v1.1.5
 	github.com/kelseyhightower/envconfig v1.3.0
 	github.com/mattn/go-isatty v0.0.4
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mholt/archiver v2.1.0+incompatible
 	github.com/mitchellh/gox v1.0.1 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd
 	github.com/modern-go/reflect2 v0.0.0-20180701023420-4b7aa43c6742
+	github.com/nbutton23/zxcvbn-go v0.0.0-20180912185939-ae427f1e4c1d
 	github.com/nwaples/rardecode v1.0.0
 	github.com/opentracing/opentracing-go v1.0.2
 	github.com/pierrec/lz4 v2.4.1+incompatible // indirect
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/streadway/amqp v0.0.0-20181107104731-27835f1a64e9
 	github.com/tidwall/pretty v0.0.0-20180105212114-65a9db5fad51
 	github.com/ugorji/go v1.1.1
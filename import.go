@@ -0,0 +1,143 @@
+package portwarden
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ImportFormatKeePass     = "keepass"
+	ImportFormatLastPassCSV = "lastpass-csv"
+	ImportFormat1PUX        = "1pux"
+)
+
+// importBuilder assembles a Backup from the items of another password
+// manager. Items, folders and attachments get fresh ids, which restoring
+// replaces with the ids Bitwarden hands out anyway.
+type importBuilder struct {
+	backup    *Backup
+	folderIDs map[string]string
+}
+
+func newImportBuilder() *importBuilder {
+	return &importBuilder{
+		backup: &Backup{
+			Items:       PortWarden{},
+			Folders:     PortWardenFolder{},
+			Collections: PortWardenCollection{},
+			Files:       make(map[string][]byte),
+		},
+		folderIDs: make(map[string]string),
+	}
+}
+
+// folder returns the id of the folder called name, creating it on first use.
+// Items without a folder get nil.
+func (ib *importBuilder) folder(name string) *string {
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	if len(name) == 0 {
+		return nil
+	}
+	id, ok := ib.folderIDs[name]
+	if !ok {
+		id = uuid.New().String()
+		ib.folderIDs[name] = id
+		ib.backup.Folders = append(ib.backup.Folders, PortWardenFolderElement{Object: Folder, ID: &id, Name: name})
+	}
+	return &id
+}
+
+func (ib *importBuilder) newItem(itemType int64, name string) PortWardenElement {
+	item := PortWardenElement{
+		Object:          Item,
+		ID:              uuid.New().String(),
+		Type:            itemType,
+		Name:            name,
+		CollectionIDS:   []string{},
+		Attachments:     []Attachment{},
+		RevisionDate:    time.Now().UTC().Format(time.RFC3339),
+		PasswordHistory: []PasswordHistory{},
+		Fields:          []Field{},
+	}
+	switch itemType {
+	case ItemTypeLogin:
+		item.Login = &Login{Uris: []Uris{}}
+	case ItemTypeSecureNote:
+		item.SecureNote = &SecureNote{}
+	case ItemTypeCard:
+		item.Card = &Card{}
+	case ItemTypeIdentity:
+		item.Identity = &Identity{}
+	}
+	return item
+}
+
+func (ib *importBuilder) attach(item *PortWardenElement, fileName string, content []byte) {
+	attachment := Attachment{
+		ID:       uuid.New().String(),
+		FileName: fileName,
+		Size:     strconv.Itoa(len(content)),
+		SizeName: sizeName(len(content)),
+	}
+	item.Attachments = append(item.Attachments, attachment)
+	ib.backup.Files[AttachmentPath(item.ID, attachment.ID, fileName)] = content
+}
+
+func (ib *importBuilder) add(item PortWardenElement) {
+	ib.backup.Items = append(ib.backup.Items, item)
+}
+
+func (ib *importBuilder) done() *Backup {
+	ib.backup.Manifest = NewManifest(ib.backup.Items)
+	return ib.backup
+}
+
+// addField adds a custom field unless value is empty.
+func addField(item *PortWardenElement, name, value string, fieldType int64) {
+	if len(value) == 0 {
+		return
+	}
+	item.Fields = append(item.Fields, Field{Name: &name, Value: &value, Type: fieldType})
+}
+
+// addURI adds uri to a login unless it's empty or already there.
+func addURI(login *Login, uri string) {
+	uri = strings.TrimSpace(uri)
+	if len(uri) == 0 {
+		return
+	}
+	for _, u := range login.Uris {
+		if u.URI == uri {
+			return
+		}
+	}
+	login.Uris = append(login.Uris, Uris{URI: uri})
+}
+
+// optionalString returns nil for an empty s, the way bw leaves out unset
+// values.
+func optionalString(s string) *string {
+	if len(s) == 0 {
+		return nil
+	}
+	return &s
+}
+
+// sizeName formats a size like Bitwarden does for attachments.
+func sizeName(size int) string {
+	units := []string{"Bytes", "KB", "MB", "GB"}
+	s := float64(size)
+	i := 0
+	for s >= 1024 && i < len(units)-1 {
+		s /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d %v", size, units[i])
+	}
+	return fmt.Sprintf("%.2f %v", s, units[i])
+}
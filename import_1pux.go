@@ -0,0 +1,350 @@
+package portwarden
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Err1PUXWithoutData = "not a 1Password export, missing " + onePUXDataFileName
+
+	onePUXDataFileName   = "export.data"
+	onePUXFilesDirectory = "files/"
+
+	onePUXCategoryLogin      = "001"
+	onePUXCategoryCreditCard = "002"
+	onePUXCategoryIdentity   = "004"
+	onePUXCategoryPassword   = "005"
+)
+
+type onePUXExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePUXItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePUXItem struct {
+	FavIndex     int    `json:"favIndex"`
+	UpdatedAt    int64  `json:"updatedAt"`
+	CategoryUUID string `json:"categoryUuid"`
+	Details      struct {
+		LoginFields []struct {
+			Value       string `json:"value"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		Sections   []struct {
+			Title  string        `json:"title"`
+			Fields []onePUXField `json:"fields"`
+		} `json:"sections"`
+		PasswordHistory []struct {
+			Value string `json:"value"`
+			Time  int64  `json:"time"`
+		} `json:"passwordHistory"`
+		Password           string          `json:"password"`
+		DocumentAttributes *onePUXDocument `json:"documentAttributes"`
+	} `json:"details"`
+	Overview struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+	} `json:"overview"`
+}
+
+// onePUXField holds a single value keyed by its kind, e.g.
+// {"concealed": "..."} or {"monthYear": 202512}.
+type onePUXField struct {
+	Title string                     `json:"title"`
+	ID    string                     `json:"id"`
+	Value map[string]json.RawMessage `json:"value"`
+}
+
+type onePUXDocument struct {
+	FileName   string `json:"fileName"`
+	DocumentID string `json:"documentId"`
+}
+
+// Import1PUX converts a 1Password export (.1pux) into a backup. Every vault
+// becomes a folder, logins, passwords, credit cards and identities keep their
+// type, other categories become secure notes with their fields, and files
+// are attached.
+func Import1PUX(data []byte) (*Backup, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[f.Name] = content
+	}
+	exportData, ok := files[onePUXDataFileName]
+	if !ok {
+		return nil, errors.New(Err1PUXWithoutData)
+	}
+	var export onePUXExport
+	if err := json.Unmarshal(exportData, &export); err != nil {
+		return nil, err
+	}
+
+	ib := newImportBuilder()
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			folderID := ib.folder(strings.Replace(vault.Attrs.Name, "/", "-", -1))
+			for _, opItem := range vault.Items {
+				item := onePUXConvert(ib, opItem, files)
+				item.FolderID = folderID
+				ib.add(item)
+			}
+		}
+	}
+	return ib.done(), nil
+}
+
+func onePUXConvert(ib *importBuilder, opItem onePUXItem, files map[string][]byte) PortWardenElement {
+	var itemType int64
+	switch opItem.CategoryUUID {
+	case onePUXCategoryLogin, onePUXCategoryPassword:
+		itemType = ItemTypeLogin
+	case onePUXCategoryCreditCard:
+		itemType = ItemTypeCard
+	case onePUXCategoryIdentity:
+		itemType = ItemTypeIdentity
+	default:
+		itemType = ItemTypeSecureNote
+	}
+	name := opItem.Overview.Title
+	if len(name) == 0 {
+		name = "Untitled"
+	}
+	item := ib.newItem(itemType, name)
+	item.Notes = optionalString(opItem.Details.NotesPlain)
+	item.Favorite = opItem.FavIndex > 0
+	if opItem.UpdatedAt > 0 {
+		item.RevisionDate = time.Unix(opItem.UpdatedAt, 0).UTC().Format(time.RFC3339)
+	}
+
+	if item.Login != nil {
+		for _, field := range opItem.Details.LoginFields {
+			switch field.Designation {
+			case "username":
+				item.Login.Username = optionalString(field.Value)
+			case "password":
+				item.Login.Password = optionalString(field.Value)
+			}
+		}
+		if item.Login.Password == nil {
+			item.Login.Password = optionalString(opItem.Details.Password)
+		}
+		addURI(item.Login, opItem.Overview.URL)
+		for _, u := range opItem.Overview.URLs {
+			addURI(item.Login, u.URL)
+		}
+		for _, ph := range opItem.Details.PasswordHistory {
+			item.PasswordHistory = append(item.PasswordHistory, PasswordHistory{
+				Password:     ph.Value,
+				LastUsedDate: time.Unix(ph.Time, 0).UTC().Format(time.RFC3339),
+			})
+		}
+	}
+
+	for _, section := range opItem.Details.Sections {
+		for _, field := range section.Fields {
+			kind, value := onePUXFieldValue(field)
+			switch {
+			case kind == "file":
+				var document onePUXDocument
+				for _, raw := range field.Value {
+					json.Unmarshal(raw, &document)
+				}
+				onePUXAttach(ib, &item, document, files)
+			case len(value) == 0:
+			case kind == "totp" && item.Login != nil && item.Login.Totp == nil:
+				item.Login.Totp = &value
+			case item.Card != nil && onePUXCardField(item.Card, field.ID, kind, value):
+			case item.Identity != nil && onePUXIdentityField(item.Identity, field, value):
+			case kind == "concealed" || kind == "totp":
+				addField(&item, onePUXFieldName(field), value, FieldTypeHidden)
+			default:
+				addField(&item, onePUXFieldName(field), value, FieldTypeText)
+			}
+		}
+	}
+	if opItem.Details.DocumentAttributes != nil {
+		onePUXAttach(ib, &item, *opItem.Details.DocumentAttributes, files)
+	}
+	return item
+}
+
+func onePUXFieldName(field onePUXField) string {
+	if len(field.Title) > 0 {
+		return field.Title
+	}
+	return field.ID
+}
+
+// onePUXFieldValue returns the kind of a field and its value as text.
+func onePUXFieldValue(field onePUXField) (string, string) {
+	for kind, raw := range field.Value {
+		switch kind {
+		case "date":
+			var t int64
+			if json.Unmarshal(raw, &t) == nil && t != 0 {
+				return kind, time.Unix(t, 0).UTC().Format("2006-01-02")
+			}
+			return kind, ""
+		case "monthYear":
+			var my int
+			if json.Unmarshal(raw, &my) == nil && my != 0 {
+				return kind, fmt.Sprintf("%02d/%d", my%100, my/100)
+			}
+			return kind, ""
+		case "email":
+			var email struct {
+				EmailAddress string `json:"email_address"`
+			}
+			json.Unmarshal(raw, &email)
+			return kind, email.EmailAddress
+		case "address":
+			var address struct {
+				Street  string `json:"street"`
+				City    string `json:"city"`
+				State   string `json:"state"`
+				Zip     string `json:"zip"`
+				Country string `json:"country"`
+			}
+			json.Unmarshal(raw, &address)
+			var parts []string
+			for _, part := range []string{address.Street, address.City, address.State, address.Zip, address.Country} {
+				if len(part) > 0 {
+					parts = append(parts, part)
+				}
+			}
+			return kind, strings.Join(parts, ", ")
+		case "sshKey":
+			var key struct {
+				PrivateKey string `json:"privateKey"`
+			}
+			json.Unmarshal(raw, &key)
+			return "concealed", key.PrivateKey
+		case "file":
+			return kind, ""
+		}
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return kind, s
+		}
+		return kind, strings.Trim(string(raw), `"`)
+	}
+	return "", ""
+}
+
+func onePUXAttach(ib *importBuilder, item *PortWardenElement, document onePUXDocument, files map[string][]byte) {
+	if len(document.DocumentID) == 0 {
+		return
+	}
+	if content, ok := files[onePUXFilesDirectory+document.DocumentID+"__"+document.FileName]; ok {
+		ib.attach(item, document.FileName, content)
+	}
+}
+
+var onePUXCardBrands = map[string]string{
+	"visa":     "Visa",
+	"mc":       "Mastercard",
+	"amex":     "Amex",
+	"discover": "Discover",
+	"diners":   "Diners Club",
+	"jcb":      "JCB",
+	"maestro":  "Maestro",
+	"unionpay": "UnionPay",
+}
+
+func onePUXCardField(card *Card, id, kind, value string) bool {
+	switch id {
+	case "cardholder":
+		card.CardholderName = value
+	case "type":
+		if brand, ok := onePUXCardBrands[value]; ok {
+			value = brand
+		}
+		card.Brand = value
+	case "ccnum":
+		card.Number = value
+	case "cvv":
+		card.Code = &value
+	case "expiry":
+		if kind != "monthYear" {
+			return false
+		}
+		parts := strings.SplitN(value, "/", 2)
+		month, _ := strconv.Atoi(parts[0])
+		card.ExpMonth = strconv.Itoa(month)
+		card.ExpYear = parts[1]
+	default:
+		return false
+	}
+	return true
+}
+
+func onePUXIdentityField(identity *Identity, field onePUXField, value string) bool {
+	switch field.ID {
+	case "firstname":
+		identity.FirstName = value
+	case "initial":
+		identity.MiddleName = value
+	case "lastname":
+		identity.LastName = value
+	case "company":
+		identity.Company = value
+	case "defphone":
+		identity.Phone = value
+	case "email":
+		identity.Email = value
+	case "username":
+		identity.Username = value
+	case "address":
+		var address struct {
+			Street  string `json:"street"`
+			City    string `json:"city"`
+			State   string `json:"state"`
+			Zip     string `json:"zip"`
+			Country string `json:"country"`
+		}
+		if json.Unmarshal(field.Value["address"], &address) != nil {
+			return false
+		}
+		identity.Address1 = address.Street
+		identity.City = address.City
+		identity.State = address.State
+		identity.PostalCode = address.Zip
+		identity.Country = address.Country
+	default:
+		return false
+	}
+	return true
+}
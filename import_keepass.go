@@ -0,0 +1,628 @@
+package portwarden
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	ErrKDBXInvalidFile        = "not a KeePass database"
+	ErrKDBXUnsupportedVersion = "unsupported KeePass database version"
+	ErrKDBXUnsupportedCipher  = "unsupported KeePass database cipher"
+	ErrKDBXInvalidKey         = "wrong KeePass passphrase or key file, or the database is corrupted"
+	ErrKDBXCorrupted          = "the KeePass database is corrupted"
+
+	kdbxHeaderTransformSeed       = 5
+	kdbxHeaderTransformRounds     = 6
+	kdbxHeaderProtectedStreamKey  = 8
+	kdbxHeaderStreamStartBytes    = 9
+	kdbxHeaderInnerRandomStreamID = 10
+
+	kdbxInnerStreamChaCha20 = 3
+)
+
+var (
+	kdbxCipherChaCha20 = mustDecodeHex("d6038a2b8b6f4cb5a524339a31dbb59a")
+	kdbxKDFAESKDBX4    = mustDecodeHex("7c02bb8279a74ac0927d114a00648238")
+	kdbxKDFArgon2d     = mustDecodeHex("ef636ddf8c29444b91f7a9a403e30a0c")
+)
+
+// ImportKeePass converts a KeePass database (KDBX 3.1 or 4) into a backup.
+// Groups become folders named by their path below the root group, entries
+// become logins, or secure notes when they have no login data, and the
+// recycle bin is left out.
+func ImportKeePass(data []byte, passphrase string, keyFile []byte) (*Backup, error) {
+	if len(passphrase) == 0 && len(keyFile) == 0 {
+		return nil, errors.New(ErrKDBXNoKey)
+	}
+	compositeKey, err := KDBXCompositeKey(passphrase, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	file, binaries, err := readKDBX(data, compositeKey)
+	if err != nil {
+		return nil, err
+	}
+	ib := newImportBuilder()
+	recycleBin := file.Meta.RecycleBinUUID
+	if strings.EqualFold(file.Meta.RecycleBinEnabled, "False") || strings.Trim(recycleBin, "A=") == "" {
+		recycleBin = ""
+	}
+	var importGroup func(g kdbxGroup, path []string)
+	importGroup = func(g kdbxGroup, path []string) {
+		folderID := ib.folder(strings.Join(path, "/"))
+		for _, e := range g.Entries {
+			ib.add(keepassItem(ib, e, folderID, binaries))
+		}
+		for _, sub := range g.Groups {
+			if len(recycleBin) > 0 && sub.UUID == recycleBin {
+				continue
+			}
+			importGroup(sub, append(path[:len(path):len(path)], strings.Replace(sub.Name, "/", "-", -1)))
+		}
+	}
+	importGroup(file.Root.Group, nil)
+	return ib.done(), nil
+}
+
+func keepassItem(ib *importBuilder, e kdbxEntry, folderID *string, binaries map[int][]byte) PortWardenElement {
+	values := make(map[string]string)
+	for _, s := range e.Strings {
+		values[s.Key] = s.Value.Content
+	}
+	name := values["Title"]
+	if len(name) == 0 {
+		name = "Untitled"
+	}
+	item := ib.newItem(ItemTypeLogin, name)
+	item.FolderID = folderID
+	item.Notes = optionalString(values["Notes"])
+	if t, ok := kdbxParseTime(e.Times.LastModificationTime); ok {
+		item.RevisionDate = t.Format(time.RFC3339)
+	}
+	for _, tag := range strings.FieldsFunc(e.Tags, func(r rune) bool { return r == ';' || r == ',' }) {
+		if strings.EqualFold(strings.TrimSpace(tag), "Favorite") {
+			item.Favorite = true
+		}
+	}
+
+	login := item.Login
+	login.Username = optionalString(values["UserName"])
+	login.Password = optionalString(values["Password"])
+	login.Totp = optionalString(keepassTOTP(values))
+	addURI(login, values["URL"])
+	for _, s := range e.Strings {
+		switch {
+		case strings.HasPrefix(s.Key, "KP2A_URL"):
+			addURI(login, s.Value.Content)
+		case keepassStandardField(s.Key):
+		case s.Value.Protected == "True":
+			addField(&item, s.Key, s.Value.Content, FieldTypeHidden)
+		default:
+			addField(&item, s.Key, s.Value.Content, FieldTypeText)
+		}
+	}
+
+	if e.History != nil {
+		seen := map[string]bool{values["Password"]: true}
+		for i := len(e.History.Entries) - 1; i >= 0; i-- {
+			old := e.History.Entries[i]
+			for _, s := range old.Strings {
+				if s.Key != "Password" || seen[s.Value.Content] {
+					continue
+				}
+				seen[s.Value.Content] = true
+				ph := PasswordHistory{Password: s.Value.Content, LastUsedDate: item.RevisionDate}
+				if t, ok := kdbxParseTime(old.Times.LastModificationTime); ok {
+					ph.LastUsedDate = t.Format(time.RFC3339)
+				}
+				item.PasswordHistory = append(item.PasswordHistory, ph)
+			}
+		}
+	}
+
+	for _, ref := range e.Binaries {
+		if content, ok := binaries[ref.Value.Ref]; ok {
+			ib.attach(&item, ref.Key, content)
+		}
+	}
+
+	if login.Username == nil && login.Password == nil && login.Totp == nil && len(login.Uris) == 0 {
+		item.Type = ItemTypeSecureNote
+		item.Login = nil
+		item.SecureNote = &SecureNote{}
+	}
+	return item
+}
+
+// keepassStandardField tells the strings that keepassItem maps onto the item
+// itself apart from custom fields.
+func keepassStandardField(key string) bool {
+	switch key {
+	case "Title", "UserName", "Password", "URL", "Notes", "otp", "TOTP Seed", "TOTP Settings":
+		return true
+	}
+	return strings.HasPrefix(key, "TimeOtp-")
+}
+
+// keepassTOTP reads the TOTP settings of KeePassXC (otp), KeeTrayTOTP (TOTP
+// Seed and TOTP Settings) and KeePass 2.47+ (TimeOtp-*).
+func keepassTOTP(values map[string]string) string {
+	if otp := values["otp"]; len(otp) > 0 {
+		return otp
+	}
+	k := &TOTPKey{Issuer: values["Title"], Account: values["UserName"], Algorithm: TOTPAlgorithmSHA1, Digits: 6, Period: 30}
+	if seed := values["TOTP Seed"]; len(seed) > 0 {
+		k.Secret = seed
+		settings := strings.Split(values["TOTP Settings"], ";")
+		if len(settings) >= 2 {
+			if period, err := strconv.Atoi(settings[0]); err == nil {
+				k.Period = period
+			}
+			if settings[1] == "S" {
+				return steamTOTPPrefix + seed
+			}
+			if digits, err := strconv.Atoi(settings[1]); err == nil {
+				k.Digits = digits
+			}
+		}
+	} else if seed := values["TimeOtp-Secret-Base32"]; len(seed) > 0 {
+		k.Secret = seed
+		if period, err := strconv.Atoi(values["TimeOtp-Period"]); err == nil {
+			k.Period = period
+		}
+		if digits, err := strconv.Atoi(values["TimeOtp-Length"]); err == nil {
+			k.Digits = digits
+		}
+		if algorithm := values["TimeOtp-Algorithm"]; len(algorithm) > 0 {
+			k.Algorithm = strings.Replace(strings.TrimPrefix(algorithm, "HMAC-"), "-", "", -1)
+		}
+	} else {
+		return ""
+	}
+	if k.Algorithm == TOTPAlgorithmSHA1 && k.Digits == 6 && k.Period == 30 {
+		return k.Secret
+	}
+	return k.URI()
+}
+
+func kdbxParseTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), true
+	}
+	if raw, err := base64.StdEncoding.DecodeString(s); err == nil && len(raw) == 8 {
+		return time.Unix(int64(binary.LittleEndian.Uint64(raw))-kdbxEpochOffset, 0).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// readKDBX decrypts a KDBX 3.1 or 4 database and returns its XML document,
+// with protected values in plain text, and its binaries by reference.
+func readKDBX(data []byte, compositeKey []byte) (*kdbxFile, map[int][]byte, error) {
+	r := bytes.NewReader(data)
+	var signature1, signature2, version uint32
+	binary.Read(r, binary.LittleEndian, &signature1)
+	binary.Read(r, binary.LittleEndian, &signature2)
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil || signature1 != kdbxSignature1 || signature2 != kdbxSignature2 {
+		return nil, nil, errors.New(ErrKDBXInvalidFile)
+	}
+	var doc []byte
+	var stream kdbxInnerStream
+	binaries := make(map[int][]byte)
+	var err error
+	switch version >> 16 {
+	case 3:
+		doc, stream, err = readKDBX3(data, r, compositeKey)
+	case 4:
+		doc, stream, err = readKDBX4(data, r, compositeKey, binaries)
+	default:
+		err = errors.New(ErrKDBXUnsupportedVersion + ": " + strconv.Itoa(int(version>>16)))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc, err = kdbxUnprotect(doc, stream); err != nil {
+		return nil, nil, err
+	}
+	file := &kdbxFile{}
+	if err := xml.Unmarshal(doc, file); err != nil {
+		return nil, nil, err
+	}
+	// KDBX 3.1 keeps binaries in the XML document instead of the inner header
+	for _, mb := range file.Meta.Binaries {
+		content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(mb.Content))
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.EqualFold(mb.Compressed, "True") {
+			// some writers cut the gzip trailer short, which KeePass
+			// doesn't check either
+			if content, err = gunzip(content); err != nil && (err != io.ErrUnexpectedEOF || len(content) == 0) {
+				return nil, nil, err
+			}
+		}
+		binaries[mb.ID] = content
+	}
+	return file, binaries, nil
+}
+
+func readKDBX3(data []byte, r *bytes.Reader, compositeKey []byte) ([]byte, kdbxInnerStream, error) {
+	fields, err := kdbxReadHeader(r, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	rounds := kdbxUint(fields[kdbxHeaderTransformRounds])
+	transformedKey, err := kdbxAESKDF(compositeKey, fields[kdbxHeaderTransformSeed], rounds)
+	if err != nil {
+		return nil, nil, errors.New(ErrKDBXCorrupted)
+	}
+	key := sha256.Sum256(append(append([]byte{}, fields[kdbxHeaderMasterSeed]...), transformedKey...))
+	plain, err := kdbxDecrypt(fields[kdbxHeaderCipherID], key[:], fields[kdbxHeaderIV], data[len(data)-r.Len():])
+	if err != nil {
+		return nil, nil, err
+	}
+	startBytes := fields[kdbxHeaderStreamStartBytes]
+	if len(startBytes) == 0 || !bytes.HasPrefix(plain, startBytes) {
+		return nil, nil, errors.New(ErrKDBXInvalidKey)
+	}
+
+	// hashed block stream: index, SHA-256 of the data, size, data
+	var payload bytes.Buffer
+	blocks := bytes.NewReader(plain[len(startBytes):])
+	for {
+		var index, size uint32
+		hash := make([]byte, sha256.Size)
+		binary.Read(blocks, binary.LittleEndian, &index)
+		io.ReadFull(blocks, hash)
+		if err := binary.Read(blocks, binary.LittleEndian, &size); err != nil || int64(size) > int64(blocks.Len()) {
+			return nil, nil, errors.New(ErrKDBXCorrupted)
+		}
+		if size == 0 {
+			break
+		}
+		block := make([]byte, size)
+		io.ReadFull(blocks, block)
+		if h := sha256.Sum256(block); !bytes.Equal(h[:], hash) {
+			return nil, nil, errors.New(ErrKDBXCorrupted)
+		}
+		payload.Write(block)
+	}
+	doc := payload.Bytes()
+	if kdbxUint(fields[kdbxHeaderCompression]) == 1 {
+		if doc, err = gunzip(doc); err != nil {
+			return nil, nil, err
+		}
+	}
+	stream, err := newKDBXInnerStream(kdbxUint(fields[kdbxHeaderInnerRandomStreamID]), fields[kdbxHeaderProtectedStreamKey])
+	return doc, stream, err
+}
+
+func readKDBX4(data []byte, r *bytes.Reader, compositeKey []byte, binaries map[int][]byte) ([]byte, kdbxInnerStream, error) {
+	fields, err := kdbxReadHeader(r, 4)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := data[:len(data)-r.Len()]
+	headerHash := make([]byte, sha256.Size)
+	headerHMAC := make([]byte, sha256.Size)
+	io.ReadFull(r, headerHash)
+	if _, err := io.ReadFull(r, headerHMAC); err != nil {
+		return nil, nil, errors.New(ErrKDBXCorrupted)
+	}
+	if h := sha256.Sum256(header); !bytes.Equal(h[:], headerHash) {
+		return nil, nil, errors.New(ErrKDBXCorrupted)
+	}
+
+	transformedKey, err := kdbxDeriveKeyFromParameters(compositeKey, fields[kdbxHeaderKDF])
+	if err != nil {
+		return nil, nil, err
+	}
+	masterSeed := fields[kdbxHeaderMasterSeed]
+	hmacBaseKey := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformedKey...), 1))
+	mac := hmac.New(sha256.New, kdbxBlockKey(hmacBaseKey[:], ^uint64(0)))
+	mac.Write(header)
+	if !hmac.Equal(mac.Sum(nil), headerHMAC) {
+		return nil, nil, errors.New(ErrKDBXInvalidKey)
+	}
+
+	// HMAC-protected blocks: HMAC, size, data
+	var encrypted bytes.Buffer
+	for index := uint64(0); ; index++ {
+		blockHMAC := make([]byte, sha256.Size)
+		var blockHeader [12]byte
+		io.ReadFull(r, blockHMAC)
+		if _, err := io.ReadFull(r, blockHeader[8:]); err != nil {
+			return nil, nil, errors.New(ErrKDBXCorrupted)
+		}
+		binary.LittleEndian.PutUint64(blockHeader[:8], index)
+		size := binary.LittleEndian.Uint32(blockHeader[8:])
+		if int64(size) > int64(r.Len()) {
+			return nil, nil, errors.New(ErrKDBXCorrupted)
+		}
+		block := make([]byte, size)
+		io.ReadFull(r, block)
+		mac := hmac.New(sha256.New, kdbxBlockKey(hmacBaseKey[:], index))
+		mac.Write(blockHeader[:])
+		mac.Write(block)
+		if !hmac.Equal(mac.Sum(nil), blockHMAC) {
+			return nil, nil, errors.New(ErrKDBXCorrupted)
+		}
+		if size == 0 {
+			break
+		}
+		encrypted.Write(block)
+	}
+
+	key := sha256.Sum256(append(append([]byte{}, masterSeed...), transformedKey...))
+	plain, err := kdbxDecrypt(fields[kdbxHeaderCipherID], key[:], fields[kdbxHeaderIV], encrypted.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	if kdbxUint(fields[kdbxHeaderCompression]) == 1 {
+		if plain, err = gunzip(plain); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// inner header: stream cipher, its key and the binaries
+	inner := bytes.NewReader(plain)
+	var streamID uint64
+	var streamKey []byte
+	for {
+		id, err := inner.ReadByte()
+		var size uint32
+		if err != nil || binary.Read(inner, binary.LittleEndian, &size) != nil || int64(size) > int64(inner.Len()) {
+			return nil, nil, errors.New(ErrKDBXCorrupted)
+		}
+		value := make([]byte, size)
+		io.ReadFull(inner, value)
+		if id == kdbxInnerHeaderEnd {
+			break
+		}
+		switch id {
+		case kdbxInnerHeaderStreamID:
+			streamID = kdbxUint(value)
+		case kdbxInnerHeaderStreamKey:
+			streamKey = value
+		case kdbxInnerHeaderBinary:
+			if len(value) > 0 {
+				binaries[len(binaries)] = value[1:]
+			}
+		}
+	}
+	stream, err := newKDBXInnerStream(streamID, streamKey)
+	return plain[len(plain)-inner.Len():], stream, err
+}
+
+// kdbxReadHeader reads the fields of the outer header, whose sizes take
+// sizeLen bytes: 2 in KDBX 3.1 and 4 in KDBX 4.
+func kdbxReadHeader(r *bytes.Reader, sizeLen int) (map[byte][]byte, error) {
+	fields := make(map[byte][]byte)
+	for {
+		id, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		sizeBytes := make([]byte, sizeLen)
+		if _, err := io.ReadFull(r, sizeBytes); err != nil {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		size := kdbxUint(sizeBytes)
+		if size > uint64(r.Len()) {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		value := make([]byte, size)
+		io.ReadFull(r, value)
+		if id == kdbxHeaderEnd {
+			return fields, nil
+		}
+		fields[id] = value
+	}
+}
+
+// kdbxDeriveKeyFromParameters runs the KDF described by the variant
+// dictionary of a KDBX 4 header.
+func kdbxDeriveKeyFromParameters(compositeKey, parameters []byte) ([]byte, error) {
+	params, err := kdbxReadVariantDictionary(parameters)
+	if err != nil {
+		return nil, err
+	}
+	kdf := params["$UUID"]
+	switch {
+	case bytes.Equal(kdf, kdbxKDFAES), bytes.Equal(kdf, kdbxKDFAESKDBX4):
+		return kdbxAESKDF(compositeKey, params["S"], kdbxUint(params["R"]))
+	case bytes.Equal(kdf, kdbxKDFArgon2d), bytes.Equal(kdf, kdbxKDFArgon2id):
+		if v, ok := params["V"]; ok && kdbxUint(v) != argon2Version {
+			return nil, errors.New(ErrKDBXUnknownKDF + ": Argon2 version " + strconv.FormatUint(kdbxUint(v), 16))
+		}
+		iterations := uint32(kdbxUint(params["I"]))
+		memory := uint32(kdbxUint(params["M"]) / 1024)
+		parallelism := uint32(kdbxUint(params["P"]))
+		if bytes.Equal(kdf, kdbxKDFArgon2d) {
+			return argon2dKey(compositeKey, params["S"], params["K"], params["A"], iterations, memory, parallelism, 32), nil
+		}
+		if len(params["K"]) > 0 || len(params["A"]) > 0 {
+			return nil, errors.New(ErrKDBXUnknownKDF + ": Argon2id with a secret or associated data")
+		}
+		return argon2.IDKey(compositeKey, params["S"], iterations, memory, uint8(parallelism), 32), nil
+	}
+	return nil, errors.New(ErrKDBXUnknownKDF)
+}
+
+func kdbxReadVariantDictionary(data []byte) (map[string][]byte, error) {
+	r := bytes.NewReader(data)
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil || version>>8 != 1 {
+		return nil, errors.New(ErrKDBXCorrupted)
+	}
+	params := make(map[string][]byte)
+	for {
+		valueType, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		if valueType == 0 {
+			return params, nil
+		}
+		var keyLen, valueLen uint32
+		if binary.Read(r, binary.LittleEndian, &keyLen) != nil || int64(keyLen) > int64(r.Len()) {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		key := make([]byte, keyLen)
+		io.ReadFull(r, key)
+		if binary.Read(r, binary.LittleEndian, &valueLen) != nil || int64(valueLen) > int64(r.Len()) {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		value := make([]byte, valueLen)
+		io.ReadFull(r, value)
+		params[string(key)] = value
+	}
+}
+
+func kdbxDecrypt(cipherID, key, iv, data []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(cipherID, kdbxCipherAES256):
+		if len(data) == 0 || len(data)%aes.BlockSize != 0 || len(iv) != aes.BlockSize {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		plain := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+		padding := int(plain[len(plain)-1])
+		if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+			return nil, errors.New(ErrKDBXInvalidKey)
+		}
+		return plain[:len(plain)-padding], nil
+	case bytes.Equal(cipherID, kdbxCipherChaCha20):
+		if len(iv) != 12 {
+			return nil, errors.New(ErrKDBXCorrupted)
+		}
+		return chacha20XOR(key, iv, 0, data), nil
+	}
+	return nil, errors.New(ErrKDBXUnsupportedCipher)
+}
+
+// kdbxInnerStream decrypts protected values in document order.
+type kdbxInnerStream interface {
+	xor(data []byte) []byte
+}
+
+func newKDBXInnerStream(id uint64, key []byte) (kdbxInnerStream, error) {
+	switch id {
+	case kdbxInnerStreamSalsa20:
+		return newKDBXSalsa20Stream(key), nil
+	case kdbxInnerStreamChaCha20:
+		h := sha512.Sum512(key)
+		return &kdbxChaCha20Stream{key: h[:32], nonce: h[32:44], used: 64}, nil
+	}
+	return nil, errors.New(ErrKDBXUnsupportedCipher + ": inner stream " + strconv.FormatUint(id, 10))
+}
+
+type kdbxChaCha20Stream struct {
+	key     []byte
+	nonce   []byte
+	counter uint32
+	block   []byte
+	used    int
+}
+
+func (s *kdbxChaCha20Stream) xor(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		if s.used == 64 {
+			s.block = chacha20XOR(s.key, s.nonce, s.counter, make([]byte, 64))
+			s.counter++
+			s.used = 0
+		}
+		out[i] = data[i] ^ s.block[s.used]
+		s.used++
+	}
+	return out
+}
+
+// kdbxUnprotect rewrites doc with its protected values decrypted, going
+// through the document in order as the inner stream requires. The Protected
+// attributes are kept so that hidden values can be told apart.
+func kdbxUnprotect(doc []byte, stream kdbxInnerStream) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	var out bytes.Buffer
+	e := xml.NewEncoder(&out)
+	protected := false
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.ProcInst:
+			continue
+		case xml.StartElement:
+			protected = false
+			if t.Name.Local == "Value" {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "Protected" && strings.EqualFold(attr.Value, "True") {
+						protected = true
+					}
+				}
+			}
+		case xml.CharData:
+			if protected {
+				raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(t)))
+				if err != nil {
+					return nil, errors.New(ErrKDBXCorrupted)
+				}
+				token = xml.CharData(stream.xor(raw))
+				protected = false
+			}
+		case xml.EndElement:
+			protected = false
+		}
+		if err := e.EncodeToken(xml.CopyToken(token)); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func kdbxUint(b []byte) uint64 {
+	var buf [8]byte
+	copy(buf[:], b)
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	// a KDBX payload is a single gzip member, possibly followed by padding
+	zr.Multistream(false)
+	// the content read so far is returned along with an error
+	return ioutil.ReadAll(zr)
+}
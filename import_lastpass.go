@@ -0,0 +1,130 @@
+package portwarden
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ErrLastPassCSVMissingColumns = "not a LastPass CSV export, missing columns"
+
+	lastPassSecureNoteURL = "http://sn"
+)
+
+// ImportLastPassCSV converts a LastPass CSV export into a backup. Groupings
+// become folders, secure notes of the Credit Card type become cards and
+// other secure notes keep their text.
+func ImportLastPassCSV(r io.Reader) (*Backup, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"url", "username", "password", "extra", "name", "grouping"} {
+		if _, ok := columns[required]; !ok {
+			return nil, errors.New(ErrLastPassCSVMissingColumns + ": " + required)
+		}
+	}
+
+	ib := newImportBuilder()
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		name := get("name")
+		if len(name) == 0 {
+			if u, err := url.Parse(get("url")); err == nil && len(u.Host) > 0 {
+				name = u.Host
+			} else {
+				name = "Untitled"
+			}
+		}
+		var item PortWardenElement
+		if get("url") == lastPassSecureNoteURL {
+			item = lastPassSecureNote(ib, name, get("extra"))
+		} else {
+			item = ib.newItem(ItemTypeLogin, name)
+			item.Notes = optionalString(get("extra"))
+			item.Login.Username = optionalString(get("username"))
+			item.Login.Password = optionalString(get("password"))
+			item.Login.Totp = optionalString(get("totp"))
+			addURI(item.Login, get("url"))
+		}
+		if grouping := get("grouping"); grouping != "(none)" {
+			item.FolderID = ib.folder(strings.Replace(grouping, "\\", "/", -1))
+		}
+		item.Favorite = get("fav") == "1"
+		ib.add(item)
+	}
+	return ib.done(), nil
+}
+
+// lastPassSecureNote converts a secure note, whose text starts with
+// `NoteType:<type>` and `Key:Value` lines for the structured types.
+func lastPassSecureNote(ib *importBuilder, name, extra string) PortWardenElement {
+	if !strings.HasPrefix(extra, "NoteType:Credit Card\n") {
+		item := ib.newItem(ItemTypeSecureNote, name)
+		item.Notes = optionalString(extra)
+		return item
+	}
+	item := ib.newItem(ItemTypeCard, name)
+	lines := strings.Split(extra, "\n")
+	for i, line := range lines[1:] {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := parts[1]
+		switch parts[0] {
+		case "Name on Card":
+			item.Card.CardholderName = value
+		case "Type":
+			item.Card.Brand = value
+		case "Number":
+			item.Card.Number = value
+		case "Security Code":
+			item.Card.Code = optionalString(value)
+		case "Expiration Date":
+			// e.g. "June,2025"
+			if date := strings.SplitN(value, ",", 2); len(date) == 2 {
+				if month, err := time.Parse("January", date[0]); err == nil {
+					item.Card.ExpMonth = strconv.Itoa(int(month.Month()))
+				}
+				item.Card.ExpYear = date[1]
+			}
+		case "Language":
+		case "Notes":
+			// the notes run until the end of the text
+			item.Notes = optionalString(strings.Join(append([]string{value}, lines[i+2:]...), "\n"))
+			return item
+		default:
+			// unset dates are exported as ","
+			if strings.Trim(value, ",") != "" {
+				addField(&item, parts[0], value, FieldTypeText)
+			}
+		}
+	}
+	return item
+}
@@ -0,0 +1,46 @@
+package portwarden
+
+import "fmt"
+
+// NamedValue is a labelled value of an item, e.g. one line of an identity.
+type NamedValue struct {
+	Name  string
+	Value string
+}
+
+// IdentityFields lists the non-empty fields of an identity in the order the
+// Bitwarden clients show them.
+func IdentityFields(identity *Identity) []NamedValue {
+	if identity == nil {
+		return nil
+	}
+	var fields []NamedValue
+	add := func(name string, value interface{}) {
+		if value == nil {
+			return
+		}
+		s := fmt.Sprint(value)
+		if len(s) > 0 {
+			fields = append(fields, NamedValue{Name: name, Value: s})
+		}
+	}
+	add("Title", identity.Title)
+	add("First Name", identity.FirstName)
+	add("Middle Name", identity.MiddleName)
+	add("Last Name", identity.LastName)
+	add("Username", identity.Username)
+	add("Company", identity.Company)
+	add("SSN", identity.Ssn)
+	add("Passport Number", identity.PassportNumber)
+	add("License Number", identity.LicenseNumber)
+	add("Email", identity.Email)
+	add("Phone", identity.Phone)
+	add("Address 1", identity.Address1)
+	add("Address 2", identity.Address2)
+	add("Address 3", identity.Address3)
+	add("City", identity.City)
+	add("State", identity.State)
+	add("Postal Code", identity.PostalCode)
+	add("Country", identity.Country)
+	return fields
+}
@@ -0,0 +1,696 @@
+package portwarden
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+const (
+	ExportFormatKDBX = "kdbx"
+
+	KDBXKDFAES      = "aes"
+	KDBXKDFArgon2id = "argon2id"
+
+	ErrKDBXNoKey      = "a KeePass database needs a passphrase, a key file or both"
+	ErrKDBXUnknownKDF = "unknown KDBX key derivation function"
+
+	kdbxSignature1 = 0x9AA2D903
+	kdbxSignature2 = 0xB54BFB67
+	kdbxVersion4   = 0x00040000
+
+	kdbxHeaderEnd         = 0
+	kdbxHeaderCipherID    = 2
+	kdbxHeaderCompression = 3
+	kdbxHeaderMasterSeed  = 4
+	kdbxHeaderIV          = 7
+	kdbxHeaderKDF         = 11
+
+	kdbxInnerHeaderEnd       = 0
+	kdbxInnerHeaderStreamID  = 1
+	kdbxInnerHeaderStreamKey = 2
+	kdbxInnerHeaderBinary    = 3
+
+	kdbxInnerStreamSalsa20 = 2
+	kdbxBlockSize          = 1024 * 1024
+
+	kdbxAESRounds         = 600000
+	kdbxArgon2Iterations  = 10
+	kdbxArgon2MemoryKiB   = 64 * 1024
+	kdbxArgon2Parallelism = 2
+
+	// seconds between 0001-01-01 and the unix epoch, KDBX 4 stores times
+	// as seconds since the former
+	kdbxEpochOffset = 62135596800
+)
+
+var (
+	kdbxCipherAES256 = mustDecodeHex("31c1f2e6bf714350be5805216afc5aff")
+	kdbxKDFAES       = mustDecodeHex("c9d9f39a628a4460bf740d08c18a4fea")
+	kdbxKDFArgon2id  = mustDecodeHex("9e298b1956db4773b23dfc3ec6f0a1e6")
+	kdbxSalsa20Nonce = []byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}
+)
+
+// KDBXOptions protects an exported KeePass database. At least one of
+// Passphrase and KeyFile has to be set.
+type KDBXOptions struct {
+	Passphrase string
+	// KeyFile is the content of a KeePass key file
+	KeyFile []byte
+	// KDF is KDBXKDFAES (the default) or KDBXKDFArgon2id
+	KDF string
+}
+
+type kdbxFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kdbxMeta `xml:"Meta"`
+	Root    kdbxRoot `xml:"Root"`
+}
+
+type kdbxMeta struct {
+	Generator        string               `xml:"Generator"`
+	DatabaseName     string               `xml:"DatabaseName"`
+	MemoryProtection kdbxMemoryProtection `xml:"MemoryProtection"`
+	// only read by ImportKeePass
+	RecycleBinEnabled string           `xml:"RecycleBinEnabled,omitempty"`
+	RecycleBinUUID    string           `xml:"RecycleBinUUID,omitempty"`
+	Binaries          []kdbxMetaBinary `xml:"Binaries>Binary,omitempty"`
+}
+
+// kdbxMetaBinary is an attachment of a KDBX 3.1 database, which keeps them
+// in the XML document.
+type kdbxMetaBinary struct {
+	ID         int    `xml:"ID,attr"`
+	Compressed string `xml:"Compressed,attr"`
+	Content    string `xml:",chardata"`
+}
+
+type kdbxMemoryProtection struct {
+	ProtectTitle    string `xml:"ProtectTitle"`
+	ProtectUserName string `xml:"ProtectUserName"`
+	ProtectPassword string `xml:"ProtectPassword"`
+	ProtectURL      string `xml:"ProtectURL"`
+	ProtectNotes    string `xml:"ProtectNotes"`
+}
+
+type kdbxRoot struct {
+	Group kdbxGroup `xml:"Group"`
+}
+
+type kdbxGroup struct {
+	UUID       string      `xml:"UUID"`
+	Name       string      `xml:"Name"`
+	Times      kdbxTimes   `xml:"Times"`
+	IsExpanded string      `xml:"IsExpanded"`
+	Entries    []kdbxEntry `xml:"Entry"`
+	Groups     []kdbxGroup `xml:"Group"`
+}
+
+type kdbxEntry struct {
+	UUID     string          `xml:"UUID"`
+	Tags     string          `xml:"Tags,omitempty"`
+	Times    kdbxTimes       `xml:"Times"`
+	Strings  []kdbxString    `xml:"String"`
+	Binaries []kdbxBinaryRef `xml:"Binary"`
+	History  *kdbxHistory    `xml:"History,omitempty"`
+}
+
+type kdbxHistory struct {
+	Entries []kdbxEntry `xml:"Entry"`
+}
+
+type kdbxTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+	UsageCount           int    `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged"`
+}
+
+type kdbxString struct {
+	Key   string    `xml:"Key"`
+	Value kdbxValue `xml:"Value"`
+}
+
+type kdbxValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Content   string `xml:",chardata"`
+}
+
+type kdbxBinaryRef struct {
+	Key   string `xml:"Key"`
+	Value struct {
+		Ref int `xml:"Ref,attr"`
+	} `xml:"Value"`
+}
+
+// ExportKDBX writes b as a KeePass KDBX 4 database. Folders become groups
+// (nested by the `/` in their names), and logins, notes, cards, identities,
+// custom fields, TOTP seeds, password history and attachments are kept.
+func ExportKDBX(b *Backup, w io.Writer, opts KDBXOptions) error {
+	if len(opts.Passphrase) == 0 && len(opts.KeyFile) == 0 {
+		return errors.New(ErrKDBXNoKey)
+	}
+	kdfParameters, transformedKey, err := kdbxDeriveKey(opts)
+	if err != nil {
+		return err
+	}
+
+	masterSeed := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	innerStreamKey := make([]byte, 32)
+	for _, buf := range [][]byte{masterSeed, iv, innerStreamKey} {
+		if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+			return err
+		}
+	}
+
+	// outer header
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, uint32(kdbxSignature1))
+	binary.Write(&header, binary.LittleEndian, uint32(kdbxSignature2))
+	binary.Write(&header, binary.LittleEndian, uint32(kdbxVersion4))
+	kdbxWriteHeaderField(&header, kdbxHeaderCipherID, kdbxCipherAES256)
+	kdbxWriteHeaderField(&header, kdbxHeaderCompression, []byte{1, 0, 0, 0})
+	kdbxWriteHeaderField(&header, kdbxHeaderMasterSeed, masterSeed)
+	kdbxWriteHeaderField(&header, kdbxHeaderIV, iv)
+	kdbxWriteHeaderField(&header, kdbxHeaderKDF, kdfParameters)
+	kdbxWriteHeaderField(&header, kdbxHeaderEnd, []byte("\r\n\r\n"))
+
+	encryptionKey := sha256.Sum256(append(append([]byte{}, masterSeed...), transformedKey...))
+	hmacBaseKey := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformedKey...), 1))
+
+	// inner header and the XML document, compressed and encrypted
+	x := newKDBXBuilder(b)
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	var inner bytes.Buffer
+	kdbxWriteHeaderField(&inner, kdbxInnerHeaderStreamID, []byte{kdbxInnerStreamSalsa20, 0, 0, 0})
+	kdbxWriteHeaderField(&inner, kdbxInnerHeaderStreamKey, innerStreamKey)
+	for _, binaryContent := range x.binaries {
+		kdbxWriteHeaderField(&inner, kdbxInnerHeaderBinary, append([]byte{0}, binaryContent...))
+	}
+	kdbxWriteHeaderField(&inner, kdbxInnerHeaderEnd, nil)
+	if _, err := gz.Write(inner.Bytes()); err != nil {
+		return err
+	}
+	stream := newKDBXSalsa20Stream(innerStreamKey)
+	stream.protectGroup(&x.file.Root.Group)
+	if _, err := io.WriteString(gz, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(gz)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(x.file); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(encryptionKey[:])
+	if err != nil {
+		return err
+	}
+	padding := aes.BlockSize - plain.Len()%aes.BlockSize
+	plain.Write(bytes.Repeat([]byte{byte(padding)}, padding))
+	encrypted := plain.Bytes()
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	// header, its hash and HMAC, then the HMAC-protected blocks
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	headerHash := sha256.Sum256(header.Bytes())
+	if _, err := w.Write(headerHash[:]); err != nil {
+		return err
+	}
+	headerHMAC := hmac.New(sha256.New, kdbxBlockKey(hmacBaseKey[:], ^uint64(0)))
+	headerHMAC.Write(header.Bytes())
+	if _, err := w.Write(headerHMAC.Sum(nil)); err != nil {
+		return err
+	}
+	for index := uint64(0); ; index++ {
+		n := len(encrypted)
+		if n > kdbxBlockSize {
+			n = kdbxBlockSize
+		}
+		var blockHeader [12]byte
+		binary.LittleEndian.PutUint64(blockHeader[:8], index)
+		binary.LittleEndian.PutUint32(blockHeader[8:], uint32(n))
+		mac := hmac.New(sha256.New, kdbxBlockKey(hmacBaseKey[:], index))
+		mac.Write(blockHeader[:])
+		mac.Write(encrypted[:n])
+		if _, err := w.Write(mac.Sum(nil)); err != nil {
+			return err
+		}
+		if _, err := w.Write(blockHeader[8:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(encrypted[:n]); err != nil {
+			return err
+		}
+		encrypted = encrypted[n:]
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// KDBXCompositeKey combines a passphrase and the content of a key file the
+// way KeePass does.
+func KDBXCompositeKey(passphrase string, keyFile []byte) ([]byte, error) {
+	var composite []byte
+	if len(passphrase) > 0 {
+		h := sha256.Sum256([]byte(passphrase))
+		composite = append(composite, h[:]...)
+	}
+	if len(keyFile) > 0 {
+		key, err := kdbxKeyFileKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		composite = append(composite, key...)
+	}
+	h := sha256.Sum256(composite)
+	return h[:], nil
+}
+
+// kdbxKeyFileKey supports the XML key files (versions 1.0 and 2.0), 32 byte
+// binary and 64 character hex key files, and hashes any other file.
+func kdbxKeyFileKey(keyFile []byte) ([]byte, error) {
+	var xmlKeyFile struct {
+		Meta struct {
+			Version string `xml:"Version"`
+		} `xml:"Meta"`
+		Key struct {
+			Data string `xml:"Data"`
+		} `xml:"Key"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(keyFile), []byte("<")) && xml.Unmarshal(keyFile, &xmlKeyFile) == nil && len(xmlKeyFile.Key.Data) > 0 {
+		data := strings.Join(strings.Fields(xmlKeyFile.Key.Data), "")
+		if strings.HasPrefix(xmlKeyFile.Meta.Version, "2.") {
+			return hex.DecodeString(data)
+		}
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if len(keyFile) == 32 {
+		return keyFile, nil
+	}
+	if len(keyFile) == 64 {
+		if key, err := hex.DecodeString(string(keyFile)); err == nil {
+			return key, nil
+		}
+	}
+	h := sha256.Sum256(keyFile)
+	return h[:], nil
+}
+
+// kdbxDeriveKey returns the serialized KDF parameters for the header and
+// the key they derive from the composite key.
+func kdbxDeriveKey(opts KDBXOptions) ([]byte, []byte, error) {
+	compositeKey, err := KDBXCompositeKey(opts.Passphrase, opts.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	seed := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, seed); err != nil {
+		return nil, nil, err
+	}
+	vd := &kdbxVariantDictionary{}
+	switch opts.KDF {
+	case "", KDBXKDFAES:
+		vd.putBytes("$UUID", kdbxKDFAES)
+		vd.putUint64("R", kdbxAESRounds)
+		vd.putBytes("S", seed)
+		key, err := kdbxAESKDF(compositeKey, seed, kdbxAESRounds)
+		return vd.bytes(), key, err
+	case KDBXKDFArgon2id:
+		vd.putBytes("$UUID", kdbxKDFArgon2id)
+		vd.putBytes("S", seed)
+		vd.putUint32("P", kdbxArgon2Parallelism)
+		vd.putUint64("M", kdbxArgon2MemoryKiB*1024)
+		vd.putUint64("I", kdbxArgon2Iterations)
+		vd.putUint32("V", 0x13)
+		key := argon2.IDKey(compositeKey, seed, kdbxArgon2Iterations, kdbxArgon2MemoryKiB, kdbxArgon2Parallelism, 32)
+		return vd.bytes(), key, nil
+	}
+	return nil, nil, errors.New(ErrKDBXUnknownKDF + ": " + opts.KDF)
+}
+
+func kdbxAESKDF(key, seed []byte, rounds uint64) ([]byte, error) {
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, err
+	}
+	transformed := append([]byte{}, key...)
+	for i := uint64(0); i < rounds; i++ {
+		block.Encrypt(transformed[:16], transformed[:16])
+		block.Encrypt(transformed[16:], transformed[16:])
+	}
+	h := sha256.Sum256(transformed)
+	return h[:], nil
+}
+
+func kdbxBlockKey(hmacBaseKey []byte, index uint64) []byte {
+	var indexBytes [8]byte
+	binary.LittleEndian.PutUint64(indexBytes[:], index)
+	h := sha512.Sum512(append(indexBytes[:], hmacBaseKey...))
+	return h[:]
+}
+
+func kdbxWriteHeaderField(w *bytes.Buffer, id byte, data []byte) {
+	w.WriteByte(id)
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+}
+
+// kdbxVariantDictionary serializes the KDF parameters of a KDBX 4 header.
+type kdbxVariantDictionary struct {
+	buf bytes.Buffer
+}
+
+func (vd *kdbxVariantDictionary) put(valueType byte, key string, value []byte) {
+	vd.buf.WriteByte(valueType)
+	binary.Write(&vd.buf, binary.LittleEndian, uint32(len(key)))
+	vd.buf.WriteString(key)
+	binary.Write(&vd.buf, binary.LittleEndian, uint32(len(value)))
+	vd.buf.Write(value)
+}
+
+func (vd *kdbxVariantDictionary) putUint32(key string, value uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], value)
+	vd.put(0x04, key, b[:])
+}
+
+func (vd *kdbxVariantDictionary) putUint64(key string, value uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], value)
+	vd.put(0x05, key, b[:])
+}
+
+func (vd *kdbxVariantDictionary) putBytes(key string, value []byte) {
+	vd.put(0x42, key, value)
+}
+
+func (vd *kdbxVariantDictionary) bytes() []byte {
+	return append(append([]byte{0x00, 0x01}, vd.buf.Bytes()...), 0x00)
+}
+
+// kdbxSalsa20Stream encrypts protected values in document order.
+type kdbxSalsa20Stream struct {
+	key     [32]byte
+	counter [16]byte
+	block   [64]byte
+	used    int
+}
+
+func newKDBXSalsa20Stream(innerStreamKey []byte) *kdbxSalsa20Stream {
+	s := &kdbxSalsa20Stream{key: sha256.Sum256(innerStreamKey), used: 64}
+	copy(s.counter[:8], kdbxSalsa20Nonce)
+	return s
+}
+
+func (s *kdbxSalsa20Stream) xor(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		if s.used == 64 {
+			var zeros [64]byte
+			salsa.XORKeyStream(s.block[:], zeros[:], &s.counter, &s.key)
+			binary.LittleEndian.PutUint64(s.counter[8:], binary.LittleEndian.Uint64(s.counter[8:])+1)
+			s.used = 0
+		}
+		out[i] = data[i] ^ s.block[s.used]
+		s.used++
+	}
+	return out
+}
+
+func (s *kdbxSalsa20Stream) protectGroup(g *kdbxGroup) {
+	for i := range g.Entries {
+		s.protectEntry(&g.Entries[i])
+	}
+	for i := range g.Groups {
+		s.protectGroup(&g.Groups[i])
+	}
+}
+
+func (s *kdbxSalsa20Stream) protectEntry(e *kdbxEntry) {
+	for i := range e.Strings {
+		if e.Strings[i].Value.Protected == "True" {
+			e.Strings[i].Value.Content = base64.StdEncoding.EncodeToString(s.xor([]byte(e.Strings[i].Value.Content)))
+		}
+	}
+	if e.History != nil {
+		for i := range e.History.Entries {
+			s.protectEntry(&e.History.Entries[i])
+		}
+	}
+}
+
+// kdbxBuilder turns a backup into the XML document and the binaries of the
+// inner header.
+type kdbxBuilder struct {
+	backup   *Backup
+	file     kdbxFile
+	binaries [][]byte
+	now      string
+}
+
+func newKDBXBuilder(b *Backup) *kdbxBuilder {
+	x := &kdbxBuilder{backup: b, now: kdbxTime(time.Now())}
+	x.file.Meta = kdbxMeta{
+		Generator:    "Portwarden",
+		DatabaseName: "Portwarden",
+		MemoryProtection: kdbxMemoryProtection{
+			ProtectTitle:    "False",
+			ProtectUserName: "False",
+			ProtectPassword: "True",
+			ProtectURL:      "False",
+			ProtectNotes:    "False",
+		},
+	}
+	root := &x.file.Root.Group
+	*root = x.group("portwarden-root", "Portwarden")
+
+	folderPaths := make(map[string]string)
+	for _, folder := range b.Folders {
+		if folder.ID != nil {
+			folderPaths[*folder.ID] = folder.Name
+			x.groupForPath(root, folder.Name)
+		}
+	}
+	for _, item := range b.Items {
+		g := root
+		if item.FolderID != nil {
+			if folderPath, ok := folderPaths[*item.FolderID]; ok {
+				g = x.groupForPath(root, folderPath)
+			}
+		}
+		g.Entries = append(g.Entries, x.entry(item))
+	}
+	return x
+}
+
+func (x *kdbxBuilder) group(id, name string) kdbxGroup {
+	return kdbxGroup{
+		UUID:       kdbxUUID(id),
+		Name:       name,
+		Times:      x.times(x.now),
+		IsExpanded: "True",
+	}
+}
+
+// groupForPath returns the group for a folder, creating one group per `/`
+// separated part of its name the way Bitwarden nests folders.
+func (x *kdbxBuilder) groupForPath(root *kdbxGroup, folderPath string) *kdbxGroup {
+	g := root
+	var sofar []string
+	for _, part := range strings.Split(folderPath, "/") {
+		sofar = append(sofar, part)
+		var next *kdbxGroup
+		for i := range g.Groups {
+			if g.Groups[i].Name == part {
+				next = &g.Groups[i]
+				break
+			}
+		}
+		if next == nil {
+			g.Groups = append(g.Groups, x.group("folder:"+strings.Join(sofar, "/"), part))
+			next = &g.Groups[len(g.Groups)-1]
+		}
+		g = next
+	}
+	return g
+}
+
+func (x *kdbxBuilder) times(modified string) kdbxTimes {
+	return kdbxTimes{
+		CreationTime:         modified,
+		LastModificationTime: modified,
+		LastAccessTime:       modified,
+		ExpiryTime:           modified,
+		Expires:              "False",
+		LocationChanged:      modified,
+	}
+}
+
+func (x *kdbxBuilder) entry(item PortWardenElement) kdbxEntry {
+	modified := x.now
+	if t, err := time.Parse(time.RFC3339, item.RevisionDate); err == nil {
+		modified = kdbxTime(t)
+	}
+	e := kdbxEntry{UUID: kdbxUUID(item.ID), Times: x.times(modified)}
+	if item.Favorite {
+		e.Tags = "Favorite"
+	}
+	strs := &kdbxStrings{}
+	strs.add("Title", item.Name, false)
+	strs.add("Notes", stringValue(item.Notes), false)
+	username, password, url := "", "", ""
+	if item.Login != nil {
+		username = stringValue(item.Login.Username)
+		password = stringValue(item.Login.Password)
+		for i, uri := range item.Login.Uris {
+			if i == 0 {
+				url = uri.URI
+			} else {
+				strs.add("KP2A_URL_"+strconv.Itoa(i), uri.URI, false)
+			}
+		}
+		if k, err := ParseItemTOTP(item); err == nil && k != nil {
+			strs.add("otp", k.URI(), true)
+		} else if item.Login.Totp != nil {
+			strs.add("otp", *item.Login.Totp, true)
+		}
+	}
+	strs.add("UserName", username, false)
+	strs.add("Password", password, true)
+	strs.add("URL", url, false)
+	if item.Card != nil {
+		strs.add("Cardholder Name", item.Card.CardholderName, false)
+		strs.add("Brand", item.Card.Brand, false)
+		strs.add("Card Number", item.Card.Number, true)
+		strs.add("Expiration Month", item.Card.ExpMonth, false)
+		strs.add("Expiration Year", item.Card.ExpYear, false)
+		strs.add("Security Code", stringValue(item.Card.Code), true)
+	}
+	if item.Identity != nil {
+		for _, f := range IdentityFields(item.Identity) {
+			strs.add(f.Name, f.Value, f.Name == "SSN")
+		}
+	}
+	for _, field := range item.Fields {
+		strs.add(stringValue(field.Name), stringValue(field.Value), field.Type == FieldTypeHidden)
+	}
+	e.Strings = strs.strings
+
+	binaryKeys := make(map[string]bool)
+	for _, attachment := range item.Attachments {
+		content, ok := x.backup.Attachment(item, attachment)
+		if !ok {
+			continue
+		}
+		key := uniqueName(attachment.FileName, binaryKeys)
+		ref := kdbxBinaryRef{Key: key}
+		ref.Value.Ref = len(x.binaries)
+		x.binaries = append(x.binaries, content)
+		e.Binaries = append(e.Binaries, ref)
+	}
+
+	if item.Login != nil && len(item.PasswordHistory) > 0 {
+		e.History = &kdbxHistory{}
+		for _, ph := range item.PasswordHistory {
+			used := modified
+			if t, err := time.Parse(time.RFC3339, ph.LastUsedDate); err == nil {
+				used = kdbxTime(t)
+			}
+			old := kdbxEntry{UUID: e.UUID, Times: x.times(used)}
+			oldStrs := &kdbxStrings{}
+			oldStrs.add("Title", item.Name, false)
+			oldStrs.add("UserName", username, false)
+			oldStrs.add("Password", ph.Password, true)
+			oldStrs.add("URL", url, false)
+			old.Strings = oldStrs.strings
+			e.History.Entries = append(e.History.Entries, old)
+		}
+	}
+	return e
+}
+
+// kdbxStrings collects the strings of an entry, renaming custom fields that
+// clash with a standard or an earlier field.
+type kdbxStrings struct {
+	strings []kdbxString
+	keys    map[string]bool
+}
+
+func (s *kdbxStrings) add(key, value string, protected bool) {
+	if s.keys == nil {
+		s.keys = make(map[string]bool)
+	}
+	if len(key) == 0 {
+		key = "Field"
+	}
+	key = uniqueName(key, s.keys)
+	v := kdbxValue{Content: value}
+	if protected {
+		v.Protected = "True"
+	}
+	s.strings = append(s.strings, kdbxString{Key: key, Value: v})
+}
+
+// uniqueName returns name, or name with a number appended if it's already in
+// taken, and marks the result as taken.
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = name + " (" + strconv.Itoa(i) + ")"
+	}
+	taken[unique] = true
+	return unique
+}
+
+// kdbxUUID derives a stable UUID from a Bitwarden id, using the id itself
+// when it's already a GUID.
+func kdbxUUID(id string) string {
+	if raw, err := hex.DecodeString(strings.Replace(id, "-", "", -1)); err == nil && len(raw) == 16 {
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	h := sha256.Sum256([]byte(id))
+	return base64.StdEncoding.EncodeToString(h[:16])
+}
+
+func kdbxTime(t time.Time) string {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(t.Unix()+kdbxEpochOffset))
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,253 @@
+package portwarden
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// The pinned golang.org/x/crypto neither exports ChaCha20 nor Argon2d, which
+// KeePassXC uses by default, so both are implemented here following RFC 7539
+// and RFC 9106.
+
+// chacha20XOR xors data with the ChaCha20 key stream of key and nonce,
+// starting at block counter.
+func chacha20XOR(key, nonce []byte, counter uint32, data []byte) []byte {
+	var state [16]uint32
+	state[0], state[1], state[2], state[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		state[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	for i := 0; i < 3; i++ {
+		state[13+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	out := make([]byte, len(data))
+	var block [64]byte
+	for offset := 0; offset < len(data); offset += 64 {
+		state[12] = counter
+		x := state
+		for round := 0; round < 10; round++ {
+			chachaQuarterRound(&x, 0, 4, 8, 12)
+			chachaQuarterRound(&x, 1, 5, 9, 13)
+			chachaQuarterRound(&x, 2, 6, 10, 14)
+			chachaQuarterRound(&x, 3, 7, 11, 15)
+			chachaQuarterRound(&x, 0, 5, 10, 15)
+			chachaQuarterRound(&x, 1, 6, 11, 12)
+			chachaQuarterRound(&x, 2, 7, 8, 13)
+			chachaQuarterRound(&x, 3, 4, 9, 14)
+		}
+		for i := range x {
+			binary.LittleEndian.PutUint32(block[i*4:], x[i]+state[i])
+		}
+		for i := 0; i < 64 && offset+i < len(data); i++ {
+			out[offset+i] = data[offset+i] ^ block[i]
+		}
+		counter++
+	}
+	return out
+}
+
+func chachaQuarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+const (
+	argon2Version   = 0x13
+	argon2TypeD     = 0
+	argon2BlockSize = 128
+	argon2SyncPts   = 4
+)
+
+type argon2Block [argon2BlockSize]uint64
+
+// argon2dKey derives keyLen bytes with Argon2d, memory being in KiB.
+func argon2dKey(password, salt, secret, data []byte, time, memory, threads, keyLen uint32) []byte {
+	h, _ := blake2b.New512(nil)
+	for _, v := range []uint32{threads, keyLen, memory, time, argon2Version, argon2TypeD} {
+		binary.Write(h, binary.LittleEndian, v)
+	}
+	for _, b := range [][]byte{password, salt, secret, data} {
+		binary.Write(h, binary.LittleEndian, uint32(len(b)))
+		h.Write(b)
+	}
+	h0 := h.Sum(nil)
+
+	if memory < 2*argon2SyncPts*threads {
+		memory = 2 * argon2SyncPts * threads
+	}
+	memory = memory / (argon2SyncPts * threads) * (argon2SyncPts * threads)
+	laneLength := memory / threads
+	segmentLength := laneLength / argon2SyncPts
+
+	B := make([]argon2Block, memory)
+	for lane := uint32(0); lane < threads; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			var input [72]byte
+			copy(input[:], h0)
+			binary.LittleEndian.PutUint32(input[64:], i)
+			binary.LittleEndian.PutUint32(input[68:], lane)
+			out := argon2Hash(input[:], 1024)
+			for j := range B[lane*laneLength+i] {
+				B[lane*laneLength+i][j] = binary.LittleEndian.Uint64(out[j*8:])
+			}
+		}
+	}
+
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPts; slice++ {
+			for lane := uint32(0); lane < threads; lane++ {
+				index := uint32(0)
+				if pass == 0 && slice == 0 {
+					index = 2
+				}
+				offset := lane*laneLength + slice*segmentLength + index
+				for ; index < segmentLength; index, offset = index+1, offset+1 {
+					prev := offset - 1
+					if index == 0 && slice == 0 {
+						prev = lane*laneLength + laneLength - 1
+					}
+					random := B[prev][0]
+					refLane := uint32(random>>32) % threads
+					if pass == 0 && slice == 0 {
+						refLane = lane
+					}
+					refIndex := argon2IndexAlpha(random, laneLength, segmentLength, pass, slice, index, refLane == lane)
+					ref := refLane*laneLength + refIndex
+					argon2Compress(&B[offset], &B[prev], &B[ref], pass > 0)
+				}
+			}
+		}
+	}
+
+	var final argon2Block
+	for lane := uint32(0); lane < threads; lane++ {
+		last := &B[lane*laneLength+laneLength-1]
+		for i := range final {
+			final[i] ^= last[i]
+		}
+	}
+	var finalBytes [1024]byte
+	for i, v := range final {
+		binary.LittleEndian.PutUint64(finalBytes[i*8:], v)
+	}
+	return argon2Hash(finalBytes[:], keyLen)
+}
+
+func argon2IndexAlpha(random uint64, laneLength, segmentLength, pass, slice, index uint32, sameLane bool) uint32 {
+	var area uint32
+	switch {
+	case pass == 0 && slice == 0:
+		area = index - 1
+	case pass == 0 && sameLane:
+		area = slice*segmentLength + index - 1
+	case pass == 0:
+		area = slice * segmentLength
+		if index == 0 {
+			area--
+		}
+	case sameLane:
+		area = laneLength - segmentLength + index - 1
+	default:
+		area = laneLength - segmentLength
+		if index == 0 {
+			area--
+		}
+	}
+	x := random & 0xFFFFFFFF
+	x = x * x >> 32
+	y := uint64(area) * x >> 32
+	relative := uint64(area) - 1 - y
+	start := uint64(0)
+	if pass > 0 && slice != argon2SyncPts-1 {
+		start = uint64((slice + 1) * segmentLength)
+	}
+	return uint32((start + relative) % uint64(laneLength))
+}
+
+// argon2Compress computes G(prev, ref) into out, xoring it into the existing
+// content on passes after the first.
+func argon2Compress(out, prev, ref *argon2Block, xor bool) {
+	var r, q argon2Block
+	for i := range r {
+		r[i] = prev[i] ^ ref[i]
+	}
+	q = r
+	for i := 0; i < 8; i++ {
+		argon2Permute(&q, [16]int{
+			16 * i, 16*i + 1, 16*i + 2, 16*i + 3, 16*i + 4, 16*i + 5, 16*i + 6, 16*i + 7,
+			16*i + 8, 16*i + 9, 16*i + 10, 16*i + 11, 16*i + 12, 16*i + 13, 16*i + 14, 16*i + 15,
+		})
+	}
+	for i := 0; i < 8; i++ {
+		argon2Permute(&q, [16]int{
+			2 * i, 2*i + 1, 2*i + 16, 2*i + 17, 2*i + 32, 2*i + 33, 2*i + 48, 2*i + 49,
+			2*i + 64, 2*i + 65, 2*i + 80, 2*i + 81, 2*i + 96, 2*i + 97, 2*i + 112, 2*i + 113,
+		})
+	}
+	for i := range out {
+		if xor {
+			out[i] ^= q[i] ^ r[i]
+		} else {
+			out[i] = q[i] ^ r[i]
+		}
+	}
+}
+
+func argon2Permute(b *argon2Block, idx [16]int) {
+	v := func(i int) *uint64 { return &b[idx[i]] }
+	argon2GB(v(0), v(4), v(8), v(12))
+	argon2GB(v(1), v(5), v(9), v(13))
+	argon2GB(v(2), v(6), v(10), v(14))
+	argon2GB(v(3), v(7), v(11), v(15))
+	argon2GB(v(0), v(5), v(10), v(15))
+	argon2GB(v(1), v(6), v(11), v(12))
+	argon2GB(v(2), v(7), v(8), v(13))
+	argon2GB(v(3), v(4), v(9), v(14))
+}
+
+func argon2GB(a, b, c, d *uint64) {
+	fBlaMka := func(x, y uint64) uint64 {
+		return x + y + 2*(x&0xFFFFFFFF)*(y&0xFFFFFFFF)
+	}
+	*a = fBlaMka(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -32)
+	*c = fBlaMka(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -24)
+	*a = fBlaMka(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -16)
+	*c = fBlaMka(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -63)
+}
+
+// argon2Hash is the variable length hash H' of Argon2.
+func argon2Hash(in []byte, outLen uint32) []byte {
+	var prefix [4]byte
+	binary.LittleEndian.PutUint32(prefix[:], outLen)
+	if outLen <= blake2b.Size {
+		h, _ := blake2b.New(int(outLen), nil)
+		h.Write(prefix[:])
+		h.Write(in)
+		return h.Sum(nil)
+	}
+	h, _ := blake2b.New512(nil)
+	h.Write(prefix[:])
+	h.Write(in)
+	v := h.Sum(nil)
+	out := append(make([]byte, 0, outLen), v[:32]...)
+	for outLen-uint32(len(out)) > blake2b.Size {
+		next := blake2b.Sum512(v)
+		v = next[:]
+		out = append(out, v[:32]...)
+	}
+	h, _ = blake2b.New(int(outLen)-len(out), nil)
+	h.Write(v)
+	return append(out, h.Sum(nil)...)
+}
@@ -0,0 +1,47 @@
+package portwarden
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 7539 section 2.4.2
+func TestChaCha20XOR(t *testing.T) {
+	key := mustHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	nonce := mustHex(t, "000000000000004a00000000")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	want := mustHex(t, `
+		6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b
+		f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8
+		07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736
+		5af90bbf74a35be6b40b8eedf2785e42874d`)
+	if got := chacha20XOR(key, nonce, 1, plaintext); !bytes.Equal(got, want) {
+		t.Errorf("chacha20XOR = %x, want %x", got, want)
+	}
+	if got := chacha20XOR(key, nonce, 1, want); !bytes.Equal(got, plaintext) {
+		t.Errorf("chacha20XOR of the ciphertext = %q, want %q", got, plaintext)
+	}
+}
+
+// RFC 9106 section 5.1
+func TestArgon2dKey(t *testing.T) {
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+	want := mustHex(t, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb")
+	if got := argon2dKey(password, salt, secret, data, 3, 32, 4, 32); !bytes.Equal(got, want) {
+		t.Errorf("argon2dKey = %x, want %x", got, want)
+	}
+}
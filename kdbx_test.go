@@ -0,0 +1,71 @@
+package portwarden
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKDBXRoundTrip(t *testing.T) {
+	b, err := ImportLastPassCSV(strings.NewReader(`url,username,password,totp,extra,name,grouping,fav
+https://example.com/login,alice,pa55,,"a note, with comma",Example,Personal\Banking,1
+https://mail.example.com,bob,s3cret,,,Mail,,0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := kdbxSummary(b)
+	for _, kdf := range []string{KDBXKDFAES, KDBXKDFArgon2id} {
+		t.Run(kdf, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ExportKDBX(b, &buf, KDBXOptions{Passphrase: "passphrase", KDF: kdf}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ImportKeePass(buf.Bytes(), "wrong", nil); err == nil {
+				t.Error("ImportKeePass accepted the wrong passphrase")
+			}
+			imported, err := ImportKeePass(buf.Bytes(), "passphrase", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := kdbxSummary(imported)
+			if len(got) != len(want) {
+				t.Fatalf("ImportKeePass returned %v, want %v", got, want)
+			}
+			for name, summary := range want {
+				if got[name] != summary {
+					t.Errorf("item %q = %q, want %q", name, got[name], summary)
+				}
+			}
+		})
+	}
+}
+
+// kdbxSummary maps the name of every item of b to its folder, username,
+// password and notes.
+func kdbxSummary(b *Backup) map[string]string {
+	folders := map[string]string{}
+	for _, folder := range b.Folders {
+		if folder.ID != nil {
+			folders[*folder.ID] = folder.Name
+		}
+	}
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	summary := map[string]string{}
+	for _, item := range b.Items {
+		fields := []string{"", "", "", str(item.Notes)}
+		if item.FolderID != nil {
+			fields[0] = folders[*item.FolderID]
+		}
+		if item.Login != nil {
+			fields[1], fields[2] = str(item.Login.Username), str(item.Login.Password)
+		}
+		summary[item.Name] = strings.Join(fields, "|")
+	}
+	return summary
+}
@@ -0,0 +1,91 @@
+package portwarden
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	ManifestJSONFileName  = "manifest.json"
+	AttachmentsFolderName = "attachments/"
+	ManifestVersion       = 1
+)
+
+// Manifest describes the layout of a backup archive. Attachments are stored
+// under attachments/<item id>/<attachment id>/<file name> so that items sharing
+// a name can't overwrite each other; the human readable names are kept here.
+// Backups made before the manifest existed have no manifest.json and store
+// attachments under <item name>/<file name> instead.
+type Manifest struct {
+	Version     int                  `json:"version"`
+	Attachments []ManifestAttachment `json:"attachments"`
+}
+
+type ManifestAttachment struct {
+	ItemID       string `json:"itemId"`
+	ItemName     string `json:"itemName"`
+	AttachmentID string `json:"attachmentId"`
+	FileName     string `json:"fileName"`
+	Path         string `json:"path"`
+}
+
+func NewManifest(pws []PortWardenElement) *Manifest {
+	m := &Manifest{Version: ManifestVersion, Attachments: []ManifestAttachment{}}
+	for _, item := range pws {
+		for _, innerItem := range item.Attachments {
+			m.Attachments = append(m.Attachments, ManifestAttachment{
+				ItemID:       item.ID,
+				ItemName:     item.Name,
+				AttachmentID: innerItem.ID,
+				FileName:     innerItem.FileName,
+				Path:         AttachmentPath(item.ID, innerItem.ID, innerItem.FileName),
+			})
+		}
+	}
+	return m
+}
+
+// ReadManifest reads the manifest of an unzipped backup in backupDir. It
+// returns nil without an error for legacy backups that don't have one.
+func ReadManifest(backupDir string) (*Manifest, error) {
+	file, err := ioutil.ReadFile(backupDir + ManifestJSONFileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(file, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AttachmentPath returns where an attachment lives relative to the root of the
+// backup archive, falling back to the legacy name based layout when m is nil
+// or doesn't know about the attachment.
+func (m *Manifest) AttachmentPath(item PortWardenElement, attachment Attachment) string {
+	if m != nil {
+		for _, ma := range m.Attachments {
+			if ma.ItemID == item.ID && ma.AttachmentID == attachment.ID {
+				return ma.Path
+			}
+		}
+	}
+	return LegacyAttachmentPath(item.Name, attachment.FileName)
+}
+
+func AttachmentPath(itemID, attachmentID, fileName string) string {
+	return AttachmentsFolderName + itemID + "/" + attachmentID + "/" + path.Base(fileName)
+}
+
+// LegacyAttachmentPath mirrors how backups without a manifest were written:
+// the item name was appended to the output directory and the whole path
+// trimmed, so only trailing whitespace was dropped from the name.
+func LegacyAttachmentPath(itemName, fileName string) string {
+	return strings.TrimRight(itemName, " \t\r\n") + "/" + fileName
+}
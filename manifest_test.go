@@ -0,0 +1,39 @@
+package portwarden
+
+import "testing"
+
+func TestLegacyAttachmentPath(t *testing.T) {
+	for _, tc := range []struct {
+		itemName, fileName string
+		want               string
+	}{
+		{"Bank", "scan.pdf", "Bank/scan.pdf"},
+		{"Bank \t\r\n", "scan.pdf", "Bank/scan.pdf"},
+		{" Bank", "scan.pdf", " Bank/scan.pdf"},
+		{" Bank ", "scan.pdf", " Bank/scan.pdf"},
+	} {
+		if got := LegacyAttachmentPath(tc.itemName, tc.fileName); got != tc.want {
+			t.Errorf("LegacyAttachmentPath(%q, %q) = %q, want %q", tc.itemName, tc.fileName, got, tc.want)
+		}
+	}
+}
+
+func TestManifestAttachmentPath(t *testing.T) {
+	item := PortWardenElement{ID: "i1", Name: " Bank "}
+	item.Attachments = []Attachment{{ID: "a1", FileName: "scan.pdf"}}
+	m := NewManifest([]PortWardenElement{item})
+	for _, tc := range []struct {
+		name       string
+		m          *Manifest
+		attachment Attachment
+		want       string
+	}{
+		{"manifest", m, item.Attachments[0], "attachments/i1/a1/scan.pdf"},
+		{"unknown attachment", m, Attachment{ID: "a2", FileName: "other.pdf"}, " Bank/other.pdf"},
+		{"legacy", nil, item.Attachments[0], " Bank/scan.pdf"},
+	} {
+		if got := tc.m.AttachmentPath(item, tc.attachment); got != tc.want {
+			t.Errorf("%s: AttachmentPath = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package portwarden
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConcurrency = 4
+	DefaultRate        = 5
+
+	pacerMaxRetries     = 6
+	pacerInitialBackoff = time.Second
+	pacerMaxBackoff     = time.Minute
+)
+
+// retryableBWError matches what `bw` prints when the server rate limits us
+// or fails temporarily: an HTTP status it reports, like "status code 503"
+// or "HTTP/1.1 502 Bad Gateway", a message that is nothing but the status
+// text, or a network error of node. The output may hold item names and
+// other text too, so a bare number like the 500 in "1500ms" doesn't count.
+var retryableBWError = regexp.MustCompile(`(?im)` +
+	`\b(?:status(?: code)?|http(?:/[\d.]+)?)[: ]+(?:429|50[0-4])\b|` +
+	`^(?:error: )?(?:too many requests|rate limit exceeded|internal server error|bad gateway|service unavailable|gateway timeout)\.?$|` +
+	`\b(?:econnreset|etimedout|econnrefused|enotfound|eai_again)\b`)
+
+// unsentBWError matches the retryable errors after which the server surely
+// didn't save anything: it rate limited the request, or the request never
+// reached it.
+var unsentBWError = regexp.MustCompile(`(?im)` +
+	`\b(?:status(?: code)?|http(?:/[\d.]+)?)[: ]+429\b|` +
+	`^(?:error: )?(?:too many requests|rate limit exceeded)\.?$|` +
+	`\b(?:econnrefused|enotfound|eai_again)\b`)
+
+// Pacer runs calls to the Bitwarden CLI on a bounded number of workers while a
+// token bucket keeps the overall request rate under Rate requests per second.
+// Calls failing with a rate limit or 5xx error are retried with exponential
+// backoff.
+type Pacer struct {
+	Concurrency int
+	Rate        float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewPacer(concurrency int, rate float64) *Pacer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+	return &Pacer{Concurrency: concurrency, Rate: rate, tokens: 1, last: time.Now()}
+}
+
+// NewPacerFromSleepMilliseconds returns a sequential pacer making one request
+// every sleepMilliseconds, the way backups used to be paced.
+func NewPacerFromSleepMilliseconds(sleepMilliseconds int) *Pacer {
+	if sleepMilliseconds <= 0 {
+		return NewPacer(1, DefaultRate)
+	}
+	return NewPacer(1, 1000/float64(sleepMilliseconds))
+}
+
+// Wait blocks until the token bucket allows another request.
+func (p *Pacer) Wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	burst := float64(p.Concurrency)
+	now := time.Now()
+	p.tokens += now.Sub(p.last).Seconds() * p.Rate
+	if p.tokens > burst {
+		p.tokens = burst
+	}
+	p.last = now
+	if p.tokens < 1 {
+		wait := time.Duration((1 - p.tokens) / p.Rate * float64(time.Second))
+		time.Sleep(wait)
+		p.last = time.Now()
+		p.tokens = 1
+	}
+	p.tokens--
+}
+
+// Do runs fn once the rate limit allows it, retrying with backoff while it
+// fails with a retryable error.
+func (p *Pacer) Do(fn func() error) error {
+	backoff := pacerInitialBackoff
+	for attempt := 0; ; attempt++ {
+		p.Wait()
+		err := fn()
+		if err == nil || !IsRetryableBWError(err) || attempt == pacerMaxRetries {
+			return err
+		}
+		backoff = sleepBackoff(backoff)
+	}
+}
+
+// DoCreate runs create, which makes a record with `bw create`, like Do. A
+// create isn't idempotent: when the response is lost the server may have
+// saved the record anyway. So unless the request surely didn't reach the
+// server, created is asked whether the record is there before create runs
+// again, and if it can't tell, the error is returned rather than risking a
+// second copy.
+func (p *Pacer) DoCreate(create func() error, created func() (bool, error)) error {
+	backoff := pacerInitialBackoff
+	for attempt := 0; ; attempt++ {
+		p.Wait()
+		err := create()
+		if err == nil || !IsRetryableBWError(err) || attempt == pacerMaxRetries {
+			return err
+		}
+		backoff = sleepBackoff(backoff)
+		if unsentBWError.MatchString(err.Error()) {
+			continue
+		}
+		p.Wait()
+		ok, findErr := created()
+		if findErr != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// sleepBackoff sleeps for backoff with some jitter and returns the next one.
+func sleepBackoff(backoff time.Duration) time.Duration {
+	time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff/2))))
+	backoff *= 2
+	if backoff > pacerMaxBackoff {
+		backoff = pacerMaxBackoff
+	}
+	return backoff
+}
+
+// Run calls fn for 0 <= i < n on Concurrency workers, each call paced by Do.
+// It stops handing out work after the first error and returns it once the
+// running calls finished.
+func (p *Pacer) Run(n int, fn func(i int) error) error {
+	return p.each(n, func(i int) error {
+		return p.Do(func() error { return fn(i) })
+	})
+}
+
+// RunCreates is Run for creating records, each paced by DoCreate: create(i)
+// makes the i-th record and created(i) tells whether it is there after a
+// failed create.
+func (p *Pacer) RunCreates(n int, create func(i int) error, created func(i int) (bool, error)) error {
+	return p.each(n, func(i int) error {
+		return p.DoCreate(func() error { return create(i) }, func() (bool, error) { return created(i) })
+	})
+}
+
+func (p *Pacer) each(n int, fn func(i int) error) error {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	failed := make(chan struct{})
+	for w := 0; w < p.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-failed:
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+func IsRetryableBWError(err error) bool {
+	return retryableBWError.MatchString(err.Error())
+}
+
+// bwRun runs the Bitwarden CLI and returns its output. On failure the error
+// carries what `bw` printed to stderr so IsRetryableBWError can inspect it.
+func bwRun(args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("bw", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return stdout.Bytes(), errors.New(msg)
+		}
+		if msg := strings.TrimSpace(stdout.String()); len(msg) > 0 {
+			return stdout.Bytes(), errors.New(msg)
+		}
+		return stdout.Bytes(), err
+	}
+	return stdout.Bytes(), nil
+}
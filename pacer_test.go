@@ -0,0 +1,28 @@
+package portwarden
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsRetryableBWError(t *testing.T) {
+	for _, tc := range []struct {
+		msg  string
+		want bool
+	}{
+		{"Request failed with status code 503", true},
+		{"status: 429", true},
+		{"HTTP/1.1 502 Bad Gateway", true},
+		{"Too many requests", true},
+		{"Error: Service Unavailable.", true},
+		{"connect ECONNRESET 1.2.3.4:443", true},
+		{"Item 500 not found.", false},
+		{"The request took 1500ms", false},
+		{"The field Name exceeds 429 characters.", false},
+		{"Bad gateway router login is invalid.", false},
+	} {
+		if got := IsRetryableBWError(errors.New(tc.msg)); got != tc.want {
+			t.Errorf("IsRetryableBWError(%q) = %v, want %v", tc.msg, got, tc.want)
+		}
+	}
+}
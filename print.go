@@ -0,0 +1,253 @@
+package portwarden
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPrintAttachmentSize is the largest attachment, in bytes, that
+	// is printed rather than only listed.
+	DefaultPrintAttachmentSize = 64 * 1024
+)
+
+// PrintOptions chooses what goes into an emergency kit. Folders and Items
+// match folder names (including their subfolders) and item names or IDs,
+// case-insensitively; when both are empty the whole backup is printed.
+type PrintOptions struct {
+	Title             string
+	Folders           []string
+	Items             []string
+	MaxAttachmentSize int
+}
+
+type printKit struct {
+	Title     string
+	Generated string
+	Folders   []printFolder
+	Count     int
+}
+
+type printFolder struct {
+	Name  string
+	Items []printItem
+}
+
+type printItem struct {
+	Name        string
+	Type        string
+	URIs        []string
+	Username    string
+	Password    string
+	TOTP        string
+	TOTPQRCode  template.URL
+	Card        []NamedValue
+	Identity    []NamedValue
+	Fields      []NamedValue
+	Notes       string
+	Attachments []printAttachment
+}
+
+type printAttachment struct {
+	FileName string
+	SizeName string
+	Text     string
+	Image    template.URL
+	// Omitted explains why the content isn't printed
+	Omitted string
+}
+
+var printItemTypes = map[int64]string{
+	ItemTypeLogin:      "Login",
+	ItemTypeSecureNote: "Secure Note",
+	ItemTypeCard:       "Card",
+	ItemTypeIdentity:   "Identity",
+}
+
+// PrintEmergencyKit writes the items of b chosen by opts as a self-contained
+// HTML page meant to be printed and kept offline: items are grouped by
+// folder, TOTP seeds are QR codes embedded as data URIs and small text and
+// image attachments are printed inline. The page loads nothing from the
+// network.
+func PrintEmergencyKit(b *Backup, w io.Writer, opts PrintOptions) error {
+	if opts.MaxAttachmentSize == 0 {
+		opts.MaxAttachmentSize = DefaultPrintAttachmentSize
+	}
+	kit := printKit{Title: opts.Title, Generated: time.Now().Format("2006-01-02 15:04")}
+	if len(kit.Title) == 0 {
+		kit.Title = "Emergency Kit"
+	}
+
+	folders := make(map[string][]printItem)
+	for _, item := range b.Items {
+		folderName := b.FolderName(item.FolderID)
+		if !printSelected(item, folderName, opts) {
+			continue
+		}
+		pi, err := newPrintItem(b, item, opts.MaxAttachmentSize)
+		if err != nil {
+			return err
+		}
+		folders[folderName] = append(folders[folderName], pi)
+		kit.Count++
+	}
+	var names []string
+	for name := range folders {
+		names = append(names, name)
+	}
+	// items without a folder sort first
+	sort.Strings(names)
+	for _, name := range names {
+		items := folders[name]
+		sort.SliceStable(items, func(i, j int) bool {
+			return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
+		})
+		if len(name) == 0 {
+			name = "No Folder"
+		}
+		kit.Folders = append(kit.Folders, printFolder{Name: name, Items: items})
+	}
+	return printTemplate.Execute(w, kit)
+}
+
+func printSelected(item PortWardenElement, folderName string, opts PrintOptions) bool {
+	if len(opts.Folders) == 0 && len(opts.Items) == 0 {
+		return true
+	}
+	for _, folder := range opts.Folders {
+		folder = strings.Trim(folder, "/")
+		if strings.EqualFold(folderName, folder) || strings.HasPrefix(strings.ToLower(folderName), strings.ToLower(folder)+"/") {
+			return true
+		}
+	}
+	for _, name := range opts.Items {
+		if strings.EqualFold(item.Name, name) || item.ID == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newPrintItem(b *Backup, item PortWardenElement, maxAttachmentSize int) (printItem, error) {
+	pi := printItem{
+		Name:     item.Name,
+		Type:     printItemTypes[item.Type],
+		Identity: IdentityFields(item.Identity),
+		Notes:    stringValue(item.Notes),
+	}
+	if item.Login != nil {
+		for _, uri := range item.Login.Uris {
+			pi.URIs = append(pi.URIs, uri.URI)
+		}
+		pi.Username = stringValue(item.Login.Username)
+		pi.Password = stringValue(item.Login.Password)
+		if k, err := ParseItemTOTP(item); err == nil && k != nil {
+			png, err := TOTPQRCode(k.URI())
+			if err != nil {
+				return pi, err
+			}
+			pi.TOTP = k.Secret
+			pi.TOTPQRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		} else {
+			pi.TOTP = stringValue(item.Login.Totp)
+		}
+	}
+	if item.Card != nil {
+		for _, f := range []NamedValue{
+			{Name: "Cardholder Name", Value: item.Card.CardholderName},
+			{Name: "Brand", Value: item.Card.Brand},
+			{Name: "Number", Value: item.Card.Number},
+			{Name: "Expiration", Value: strings.Trim(item.Card.ExpMonth+" / "+item.Card.ExpYear, " /")},
+			{Name: "Security Code", Value: stringValue(item.Card.Code)},
+		} {
+			if len(f.Value) > 0 {
+				pi.Card = append(pi.Card, f)
+			}
+		}
+	}
+	for _, field := range item.Fields {
+		pi.Fields = append(pi.Fields, NamedValue{Name: stringValue(field.Name), Value: stringValue(field.Value)})
+	}
+
+	for _, attachment := range item.Attachments {
+		pa := printAttachment{FileName: attachment.FileName, SizeName: attachment.SizeName}
+		content, ok := b.Attachment(item, attachment)
+		switch {
+		case !ok:
+			pa.Omitted = "missing from the backup"
+		case len(content) > maxAttachmentSize:
+			pa.Omitted = "too large to print, see the backup"
+		default:
+			contentType := http.DetectContentType(content)
+			switch {
+			case strings.HasPrefix(contentType, "image/"):
+				pa.Image = template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(content))
+			case strings.HasPrefix(contentType, "text/") && utf8.Valid(content):
+				pa.Text = string(content)
+			default:
+				pa.Omitted = "can't be printed, see the backup"
+			}
+		}
+		pi.Attachments = append(pi.Attachments, pa)
+	}
+	return pi, nil
+}
+
+var printTemplate = template.Must(template.New("print").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 11pt; margin: 2em; color: #000; }
+h1 { margin-bottom: 0; }
+h2 { border-bottom: 2px solid #000; margin-top: 1.5em; page-break-after: avoid; }
+.item { border: 1px solid #888; padding: 0.5em 1em; margin: 1em 0; page-break-inside: avoid; }
+.item h3 { margin: 0.2em 0 0.5em; }
+.type { font-weight: normal; font-size: 0.8em; color: #555; }
+table { border-collapse: collapse; }
+th { text-align: left; vertical-align: top; padding: 0.1em 1em 0.1em 0; white-space: nowrap; }
+td { font-family: monospace; font-size: 1.1em; word-break: break-all; }
+pre { white-space: pre-wrap; font-size: 0.95em; margin: 0.3em 0; }
+.qr { width: 35mm; height: 35mm; }
+.attachment img { max-width: 100%; max-height: 120mm; }
+.omitted { color: #555; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Count}} items, printed {{.Generated}}. Keep this document somewhere safe.</p>
+{{range .Folders}}
+<h2>{{.Name}}</h2>
+{{range .Items}}
+<div class="item">
+<h3>{{.Name}} <span class="type">{{.Type}}</span></h3>
+<table>
+{{range .URIs}}<tr><th>URI</th><td>{{.}}</td></tr>
+{{end}}{{with .Username}}<tr><th>Username</th><td>{{.}}</td></tr>
+{{end}}{{with .Password}}<tr><th>Password</th><td>{{.}}</td></tr>
+{{end}}{{with .TOTP}}<tr><th>TOTP</th><td>{{.}}</td></tr>
+{{end}}{{range .Card}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}{{range .Identity}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}{{range .Fields}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{with .TOTPQRCode}}<img class="qr" src="{{.}}" alt="TOTP QR code">
+{{end}}{{with .Notes}}<h4>Notes</h4>
+<pre>{{.}}</pre>
+{{end}}{{with .Attachments}}<h4>Attachments</h4>
+{{range .}}<div class="attachment">
+<p>{{.FileName}} ({{.SizeName}}){{with .Omitted}} <span class="omitted">{{.}}</span>{{end}}</p>
+{{with .Text}}<pre>{{.}}</pre>
+{{end}}{{with .Image}}<img src="{{.}}" alt="">
+{{end}}</div>
+{{end}}{{end}}</div>
+{{end}}{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,48 @@
+package portwarden
+
+// Stages of a backup or a restore, as reported to Progress
+const (
+	StageFolders     = "folders"
+	StageCollections = "collections"
+	StageItems       = "items"
+	StageAttachments = "attachments"
+)
+
+// ProgressReporter follows a backup or a restore through its stages. Steps
+// and warnings come from the goroutines of the pacer, so an implementation
+// has to be safe for concurrent use.
+type ProgressReporter interface {
+	// Stage starts stage, which has total steps. The total is -1 while it
+	// isn't known yet, and Stage is called again for the same stage once it
+	// is.
+	Stage(stage string, total int)
+	// Step finishes one step of the current stage, name tells which, like
+	// the name of the item restored
+	Step(name string)
+	// Info tells what is going on besides the steps, like creating a
+	// missing collection
+	Info(message string)
+	// Warn reports a problem that doesn't stop the backup or restore
+	Warn(message string)
+}
+
+// Progress is where backups and restores report how far they are. It
+// discards everything by default.
+var Progress ProgressReporter = DiscardProgress{}
+
+// DiscardProgress is a ProgressReporter that reports nothing.
+type DiscardProgress struct{}
+
+func (DiscardProgress) Stage(stage string, total int) {}
+func (DiscardProgress) Step(name string)              {}
+func (DiscardProgress) Info(message string)           {}
+func (DiscardProgress) Warn(message string)           {}
+
+// reportListed reports the entries of a stage that lists them all at once,
+// like the folders of a backup, as its steps.
+func reportListed(stage string, names []string) {
+	Progress.Stage(stage, len(names))
+	for _, name := range names {
+		Progress.Step(name)
+	}
+}
@@ -0,0 +1,348 @@
+package portwarden
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+const (
+	PwnedHashSHA1 = "sha1"
+	PwnedHashNTLM = "ntlm"
+
+	ErrPwnedDBUnknownFormat = "not a Have I Been Pwned dataset, expected HASH:COUNT lines"
+	ErrPwnedDBNotSorted     = "the Have I Been Pwned dataset isn't sorted by hash"
+
+	// pwnedIndexMagic starts an index built by BuildPwnedIndex, followed by
+	// the hash length and fixed size records of the hash and a big endian
+	// uint32 count, sorted by hash.
+	pwnedIndexMagic    = "PWNDIDX1"
+	pwnedRangePrefixes = 5
+)
+
+// PwnedDB looks up password hashes in a local copy of the Have I Been Pwned
+// Pwned Passwords dataset without any network access. It reads
+//   - the sorted HASH:COUNT text file of SHA-1 or NTLM hashes, by binary
+//     search,
+//   - a directory of range files named after the first 5 hex digits of the
+//     hashes with SUFFIX:COUNT lines, as the haveibeenpwned-downloader writes
+//     them,
+//   - or an index built by BuildPwnedIndex.
+type PwnedDB struct {
+	HashType string
+	source   pwnedSource
+	hashLen  int
+	cache    map[string]int64
+}
+
+type pwnedSource interface {
+	// count returns how often the upper case hex hash was seen
+	count(hexHash string) (int64, error)
+	Close() error
+}
+
+// OpenPwnedDB opens the dataset at path, telling the kind of dataset and the
+// hash type from its content.
+func OpenPwnedDB(path string) (*PwnedDB, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return openPwnedRanges(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 128)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+	head = head[:n]
+	if bytes.HasPrefix(head, []byte(pwnedIndexMagic)) && len(head) > len(pwnedIndexMagic) {
+		hashLen := int(head[len(pwnedIndexMagic)])
+		return newPwnedDB(hashLen*2, &pwnedIndex{f: f, hashLen: hashLen, size: info.Size()})
+	}
+	hashLen := bytes.IndexByte(head, ':')
+	return newPwnedDB(hashLen, &pwnedTextFile{f: f, size: info.Size()})
+}
+
+func openPwnedRanges(dir string) (*PwnedDB, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "[0-9A-Fa-f][0-9A-Fa-f][0-9A-Fa-f][0-9A-Fa-f][0-9A-Fa-f]*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New(ErrPwnedDBUnknownFormat)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	suffixLen := strings.IndexByte(line, ':')
+	if suffixLen < 0 {
+		return nil, errors.New(ErrPwnedDBUnknownFormat)
+	}
+	return newPwnedDB(pwnedRangePrefixes+suffixLen, &pwnedRanges{dir: dir, ext: filepath.Ext(files[0])})
+}
+
+func newPwnedDB(hexLen int, source pwnedSource) (*PwnedDB, error) {
+	db := &PwnedDB{source: source, hashLen: hexLen, cache: make(map[string]int64)}
+	switch hexLen {
+	case sha1.Size * 2:
+		db.HashType = PwnedHashSHA1
+	case md4.Size * 2:
+		db.HashType = PwnedHashNTLM
+	default:
+		source.Close()
+		return nil, errors.New(ErrPwnedDBUnknownFormat)
+	}
+	return db, nil
+}
+
+// Count returns how often password appears in the dataset, 0 if it doesn't.
+func (db *PwnedDB) Count(password string) (int64, error) {
+	var h hash.Hash
+	if db.HashType == PwnedHashNTLM {
+		h = md4.New()
+		for _, u := range utf16.Encode([]rune(password)) {
+			h.Write([]byte{byte(u), byte(u >> 8)})
+		}
+	} else {
+		h = sha1.New()
+		h.Write([]byte(password))
+	}
+	hexHash := strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+	if count, ok := db.cache[hexHash]; ok {
+		return count, nil
+	}
+	count, err := db.source.count(hexHash)
+	if err != nil {
+		return 0, err
+	}
+	db.cache[hexHash] = count
+	return count, nil
+}
+
+func (db *PwnedDB) Close() error {
+	return db.source.Close()
+}
+
+// parsePwnedLine splits a HASH:COUNT line.
+func parsePwnedLine(line string) (string, int64, bool) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return "", 0, false
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(line[i+1:]), 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.ToUpper(line[:i]), count, true
+}
+
+type pwnedTextFile struct {
+	f    *os.File
+	size int64
+}
+
+// lineAt returns the first line starting at or after offset, and where the
+// next line starts.
+func (t *pwnedTextFile) lineAt(offset int64) (string, int64, int64, error) {
+	start := offset
+	buf := make([]byte, 256)
+	if offset > 0 {
+		// the line starts after the previous newline
+		n, err := t.f.ReadAt(buf, offset-1)
+		if err != nil && err != io.EOF {
+			return "", 0, 0, err
+		}
+		i := bytes.IndexByte(buf[:n], '\n')
+		if i < 0 {
+			return "", t.size, t.size, nil
+		}
+		start = offset + int64(i)
+	}
+	if start >= t.size {
+		return "", t.size, t.size, nil
+	}
+	n, err := t.f.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return "", 0, 0, err
+	}
+	line := buf[:n]
+	next := start + int64(n)
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+		next = start + int64(i) + 1
+	}
+	return strings.TrimRight(string(line), "\r"), start, next, nil
+}
+
+func (t *pwnedTextFile) count(hexHash string) (int64, error) {
+	lo, hi := int64(0), t.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, start, next, err := t.lineAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= t.size {
+			hi = mid
+			continue
+		}
+		lineHash, count, ok := parsePwnedLine(line)
+		if !ok {
+			return 0, errors.New(ErrPwnedDBUnknownFormat)
+		}
+		switch {
+		case lineHash == hexHash:
+			return count, nil
+		case lineHash < hexHash:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+	return 0, nil
+}
+
+func (t *pwnedTextFile) Close() error {
+	return t.f.Close()
+}
+
+type pwnedRanges struct {
+	dir string
+	ext string
+}
+
+func (r *pwnedRanges) count(hexHash string) (int64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(r.dir, hexHash[:pwnedRangePrefixes]+r.ext))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	suffix := hexHash[pwnedRangePrefixes:]
+	for _, line := range strings.Split(string(content), "\n") {
+		if lineSuffix, count, ok := parsePwnedLine(strings.TrimRight(line, "\r")); ok && lineSuffix == suffix {
+			return count, nil
+		}
+	}
+	return 0, nil
+}
+
+func (r *pwnedRanges) Close() error {
+	return nil
+}
+
+type pwnedIndex struct {
+	f       *os.File
+	hashLen int
+	size    int64
+}
+
+func (x *pwnedIndex) count(hexHash string) (int64, error) {
+	target, err := hex.DecodeString(hexHash)
+	if err != nil {
+		return 0, err
+	}
+	recordLen := int64(x.hashLen + 4)
+	header := int64(len(pwnedIndexMagic) + 1)
+	records := (x.size - header) / recordLen
+	record := make([]byte, recordLen)
+	var readErr error
+	i := sort.Search(int(records), func(i int) bool {
+		if _, err := x.f.ReadAt(record, header+int64(i)*recordLen); err != nil {
+			readErr = err
+			return true
+		}
+		return bytes.Compare(record[:x.hashLen], target) >= 0
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+	if int64(i) == records {
+		return 0, nil
+	}
+	if _, err := x.f.ReadAt(record, header+int64(i)*recordLen); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(record[:x.hashLen], target) {
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint32(record[x.hashLen:])), nil
+}
+
+func (x *pwnedIndex) Close() error {
+	return x.f.Close()
+}
+
+// BuildPwnedIndex converts a sorted HASH:COUNT text file of the Have I Been
+// Pwned dataset into the binary index OpenPwnedDB reads, which is about half
+// the size and has fixed size records. It returns the number of hashes.
+func BuildPwnedIndex(r io.Reader, w io.Writer) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	bw := bufio.NewWriterSize(w, 1<<20)
+	var previous []byte
+	var n int64
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		hexHash, count, ok := parsePwnedLine(line)
+		if !ok {
+			return n, fmt.Errorf("%v: line %d", ErrPwnedDBUnknownFormat, n+1)
+		}
+		h, err := hex.DecodeString(hexHash)
+		if err != nil || (len(h) != sha1.Size && len(h) != md4.Size) {
+			return n, fmt.Errorf("%v: line %d", ErrPwnedDBUnknownFormat, n+1)
+		}
+		if previous == nil {
+			bw.WriteString(pwnedIndexMagic)
+			bw.WriteByte(byte(len(h)))
+		} else if len(h) != len(previous) || bytes.Compare(previous, h) >= 0 {
+			return n, fmt.Errorf("%v: line %d", ErrPwnedDBNotSorted, n+1)
+		}
+		if count > 0xFFFFFFFF {
+			count = 0xFFFFFFFF
+		}
+		var countBytes [4]byte
+		binary.BigEndian.PutUint32(countBytes[:], uint32(count))
+		bw.Write(h)
+		if _, err := bw.Write(countBytes[:]); err != nil {
+			return n, err
+		}
+		previous = h
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
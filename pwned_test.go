@@ -0,0 +1,94 @@
+package portwarden
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writePwnedFiles writes a sorted HASH:COUNT file of n hashes with CRLF line
+// ends, as the dataset comes, and its index. It returns both paths and the
+// hashes with their counts.
+func writePwnedFiles(t *testing.T, n int) (string, string, []string, map[string]int64) {
+	t.Helper()
+	counts := map[string]int64{}
+	var hashes []string
+	for i := 0; i < n; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprint("password", i)))
+		hexHash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		hashes = append(hashes, hexHash)
+		counts[hexHash] = int64(i + 1)
+	}
+	sort.Strings(hashes)
+	var text bytes.Buffer
+	for _, hexHash := range hashes {
+		fmt.Fprintf(&text, "%v:%d\r\n", hexHash, counts[hexHash])
+	}
+	dir, err := ioutil.TempDir("", "pwned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	textPath, indexPath := filepath.Join(dir, "pwned.txt"), filepath.Join(dir, "pwned.idx")
+	if err := ioutil.WriteFile(textPath, text.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var index bytes.Buffer
+	if built, err := BuildPwnedIndex(bytes.NewReader(text.Bytes()), &index); err != nil || built != int64(n) {
+		t.Fatalf("BuildPwnedIndex = %v, %v, want %v", built, err, n)
+	}
+	if err := ioutil.WriteFile(indexPath, index.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return textPath, indexPath, hashes, counts
+}
+
+func TestPwnedSourceCount(t *testing.T) {
+	textPath, indexPath, hashes, counts := writePwnedFiles(t, 50)
+	first, last := hashes[0], hashes[len(hashes)-1]
+	for _, path := range []string{textPath, indexPath} {
+		db, err := OpenPwnedDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.HashType != PwnedHashSHA1 {
+			t.Errorf("%v: HashType = %v, want %v", filepath.Base(path), db.HashType, PwnedHashSHA1)
+		}
+		for _, tc := range []struct {
+			name    string
+			hexHash string
+			want    int64
+		}{
+			{"first", first, counts[first]},
+			{"middle", hashes[25], counts[hashes[25]]},
+			{"last", last, counts[last]},
+			{"before the first", strings.Repeat("0", 40), 0},
+			{"between two", first[:39] + nextHexDigit(first[39]), 0},
+			{"after the last", strings.Repeat("F", 40), 0},
+		} {
+			got, err := db.source.count(tc.hexHash)
+			if err != nil {
+				t.Errorf("%v: count of the %v hash: %v", filepath.Base(path), tc.name, err)
+			} else if got != tc.want {
+				t.Errorf("%v: count of the %v hash = %v, want %v", filepath.Base(path), tc.name, got, tc.want)
+			}
+		}
+		if got, err := db.Count("password7"); err != nil || got != 8 {
+			t.Errorf("%v: Count(password7) = %v, %v, want 8", filepath.Base(path), got, err)
+		}
+		db.Close()
+	}
+}
+
+// nextHexDigit returns the hex digit after c, which mustn't be F.
+func nextHexDigit(c byte) string {
+	const digits = "0123456789ABCDEF"
+	return string(digits[strings.IndexByte(digits, c)+1])
+}
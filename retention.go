@@ -0,0 +1,122 @@
+package portwarden
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ErrEmptyRetentionPolicy = "the retention policy keeps nothing, set keep_last, daily, weekly, monthly or yearly"
+)
+
+// RetentionPolicy is a grandfather-father-son policy: it keeps the KeepLast
+// newest backups, and the newest backup of each of the last Daily days,
+// Weekly ISO weeks, Monthly months and Yearly years that have backups. The
+// rules add up, and everything no rule keeps is pruned.
+type RetentionPolicy struct {
+	KeepLast int `yaml:"keep_last" json:"keep_last"`
+	Daily    int `yaml:"daily" json:"daily"`
+	Weekly   int `yaml:"weekly" json:"weekly"`
+	Monthly  int `yaml:"monthly" json:"monthly"`
+	Yearly   int `yaml:"yearly" json:"yearly"`
+}
+
+// IsSet tells whether the policy keeps anything. Pruning with an empty
+// policy would delete every backup, so it means no pruning at all.
+func (p RetentionPolicy) IsSet() bool {
+	return p.KeepLast > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0 || p.Yearly > 0
+}
+
+// BackupFile is a backup that a retention policy may prune.
+type BackupFile struct {
+	// Name is the path of a local backup or the title of a Drive file
+	Name string `json:"name"`
+	// ID tells backups apart where names don't, like on Drive
+	ID   string    `json:"id,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// RetentionDecision tells whether a policy keeps a backup.
+type RetentionDecision struct {
+	BackupFile
+	// Reasons are the rules that keep the backup, like "daily 2026-10-19",
+	// none if it is pruned
+	Reasons []string `json:"reasons"`
+}
+
+func (d RetentionDecision) Keep() bool {
+	return len(d.Reasons) > 0
+}
+
+// Apply decides for each backup whether the policy keeps it, newest first.
+func (p RetentionPolicy) Apply(backups []BackupFile) ([]RetentionDecision, error) {
+	if !p.IsSet() {
+		return nil, errors.New(ErrEmptyRetentionPolicy)
+	}
+	decisions := make([]RetentionDecision, len(backups))
+	for i, b := range backups {
+		decisions[i].BackupFile = b
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		if decisions[i].Time.Equal(decisions[j].Time) {
+			return decisions[i].Name > decisions[j].Name
+		}
+		return decisions[i].Time.After(decisions[j].Time)
+	})
+	for i := 0; i < p.KeepLast && i < len(decisions); i++ {
+		decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("last %v", p.KeepLast))
+	}
+	for _, rule := range []struct {
+		name   string
+		count  int
+		period func(t time.Time) string
+	}{
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%v-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	} {
+		seen := map[string]bool{}
+		for i := range decisions {
+			if len(seen) == rule.count {
+				break
+			}
+			period := rule.period(decisions[i].Time.Local())
+			if seen[period] {
+				continue
+			}
+			seen[period] = true
+			decisions[i].Reasons = append(decisions[i].Reasons, rule.name+" "+period)
+		}
+	}
+	return decisions, nil
+}
+
+// ListBackupFiles returns the .portwarden files in dir whose names match
+// pattern, or all of them if it is nil, with their modification times.
+func ListBackupFiles(dir string, pattern *regexp.Regexp) ([]BackupFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []BackupFile
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), ".portwarden") {
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(info.Name()) {
+			continue
+		}
+		backups = append(backups, BackupFile{Name: filepath.Join(dir, info.Name()), Time: info.ModTime()})
+	}
+	return backups, nil
+}
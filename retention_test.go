@@ -0,0 +1,53 @@
+package portwarden
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyApply(t *testing.T) {
+	// a backup at noon on each of the 60 days from 2026-08-21 to 2026-10-19,
+	// a Monday
+	var backups []BackupFile
+	for day := time.Date(2026, 8, 21, 12, 0, 0, 0, time.Local); day.Month() != 10 || day.Day() <= 19; day = day.AddDate(0, 0, 1) {
+		backups = append(backups, BackupFile{Name: day.Format("2006-01-02"), Time: day})
+	}
+	if len(backups) != 60 {
+		t.Fatalf("got %v backups, want 60", len(backups))
+	}
+	policy := RetentionPolicy{KeepLast: 3, Daily: 7, Weekly: 4, Monthly: 3, Yearly: 1}
+	decisions, err := policy.Apply(backups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != len(backups) || decisions[0].Name != "2026-10-19" || decisions[59].Name != "2026-08-21" {
+		t.Fatalf("Apply didn't return every backup newest first")
+	}
+	want := map[string]string{
+		"2026-10-19": "last 3, daily 2026-10-19, weekly 2026-W43, monthly 2026-10, yearly 2026",
+		"2026-10-18": "last 3, daily 2026-10-18, weekly 2026-W42",
+		"2026-10-17": "last 3, daily 2026-10-17",
+		"2026-10-16": "daily 2026-10-16",
+		"2026-10-15": "daily 2026-10-15",
+		"2026-10-14": "daily 2026-10-14",
+		"2026-10-13": "daily 2026-10-13",
+		"2026-10-11": "weekly 2026-W41",
+		"2026-10-04": "weekly 2026-W40",
+		"2026-09-30": "monthly 2026-09",
+		"2026-08-31": "monthly 2026-08",
+	}
+	got := map[string]string{}
+	for _, d := range decisions {
+		if d.Keep() {
+			got[d.Name] = strings.Join(d.Reasons, ", ")
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply kept %v, want %v", got, want)
+	}
+	if _, err := (RetentionPolicy{}).Apply(backups); err == nil {
+		t.Error("Apply of an empty policy succeeded")
+	}
+}
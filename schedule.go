@@ -0,0 +1,218 @@
+package portwarden
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ErrScheduleFields    = "expected 5 fields: minute hour day-of-month month day-of-week"
+	ErrScheduleNeverRuns = "the schedule never runs"
+	ErrScheduleEvery     = "@every needs a duration of at least a minute"
+)
+
+// Schedule tells when a backup runs next.
+type Schedule interface {
+	// Next returns the first time after t, or the zero time if there is none
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a cron expression in local time, like
+// "30 3 * * *" or "0 */6 * * mon-fri", one of the descriptors @hourly,
+// @daily, @weekly, @monthly and @yearly, or "@every 12h".
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+		if every < time.Minute {
+			return nil, errors.New(ErrScheduleEvery)
+		}
+		return everySchedule(every), nil
+	}
+	if expr, ok := scheduleDescriptors[spec]; ok {
+		spec = expr
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New(ErrScheduleFields)
+	}
+	var s cronSchedule
+	var err error
+	for i, f := range []struct {
+		field    *uint64
+		min, max int
+		names    []string
+	}{
+		{&s.minute, 0, 59, nil},
+		{&s.hour, 0, 23, nil},
+		{&s.dom, 1, 31, nil},
+		{&s.month, 1, 12, monthNames},
+		{&s.dow, 0, 7, dayNames},
+	} {
+		if *f.field, err = parseScheduleField(fields[i], f.min, f.max, f.names); err != nil {
+			return nil, fmt.Errorf("field %v: %v", i+1, err)
+		}
+	}
+	// 7 is Sunday as well
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// like cron, a day has to match either of the day fields unless one is *
+	s.anyDay = fields[2] == "*" || fields[4] == "*"
+	if s.Next(time.Now()).IsZero() {
+		return nil, errors.New(ErrScheduleNeverRuns)
+	}
+	return s, nil
+}
+
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// parseScheduleField parses a comma separated list of *, values and ranges,
+// each with an optional /step, into a bit set.
+func parseScheduleField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, errors.New("bad step")
+			}
+			rangePart = part[:i]
+		}
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = scheduleValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = scheduleValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 on
+				hi = max
+			}
+			if hi < lo {
+				return 0, errors.New("bad range")
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func scheduleValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if len(name) > 0 && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%q isn't between %v and %v", value, min, max)
+	}
+	return v, nil
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDay                        bool
+}
+
+func (s cronSchedule) Next(t time.Time) time.Time {
+	t = scheduleDate(t, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+	// a day that exists at all is at most 8 years away, like Feb 29
+	limit := t.AddDate(9, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = scheduleDate(t, t.Year(), t.Month()+1, 1, 0, 0)
+		case !s.dayMatches(t):
+			t = scheduleDate(t, t.Year(), t.Month(), t.Day()+1, 0, 0)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next := scheduleDate(t, t.Year(), t.Month(), t.Day(), t.Hour()+1, 0)
+			if s.skipped(t.Hour()+1, next) {
+				return next
+			}
+			t = next
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			next := scheduleDate(t, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+			if t.Minute() == 59 && s.skipped(t.Hour()+1, next) {
+				return next
+			}
+			t = next
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// skipped tells whether hour is one of the schedule that the clocks skip
+// going forward, next being where they do. Like cron, the schedule runs
+// then instead of not at all.
+func (s cronSchedule) skipped(hour int, next time.Time) bool {
+	return hour < 24 && next.Hour() != hour && s.hour&(1<<uint(hour)) != 0
+}
+
+// scheduleDate returns the first time after t whose wall clock in the
+// location of t reads the date given, or later. That is time.Date unless the
+// clocks skip the date going forward, where time.Date may return a time
+// before the gap and Next would never get past it.
+func scheduleDate(t time.Time, year int, month time.Month, day, hour, min int) time.Time {
+	next := time.Date(year, month, day, hour, min, 0, 0, t.Location())
+	want := time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	if next.After(t) && wallClock(next).Equal(want) {
+		return next
+	}
+	next = t.Truncate(time.Minute)
+	for !next.After(t) || wallClock(next).Before(want) {
+		next = next.Add(time.Minute)
+	}
+	return next
+}
+
+// wallClock returns what the clock reads at t, as a time in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
@@ -0,0 +1,43 @@
+package portwarden
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, tc := range []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// Feb 29 is next in 2028
+		{"0 0 29 2 *", time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2032, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day field matches: the first seven days, or Mondays
+		{"0 0 1-7 * mon", time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC), time.Date(2026, 10, 26, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-7 * mon", time.Date(2026, 10, 26, 0, 0, 0, 0, time.UTC), time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-7 * mon", time.Date(2026, 11, 7, 0, 0, 0, 0, time.UTC), time.Date(2026, 11, 9, 0, 0, 0, 0, time.UTC)},
+		// 2:30 doesn't exist on March 8 2026 in New York, the backup runs
+		// when the clocks go forward
+		{"30 2 * * *", time.Date(2026, 3, 7, 2, 30, 0, 0, newYork), time.Date(2026, 3, 8, 3, 0, 0, 0, newYork)},
+		{"30 2 * * *", time.Date(2026, 3, 8, 3, 0, 0, 0, newYork), time.Date(2026, 3, 9, 2, 30, 0, 0, newYork)},
+		{"0 3 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, newYork), time.Date(2026, 3, 8, 3, 0, 0, 0, newYork)},
+		{"*/30 1,2 * * *", time.Date(2026, 3, 8, 1, 30, 0, 0, newYork), time.Date(2026, 3, 8, 3, 0, 0, 0, newYork)},
+		// 1:30 happens twice on November 1 2026, the backup runs once
+		{"30 1 * * *", time.Date(2026, 11, 1, 0, 0, 0, 0, newYork), time.Date(2026, 11, 1, 1, 30, 0, 0, newYork)},
+		{"30 1 * * *", time.Date(2026, 11, 1, 1, 30, 0, 0, newYork), time.Date(2026, 11, 2, 1, 30, 0, 0, newYork)},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", tc.spec, err)
+		}
+		if got := s.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("%q after %v = %v, want %v", tc.spec, tc.from, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package portwarden
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	ErrSearchUnknownField = "the item has no such field"
+
+	SearchFieldName     = "name"
+	SearchFieldFolder   = "folder"
+	SearchFieldUsername = "username"
+	SearchFieldURI      = "uri"
+	SearchFieldNotes    = "notes"
+	SearchFieldField    = "field"
+)
+
+// SearchOptions configures SearchItems.
+type SearchOptions struct {
+	// Regex treats the query as a regular expression instead of a substring
+	Regex         bool
+	CaseSensitive bool
+}
+
+// SearchMatch is an item that matched a search and what in it matched.
+type SearchMatch struct {
+	Item   PortWardenElement
+	Folder string
+	// Fields names what matched, e.g. name, username or field:PIN
+	Fields []string
+}
+
+// SearchItems finds the items of b whose name, username, URIs, notes, custom
+// field names or folder name match query. Passwords and other secret values
+// aren't searched.
+func SearchItems(b *Backup, query string, opts SearchOptions) ([]SearchMatch, error) {
+	var match func(s string) bool
+	if opts.Regex {
+		if !opts.CaseSensitive {
+			query = "(?i)" + query
+		}
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	} else if opts.CaseSensitive {
+		match = func(s string) bool { return strings.Contains(s, query) }
+	} else {
+		lower := strings.ToLower(query)
+		match = func(s string) bool { return strings.Contains(strings.ToLower(s), lower) }
+	}
+
+	var matches []SearchMatch
+	for _, item := range b.Items {
+		m := SearchMatch{Item: item, Folder: b.FolderName(item.FolderID)}
+		check := func(field, value string) {
+			if len(value) > 0 && match(value) {
+				m.Fields = append(m.Fields, field)
+			}
+		}
+		check(SearchFieldName, item.Name)
+		check(SearchFieldFolder, m.Folder)
+		if item.Login != nil {
+			check(SearchFieldUsername, stringValue(item.Login.Username))
+			for _, uri := range item.Login.Uris {
+				if match(uri.URI) {
+					m.Fields = append(m.Fields, SearchFieldURI)
+					break
+				}
+			}
+		}
+		check(SearchFieldNotes, stringValue(item.Notes))
+		for _, field := range item.Fields {
+			check(SearchFieldField+":"+stringValue(field.Name), stringValue(field.Name))
+		}
+		if len(m.Fields) > 0 {
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// ItemField returns a value of item by name: username, password, totp, uri
+// (the first one), notes, card.number, card.code or the name of a custom
+// field, compared case-insensitively.
+func ItemField(item PortWardenElement, name string) (string, error) {
+	var value *string
+	switch strings.ToLower(name) {
+	case "username":
+		if item.Login != nil {
+			value = item.Login.Username
+		}
+	case "password":
+		if item.Login != nil {
+			value = item.Login.Password
+		}
+	case "totp":
+		if item.Login != nil {
+			value = item.Login.Totp
+		}
+	case "uri":
+		if item.Login != nil && len(item.Login.Uris) > 0 {
+			value = &item.Login.Uris[0].URI
+		}
+	case "notes":
+		value = item.Notes
+	case "card.number":
+		if item.Card != nil {
+			value = &item.Card.Number
+		}
+	case "card.code":
+		if item.Card != nil {
+			value = item.Card.Code
+		}
+	default:
+		for _, field := range item.Fields {
+			if strings.EqualFold(stringValue(field.Name), name) {
+				value = field.Value
+				break
+			}
+		}
+	}
+	if value == nil || len(*value) == 0 {
+		return "", errors.New(ErrSearchUnknownField + ": " + name)
+	}
+	return *value, nil
+}
@@ -0,0 +1,76 @@
+package portwarden
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchItems(t *testing.T) {
+	example := testLogin("l1", "Example", "https://example.com/login", "alice", "s3cret", "")
+	example.FolderID = strPtr("f1")
+	example.Fields = []Field{{Name: strPtr("PIN"), Value: strPtr("1234"), Type: FieldTypeHidden}}
+	mail := testLogin("l2", "a.c Mail", "https://mail.example.org", "bob", "example", "")
+	mail.Notes = strPtr("recovery codes for abc")
+	b := &Backup{Items: PortWarden{example, mail}, Folders: PortWardenFolder{{ID: strPtr("f1"), Name: "Banking"}}}
+	for _, tc := range []struct {
+		name  string
+		query string
+		opts  SearchOptions
+		want  map[string][]string
+	}{
+		{"substring ignores case", "EXAMPLE", SearchOptions{},
+			map[string][]string{"l1": {"name", "uri"}, "l2": {"uri"}}},
+		{"case sensitive substring", "Example", SearchOptions{CaseSensitive: true},
+			map[string][]string{"l1": {"name"}}},
+		{"substring is literal", "a.c", SearchOptions{},
+			map[string][]string{"l2": {"name"}}},
+		{"regex", "a.c", SearchOptions{Regex: true},
+			map[string][]string{"l2": {"name", "notes"}}},
+		{"regex ignores case", "^BANK", SearchOptions{Regex: true},
+			map[string][]string{"l1": {"folder"}}},
+		{"case sensitive regex", "^bank", SearchOptions{Regex: true, CaseSensitive: true},
+			map[string][]string{}},
+		{"username and field name", "^(bob|pin)$", SearchOptions{Regex: true},
+			map[string][]string{"l1": {"field:PIN"}, "l2": {"username"}}},
+		{"secrets aren't searched", "s3cret", SearchOptions{},
+			map[string][]string{}},
+	} {
+		matches, err := SearchItems(b, tc.query, tc.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		got := map[string][]string{}
+		for _, m := range matches {
+			got[m.Item.ID] = m.Fields
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: SearchItems(%q) matched %v, want %v", tc.name, tc.query, got, tc.want)
+		}
+	}
+	if _, err := SearchItems(b, "(", SearchOptions{Regex: true}); err == nil {
+		t.Error("SearchItems accepted an invalid regex")
+	}
+}
+
+func TestItemField(t *testing.T) {
+	item := testLogin("l1", "Example", "https://example.com", "alice", "s3cret", "")
+	item.Fields = []Field{{Name: strPtr("PIN"), Value: strPtr("1234")}}
+	for _, tc := range []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"password", "s3cret", true},
+		{"Username", "alice", true},
+		{"uri", "https://example.com", true},
+		{"pin", "1234", true},
+		{"totp", "", false},
+		{"card.number", "", false},
+	} {
+		got, err := ItemField(item, tc.name)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("ItemField(%q) = %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
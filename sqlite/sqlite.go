@@ -0,0 +1,318 @@
+// Package sqlite exports backups to SQLite databases. It is kept out of
+// package portwarden because the driver needs cgo, so only programs that
+// import it need a C compiler.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/vwxyzjn/portwarden"
+)
+
+const (
+	ExportFormat = "sqlite"
+
+	// DriverName is the database/sql driver Export opens, registered by
+	// github.com/mattn/go-sqlite3.
+	DriverName = "sqlite3"
+
+	// SchemaVersion is stored as the `user_version` of the database and
+	// only changes when Schema changes in a way that breaks queries.
+	SchemaVersion = 1
+)
+
+// Schema is the schema of an SQLite export. Every table besides folders
+// references items by item_id, and columns marked secret are NULL unless the
+// export includes secrets.
+const Schema = `
+CREATE TABLE folders (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+
+CREATE TABLE items (
+	id              TEXT PRIMARY KEY,
+	type            INTEGER NOT NULL, -- 1 login, 2 secure note, 3 card, 4 identity
+	type_name       TEXT NOT NULL,    -- login, secure_note, card or identity
+	name            TEXT NOT NULL,
+	folder_id       TEXT REFERENCES folders (id),
+	organization_id TEXT,
+	favorite        INTEGER NOT NULL, -- 0 or 1
+	revision_date   TEXT,             -- RFC 3339
+	notes           TEXT              -- secret
+);
+
+CREATE TABLE logins (
+	item_id                TEXT PRIMARY KEY REFERENCES items (id),
+	username               TEXT,
+	password               TEXT,             -- secret
+	totp                   TEXT,             -- secret
+	has_password           INTEGER NOT NULL, -- 0 or 1
+	has_totp               INTEGER NOT NULL, -- 0 or 1
+	password_revision_date TEXT              -- RFC 3339
+);
+
+CREATE TABLE uris (
+	item_id    TEXT NOT NULL REFERENCES items (id),
+	position   INTEGER NOT NULL,
+	uri        TEXT NOT NULL,
+	scheme     TEXT,    -- e.g. https, NULL when the URI can't be parsed
+	host       TEXT,    -- without the port
+	match_type INTEGER, -- Bitwarden's URI match detection, NULL for the default
+	PRIMARY KEY (item_id, position)
+);
+
+CREATE TABLE fields (
+	item_id  TEXT NOT NULL REFERENCES items (id),
+	position INTEGER NOT NULL,
+	name     TEXT,
+	value    TEXT,             -- secret for hidden fields
+	type     INTEGER NOT NULL, -- 0 text, 1 hidden, 2 boolean
+	PRIMARY KEY (item_id, position)
+);
+
+CREATE TABLE password_history (
+	item_id        TEXT NOT NULL REFERENCES items (id),
+	position       INTEGER NOT NULL, -- 0 is the most recent
+	last_used_date TEXT,             -- RFC 3339
+	password       TEXT,             -- secret
+	PRIMARY KEY (item_id, position)
+);
+
+CREATE TABLE cards (
+	item_id         TEXT PRIMARY KEY REFERENCES items (id),
+	cardholder_name TEXT,
+	brand           TEXT,
+	number          TEXT, -- secret
+	number_last4    TEXT,
+	exp_month       INTEGER,
+	exp_year        INTEGER,
+	code            TEXT  -- secret
+);
+
+CREATE TABLE identities (
+	item_id         TEXT PRIMARY KEY REFERENCES items (id),
+	title           TEXT,
+	first_name      TEXT,
+	middle_name     TEXT,
+	last_name       TEXT,
+	username        TEXT,
+	company         TEXT,
+	email           TEXT,
+	phone           TEXT,
+	address1        TEXT,
+	address2        TEXT,
+	address3        TEXT,
+	city            TEXT,
+	state           TEXT,
+	postal_code     TEXT,
+	country         TEXT,
+	ssn             TEXT, -- secret
+	passport_number TEXT, -- secret
+	license_number  TEXT  -- secret
+);
+
+CREATE TABLE attachments (
+	item_id   TEXT NOT NULL REFERENCES items (id),
+	id        TEXT NOT NULL,
+	file_name TEXT NOT NULL,
+	size      INTEGER, -- bytes
+	size_name TEXT,
+	path      TEXT,    -- in the backup archive, NULL if it is missing
+	PRIMARY KEY (item_id, id)
+);
+
+CREATE INDEX items_folder_id ON items (folder_id);
+CREATE INDEX uris_host ON uris (host);
+`
+
+var itemTypeNames = map[int64]string{
+	portwarden.ItemTypeLogin:      "login",
+	portwarden.ItemTypeSecureNote: "secure_note",
+	portwarden.ItemTypeCard:       "card",
+	portwarden.ItemTypeIdentity:   "identity",
+}
+
+// Options configures an export to an SQLite database.
+type Options struct {
+	// IncludeSecrets fills the columns marked secret in Schema
+	IncludeSecrets bool
+}
+
+// Export writes the items and folders of b to a new SQLite database at
+// fileName, replacing the file if it exists, using Schema.
+func Export(b *portwarden.Backup, fileName string, opts Options) error {
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// create the file first so that the database isn't readable by others
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	db, err := sql.Open(DriverName, fileName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := write(tx, b, opts); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func write(tx *sql.Tx, b *portwarden.Backup, opts Options) error {
+	if _, err := tx.Exec(Schema); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		return err
+	}
+	secret := func(s string) interface{} {
+		if !opts.IncludeSecrets {
+			return nil
+		}
+		return nullString(s)
+	}
+
+	for _, folder := range b.Folders {
+		if folder.ID == nil {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO folders (id, name) VALUES (?, ?)", *folder.ID, folder.Name); err != nil {
+			return err
+		}
+	}
+	for _, item := range b.Items {
+		if _, err := tx.Exec("INSERT INTO items (id, type, type_name, name, folder_id, organization_id, favorite, revision_date, notes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			item.ID, item.Type, itemTypeNames[item.Type], item.Name, item.FolderID, item.OrganizationID, item.Favorite, nullString(item.RevisionDate), secret(stringValue(item.Notes))); err != nil {
+			return err
+		}
+
+		if item.Login != nil {
+			password, totp := stringValue(item.Login.Password), stringValue(item.Login.Totp)
+			if _, err := tx.Exec("INSERT INTO logins (item_id, username, password, totp, has_password, has_totp, password_revision_date) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				item.ID, item.Login.Username, secret(password), secret(totp), len(password) > 0, len(totp) > 0, item.Login.PasswordRevisionDate); err != nil {
+				return err
+			}
+			for i, uri := range item.Login.Uris {
+				var scheme, host interface{}
+				if u, err := url.Parse(uri.URI); err == nil {
+					scheme, host = nullString(u.Scheme), nullString(u.Hostname())
+				}
+				if _, err := tx.Exec("INSERT INTO uris (item_id, position, uri, scheme, host, match_type) VALUES (?, ?, ?, ?, ?, ?)",
+					item.ID, i, uri.URI, scheme, host, matchType(uri.Match)); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, field := range item.Fields {
+			var value interface{} = field.Value
+			if field.Type == portwarden.FieldTypeHidden {
+				value = secret(stringValue(field.Value))
+			}
+			if _, err := tx.Exec("INSERT INTO fields (item_id, position, name, value, type) VALUES (?, ?, ?, ?, ?)",
+				item.ID, i, field.Name, value, field.Type); err != nil {
+				return err
+			}
+		}
+
+		for i, ph := range item.PasswordHistory {
+			if _, err := tx.Exec("INSERT INTO password_history (item_id, position, last_used_date, password) VALUES (?, ?, ?, ?)",
+				item.ID, i, nullString(ph.LastUsedDate), secret(ph.Password)); err != nil {
+				return err
+			}
+		}
+
+		if item.Card != nil {
+			var last4 interface{}
+			if number := strings.Replace(item.Card.Number, " ", "", -1); len(number) >= 4 {
+				last4 = number[len(number)-4:]
+			}
+			if _, err := tx.Exec("INSERT INTO cards (item_id, cardholder_name, brand, number, number_last4, exp_month, exp_year, code) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+				item.ID, nullString(item.Card.CardholderName), nullString(item.Card.Brand), secret(item.Card.Number), last4,
+				nullInt(item.Card.ExpMonth), nullInt(item.Card.ExpYear), secret(stringValue(item.Card.Code))); err != nil {
+				return err
+			}
+		}
+
+		if id := item.Identity; id != nil {
+			if _, err := tx.Exec("INSERT INTO identities (item_id, title, first_name, middle_name, last_name, username, company, email, phone, address1, address2, address3, city, state, postal_code, country, ssn, passport_number, license_number) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				item.ID, text(id.Title), text(id.FirstName), text(id.MiddleName), text(id.LastName),
+				text(id.Username), text(id.Company), text(id.Email), text(id.Phone),
+				text(id.Address1), text(id.Address2), text(id.Address3), text(id.City),
+				text(id.State), text(id.PostalCode), text(id.Country),
+				secret(identityText(id.Ssn)), secret(identityText(id.PassportNumber)), secret(identityText(id.LicenseNumber))); err != nil {
+				return err
+			}
+		}
+
+		for _, attachment := range item.Attachments {
+			var attachmentPath interface{}
+			if _, ok := b.Attachment(item, attachment); ok {
+				attachmentPath = b.Manifest.AttachmentPath(item, attachment)
+			}
+			if _, err := tx.Exec("INSERT INTO attachments (item_id, id, file_name, size, size_name, path) VALUES (?, ?, ?, ?, ?, ?)",
+				item.ID, attachment.ID, attachment.FileName, nullInt(attachment.Size), nullString(attachment.SizeName), attachmentPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+func nullInt(s string) interface{} {
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return i
+}
+
+// text stores the untyped values of an identity as text.
+func text(v interface{}) interface{} {
+	return nullString(identityText(v))
+}
+
+func identityText(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func matchType(v interface{}) interface{} {
+	if f, ok := v.(float64); ok {
+		return int64(f)
+	}
+	return nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,205 @@
+package portwarden
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ErrInvalidTOTP = "invalid TOTP secret"
+
+	TOTPAlgorithmSHA1   = "SHA1"
+	TOTPAlgorithmSHA256 = "SHA256"
+	TOTPAlgorithmSHA512 = "SHA512"
+
+	steamTOTPPrefix = "steam://"
+	steamTOTPDigits = 5
+	steamAlphabet   = "23456789BCDFGHJKMNPQRTVWXY"
+)
+
+// TOTPKey is a parsed `Login.Totp`. Bitwarden accepts a bare base32 secret,
+// an otpauth:// URI or a steam:// secret for Steam Guard codes.
+type TOTPKey struct {
+	Secret    string
+	Issuer    string
+	Account   string
+	Algorithm string
+	Digits    int
+	Period    int
+	Steam     bool
+}
+
+func ParseTOTP(totp string) (*TOTPKey, error) {
+	totp = strings.TrimSpace(totp)
+	k := &TOTPKey{Algorithm: TOTPAlgorithmSHA1, Digits: 6, Period: 30}
+	switch {
+	case strings.HasPrefix(strings.ToLower(totp), "otpauth://"):
+		u, err := url.Parse(totp)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(u.Host, "totp") {
+			return nil, errors.New(ErrInvalidTOTP + ": only totp is supported, not " + u.Host)
+		}
+		label := strings.TrimPrefix(u.Path, "/")
+		if i := strings.Index(label, ":"); i >= 0 {
+			k.Issuer = strings.TrimSpace(label[:i])
+			label = label[i+1:]
+		}
+		k.Account = strings.TrimSpace(label)
+		q := u.Query()
+		k.Secret = q.Get("secret")
+		if issuer := q.Get("issuer"); len(issuer) > 0 {
+			k.Issuer = issuer
+		}
+		if algorithm := q.Get("algorithm"); len(algorithm) > 0 {
+			k.Algorithm = strings.ToUpper(algorithm)
+		}
+		if digits := q.Get("digits"); len(digits) > 0 {
+			if k.Digits, err = strconv.Atoi(digits); err != nil {
+				return nil, errors.New(ErrInvalidTOTP + ": digits " + digits)
+			}
+		}
+		if period := q.Get("period"); len(period) > 0 {
+			if k.Period, err = strconv.Atoi(period); err != nil {
+				return nil, errors.New(ErrInvalidTOTP + ": period " + period)
+			}
+		}
+		if strings.EqualFold(q.Get("encoder"), "steam") {
+			k.Steam = true
+			k.Digits = steamTOTPDigits
+		}
+	case strings.HasPrefix(strings.ToLower(totp), steamTOTPPrefix):
+		k.Secret = totp[len(steamTOTPPrefix):]
+		k.Steam = true
+		k.Digits = steamTOTPDigits
+	default:
+		k.Secret = totp
+	}
+
+	k.Secret = strings.ToUpper(strings.TrimRight(strings.Join(strings.Fields(k.Secret), ""), "="))
+	if len(k.Secret) == 0 {
+		return nil, errors.New(ErrInvalidTOTP)
+	}
+	if _, err := k.SecretBytes(); err != nil {
+		return nil, errors.New(ErrInvalidTOTP + ": " + err.Error())
+	}
+	switch k.Algorithm {
+	case TOTPAlgorithmSHA1, TOTPAlgorithmSHA256, TOTPAlgorithmSHA512:
+	default:
+		return nil, errors.New(ErrInvalidTOTP + ": algorithm " + k.Algorithm)
+	}
+	if k.Digits < 1 || k.Digits > 10 || k.Period < 1 {
+		return nil, errors.New(ErrInvalidTOTP)
+	}
+	return k, nil
+}
+
+// ParseItemTOTP parses the TOTP of a login item, naming the issuer and
+// account after the item and its username when the secret doesn't say.
+func ParseItemTOTP(item PortWardenElement) (*TOTPKey, error) {
+	if item.Login == nil || item.Login.Totp == nil || len(strings.TrimSpace(*item.Login.Totp)) == 0 {
+		return nil, nil
+	}
+	k, err := ParseTOTP(*item.Login.Totp)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Issuer) == 0 {
+		k.Issuer = item.Name
+	}
+	if len(k.Account) == 0 {
+		k.Account = stringValue(item.Login.Username)
+	}
+	if len(k.Account) == 0 {
+		k.Account = item.Name
+	}
+	return k, nil
+}
+
+func (k *TOTPKey) SecretBytes() ([]byte, error) {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(k.Secret)
+}
+
+// URI returns the key as an otpauth:// URI as understood by authenticator
+// apps. Steam keys get the `encoder=steam` parameter KeePassXC uses.
+func (k *TOTPKey) URI() string {
+	label := k.Account
+	if len(k.Issuer) > 0 {
+		label = k.Issuer + ":" + k.Account
+	}
+	q := url.Values{}
+	q.Set("secret", k.Secret)
+	if len(k.Issuer) > 0 {
+		q.Set("issuer", k.Issuer)
+	}
+	q.Set("algorithm", k.Algorithm)
+	q.Set("digits", strconv.Itoa(k.Digits))
+	q.Set("period", strconv.Itoa(k.Period))
+	if k.Steam {
+		q.Set("encoder", "steam")
+	}
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// Code returns the RFC 6238 code of k at t, or the Steam Guard code for Steam
+// keys.
+func (k *TOTPKey) Code(t time.Time) (string, error) {
+	secret, err := k.SecretBytes()
+	if err != nil {
+		return "", errors.New(ErrInvalidTOTP + ": " + err.Error())
+	}
+	var h func() hash.Hash
+	switch k.Algorithm {
+	case TOTPAlgorithmSHA256:
+		h = sha256.New
+	case TOTPAlgorithmSHA512:
+		h = sha512.New
+	default:
+		h = sha1.New
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(k.Period)))
+	mac := hmac.New(h, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	// RFC 4226 dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	if k.Steam {
+		code := make([]byte, k.Digits)
+		for i := range code {
+			code[i] = steamAlphabet[value%uint32(len(steamAlphabet))]
+			value /= uint32(len(steamAlphabet))
+		}
+		return string(code), nil
+	}
+	code := strconv.FormatUint(uint64(value)%pow10(k.Digits), 10)
+	return strings.Repeat("0", k.Digits-len(code)) + code, nil
+}
+
+// Remaining returns how long the code at t stays valid.
+func (k *TOTPKey) Remaining(t time.Time) time.Duration {
+	period := time.Duration(k.Period) * time.Second
+	return period - time.Duration(t.UnixNano()%int64(period))
+}
+
+func pow10(n int) uint64 {
+	p := uint64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
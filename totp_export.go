@@ -0,0 +1,196 @@
+package portwarden
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	TOTPFormatURI      = "uri"
+	TOTPFormatPNG      = "png"
+	TOTPFormatTerminal = "terminal"
+
+	// DefaultMigrationBatchSize is how many accounts Google Authenticator
+	// itself puts in one QR code when transferring accounts.
+	DefaultMigrationBatchSize = 10
+
+	// TOTPQRCodeSize is the width and height of the PNG QR codes in pixels
+	TOTPQRCodeSize = 512
+
+	migrationScheme = "otpauth-migration://offline?data="
+)
+
+// TOTPEntry is a login with a TOTP seed, labelled for an authenticator app.
+type TOTPEntry struct {
+	Item PortWardenElement
+	Key  *TOTPKey
+}
+
+// TOTPEntries returns the TOTP keys of all logins in b, along with the items
+// whose `Login.Totp` can't be parsed.
+func TOTPEntries(b *Backup) (entries []TOTPEntry, invalid []PortWardenElement) {
+	for _, item := range b.Items {
+		k, err := ParseItemTOTP(item)
+		if err != nil {
+			invalid = append(invalid, item)
+			continue
+		}
+		if k != nil {
+			entries = append(entries, TOTPEntry{Item: item, Key: k})
+		}
+	}
+	return entries, invalid
+}
+
+// TOTPCode is something for an authenticator app to scan: an otpauth:// URI
+// or a migration payload, and a label telling the user what it is.
+type TOTPCode struct {
+	Label   string
+	Content string
+}
+
+// TOTPCodes returns the otpauth:// URI of every entry, labelled by item name.
+func TOTPCodes(entries []TOTPEntry) []TOTPCode {
+	var codes []TOTPCode
+	for _, entry := range entries {
+		codes = append(codes, TOTPCode{Label: entry.Item.Name, Content: entry.Key.URI()})
+	}
+	return codes
+}
+
+// WriteTOTPQRCodes writes one PNG QR code per code into dir, named after its
+// label.
+func WriteTOTPQRCodes(codes []TOTPCode, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	taken := make(map[string]bool)
+	for _, code := range codes {
+		png, err := TOTPQRCode(code.Content)
+		if err != nil {
+			return err
+		}
+		name := passFileName(code.Label)
+		if len(name) == 0 {
+			name = "totp"
+		}
+		fileName := filepath.Join(dir, uniqueName(name, taken)+".png")
+		if err := ioutil.WriteFile(fileName, png, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TOTPQRCode renders content as a PNG QR code.
+func TOTPQRCode(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, TOTPQRCodeSize)
+}
+
+// TOTPQRCodeTerminal renders content as a QR code made of block characters,
+// two modules per line.
+func TOTPQRCodeTerminal(content string) (string, error) {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
+
+// MigrationCodes batches entries into the otpauth-migration:// URIs Google
+// Authenticator scans when transferring accounts, batchSize accounts each.
+// Google Authenticator only knows 30 second periods and 6 or 8 digits, so
+// other keys, including Steam's, are returned as unsupported.
+func MigrationCodes(entries []TOTPEntry, batchSize int) (codes []TOTPCode, unsupported []TOTPEntry, err error) {
+	if batchSize < 1 {
+		batchSize = DefaultMigrationBatchSize
+	}
+	var supported []TOTPEntry
+	for _, entry := range entries {
+		if entry.Key.Steam || entry.Key.Period != 30 || (entry.Key.Digits != 6 && entry.Key.Digits != 8) {
+			unsupported = append(unsupported, entry)
+			continue
+		}
+		supported = append(supported, entry)
+	}
+
+	var batchID [4]byte
+	if _, err := rand.Read(batchID[:]); err != nil {
+		return nil, nil, err
+	}
+	batches := (len(supported) + batchSize - 1) / batchSize
+	for i := 0; i < batches; i++ {
+		end := (i + 1) * batchSize
+		if end > len(supported) {
+			end = len(supported)
+		}
+		var payload protobufMessage
+		for _, entry := range supported[i*batchSize : end] {
+			otp, err := migrationOtpParameters(entry.Key)
+			if err != nil {
+				return nil, nil, err
+			}
+			payload.bytes(1, otp)
+		}
+		payload.varint(2, 1)
+		payload.varint(3, uint64(batches))
+		payload.varint(4, uint64(i))
+		payload.varint(5, uint64(binary.LittleEndian.Uint32(batchID[:])&0x7FFFFFFF))
+		codes = append(codes, TOTPCode{
+			Label:   fmt.Sprintf("Google Authenticator %d of %d", i+1, batches),
+			Content: migrationScheme + url.QueryEscape(base64.StdEncoding.EncodeToString(payload)),
+		})
+	}
+	return codes, unsupported, nil
+}
+
+// migrationOtpParameters encodes the OtpParameters message of the migration
+// payload: secret, name, issuer, algorithm, digits and type.
+func migrationOtpParameters(k *TOTPKey) ([]byte, error) {
+	secret, err := k.SecretBytes()
+	if err != nil {
+		return nil, err
+	}
+	algorithm := map[string]uint64{TOTPAlgorithmSHA1: 1, TOTPAlgorithmSHA256: 2, TOTPAlgorithmSHA512: 3}[k.Algorithm]
+	digits := uint64(1)
+	if k.Digits == 8 {
+		digits = 2
+	}
+	var otp protobufMessage
+	otp.bytes(1, secret)
+	otp.bytes(2, []byte(k.Account))
+	otp.bytes(3, []byte(k.Issuer))
+	otp.varint(4, algorithm)
+	otp.varint(5, digits)
+	otp.varint(6, 2) // TOTP
+	return otp, nil
+}
+
+// protobufMessage encodes the few protobuf wire types the migration payload
+// needs.
+type protobufMessage []byte
+
+func (m *protobufMessage) varint(field int, value uint64) {
+	m.appendVarint(uint64(field)<<3 | 0)
+	m.appendVarint(value)
+}
+
+func (m *protobufMessage) bytes(field int, value []byte) {
+	m.appendVarint(uint64(field)<<3 | 2)
+	m.appendVarint(uint64(len(value)))
+	*m = append(*m, value...)
+}
+
+func (m *protobufMessage) appendVarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	*m = append(*m, buf[:n]...)
+}
@@ -0,0 +1,80 @@
+package portwarden
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B
+func TestTOTPCode(t *testing.T) {
+	seeds := map[string]string{
+		TOTPAlgorithmSHA1:   "12345678901234567890",
+		TOTPAlgorithmSHA256: "12345678901234567890123456789012",
+		TOTPAlgorithmSHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	for _, tc := range []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, TOTPAlgorithmSHA1, "94287082"},
+		{59, TOTPAlgorithmSHA256, "46119246"},
+		{59, TOTPAlgorithmSHA512, "90693936"},
+		{1111111109, TOTPAlgorithmSHA1, "07081804"},
+		{1111111109, TOTPAlgorithmSHA256, "68084774"},
+		{1111111109, TOTPAlgorithmSHA512, "25091201"},
+		{1111111111, TOTPAlgorithmSHA1, "14050471"},
+		{1111111111, TOTPAlgorithmSHA256, "67062674"},
+		{1111111111, TOTPAlgorithmSHA512, "99943326"},
+		{1234567890, TOTPAlgorithmSHA1, "89005924"},
+		{1234567890, TOTPAlgorithmSHA256, "91819424"},
+		{1234567890, TOTPAlgorithmSHA512, "93441116"},
+		{2000000000, TOTPAlgorithmSHA1, "69279037"},
+		{2000000000, TOTPAlgorithmSHA256, "90698825"},
+		{2000000000, TOTPAlgorithmSHA512, "38618901"},
+		{20000000000, TOTPAlgorithmSHA1, "65353130"},
+		{20000000000, TOTPAlgorithmSHA256, "77737706"},
+		{20000000000, TOTPAlgorithmSHA512, "47863826"},
+	} {
+		secret := base32.StdEncoding.EncodeToString([]byte(seeds[tc.algorithm]))
+		uri := "otpauth://totp/Example:alice?secret=" + secret + "&algorithm=" + tc.algorithm + "&digits=8&period=30"
+		k, err := ParseTOTP(uri)
+		if err != nil {
+			t.Fatalf("ParseTOTP(%q): %v", uri, err)
+		}
+		got, err := k.Code(time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%v code at %v = %v, want %v", tc.algorithm, tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestParseTOTP(t *testing.T) {
+	for _, tc := range []struct {
+		totp string
+		want TOTPKey
+	}{
+		{"jbsw y3dp ehpk 3pxp", TOTPKey{Secret: "JBSWY3DPEHPK3PXP", Algorithm: TOTPAlgorithmSHA1, Digits: 6, Period: 30}},
+		{"otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME&algorithm=sha256&digits=8&period=60",
+			TOTPKey{Secret: "JBSWY3DPEHPK3PXP", Issuer: "ACME", Account: "alice@example.com", Algorithm: TOTPAlgorithmSHA256, Digits: 8, Period: 60}},
+		{"steam://JBSWY3DPEHPK3PXP", TOTPKey{Secret: "JBSWY3DPEHPK3PXP", Algorithm: TOTPAlgorithmSHA1, Digits: steamTOTPDigits, Period: 30, Steam: true}},
+	} {
+		k, err := ParseTOTP(tc.totp)
+		if err != nil {
+			t.Errorf("ParseTOTP(%q): %v", tc.totp, err)
+			continue
+		}
+		if *k != tc.want {
+			t.Errorf("ParseTOTP(%q) = %+v, want %+v", tc.totp, *k, tc.want)
+		}
+	}
+	for _, totp := range []string{"", "not base32!", "otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=MD5"} {
+		if _, err := ParseTOTP(totp); err == nil {
+			t.Errorf("ParseTOTP(%q) succeeded", totp)
+		}
+	}
+}
@@ -106,3 +106,17 @@ type PortWardenFolderElement struct {
 const (
 	Folder Object = "folder"
 )
+
+type PortWardenCollection []PortWardenCollectionElement
+
+type PortWardenCollectionElement struct {
+	Object         Object  `json:"object"`
+	ID             string  `json:"id"`
+	OrganizationID string  `json:"organizationId"`
+	Name           string  `json:"name"`
+	ExternalID     *string `json:"externalId"`
+}
+
+const (
+	Collection Object = "collection"
+)
@@ -0,0 +1,146 @@
+package portwarden
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+const (
+	ErrRestoreVerificationFailed = "restore verification failed: the restored vault differs from the backup"
+
+	maskedSecret = "<hidden>"
+)
+
+// Discrepancy is a difference between an item of the backup and its restored
+// counterpart. Secret values are masked.
+type Discrepancy struct {
+	ItemID   string `json:"itemId"`
+	ItemName string `json:"itemName"`
+	Field    string `json:"field"`
+	Backup   string `json:"backup"`
+	Restored string `json:"restored"`
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("%v (%v): %v: backup %q, restored %q", d.ItemName, d.ItemID, d.Field, d.Backup, d.Restored)
+}
+
+func BWSync(sessionKey string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("bw", "sync", "--session", sessionKey)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New(stderr.String())
+	}
+	return nil
+}
+
+// VerifyRestore compares every item of the backup with the item it was
+// restored to, as recorded in oldToNewItemID.
+func VerifyRestore(backupItems, liveItems PortWarden, oldToNewItemID, oldToNewFolderID map[string]string) []Discrepancy {
+	live := make(map[string]PortWardenElement)
+	for _, item := range liveItems {
+		live[item.ID] = item
+	}
+	discrepancies := []Discrepancy{}
+	for _, item := range backupItems {
+		restored, ok := live[oldToNewItemID[item.ID]]
+		if !ok {
+			discrepancies = append(discrepancies, Discrepancy{ItemID: item.ID, ItemName: item.Name, Field: "item", Backup: "present", Restored: "missing"})
+			continue
+		}
+		discrepancies = append(discrepancies, compareItems(item, restored, oldToNewFolderID)...)
+	}
+	return discrepancies
+}
+
+func compareItems(backup, restored PortWardenElement, oldToNewFolderID map[string]string) []Discrepancy {
+	var ds []Discrepancy
+	check := func(field, backupValue, restoredValue string, secret bool) {
+		if backupValue == restoredValue {
+			return
+		}
+		if secret {
+			backupValue, restoredValue = maskedSecret, maskedSecret
+		}
+		ds = append(ds, Discrepancy{ItemID: backup.ID, ItemName: backup.Name, Field: field, Backup: backupValue, Restored: restoredValue})
+	}
+
+	check("name", backup.Name, restored.Name, false)
+	check("type", strconv.FormatInt(backup.Type, 10), strconv.FormatInt(restored.Type, 10), false)
+	check("favorite", strconv.FormatBool(backup.Favorite), strconv.FormatBool(restored.Favorite), false)
+	check("notes", stringValue(backup.Notes), stringValue(restored.Notes), true)
+	backupFolder := ""
+	if backup.FolderID != nil {
+		backupFolder = oldToNewFolderID[*backup.FolderID]
+	}
+	check("folder", backupFolder, stringValue(restored.FolderID), false)
+
+	backupLogin, restoredLogin := backup.Login, restored.Login
+	if backupLogin == nil {
+		backupLogin = &Login{}
+	}
+	if restoredLogin == nil {
+		restoredLogin = &Login{}
+	}
+	check("login.username", stringValue(backupLogin.Username), stringValue(restoredLogin.Username), false)
+	check("login.password", stringValue(backupLogin.Password), stringValue(restoredLogin.Password), true)
+	check("login.totp", stringValue(backupLogin.Totp), stringValue(restoredLogin.Totp), true)
+	check("login.uris", strconv.Itoa(len(backupLogin.Uris)), strconv.Itoa(len(restoredLogin.Uris)), false)
+	for i := 0; i < len(backupLogin.Uris) && i < len(restoredLogin.Uris); i++ {
+		field := "login.uris[" + strconv.Itoa(i) + "]"
+		check(field+".uri", backupLogin.Uris[i].URI, restoredLogin.Uris[i].URI, false)
+		check(field+".match", fmt.Sprint(backupLogin.Uris[i].Match), fmt.Sprint(restoredLogin.Uris[i].Match), false)
+	}
+
+	check("fields", strconv.Itoa(len(backup.Fields)), strconv.Itoa(len(restored.Fields)), false)
+	for i := 0; i < len(backup.Fields) && i < len(restored.Fields); i++ {
+		field := "fields[" + strconv.Itoa(i) + "]"
+		check(field+".name", stringValue(backup.Fields[i].Name), stringValue(restored.Fields[i].Name), false)
+		check(field+".type", strconv.FormatInt(backup.Fields[i].Type, 10), strconv.FormatInt(restored.Fields[i].Type, 10), false)
+		check(field+".value", stringValue(backup.Fields[i].Value), stringValue(restored.Fields[i].Value), true)
+	}
+
+	check("card", jsonValue(backup.Card), jsonValue(restored.Card), true)
+	check("identity", jsonValue(backup.Identity), jsonValue(restored.Identity), true)
+	check("secureNote", jsonValue(backup.SecureNote), jsonValue(restored.SecureNote), false)
+
+	check("attachments", strconv.Itoa(len(backup.Attachments)), strconv.Itoa(len(restored.Attachments)), false)
+	restoredAttachments := make(map[string][]Attachment)
+	for _, a := range restored.Attachments {
+		restoredAttachments[a.FileName] = append(restoredAttachments[a.FileName], a)
+	}
+	for _, a := range backup.Attachments {
+		field := "attachments[" + a.FileName + "]"
+		candidates := restoredAttachments[a.FileName]
+		if len(candidates) == 0 {
+			check(field, "present", "missing", false)
+			continue
+		}
+		restoredAttachments[a.FileName] = candidates[1:]
+		check(field+".size", a.Size, candidates[0].Size, false)
+	}
+	return ds
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func jsonValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
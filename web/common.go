@@ -28,6 +28,14 @@ var (
 	MachineryServer                *machinery.Server
 	BITWARDENCLI_APPDATA_DIR       string
 	GlobalMutex                    sync.Mutex
+
+	// SMTP settings for emailing reports, which are off while SMTPHost is
+	// empty
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
 )
 
 func InitCommonVars() {
@@ -84,4 +92,16 @@ func InitCommonVars() {
 	}
 	BackupDefaultSleepMilliseconds = temp
 
+	// Setup SMTP
+	SMTPHost = os.Getenv("SMTP_HOST")
+	SMTPPort = os.Getenv("SMTP_PORT")
+	if len(SMTPPort) == 0 {
+		SMTPPort = "587"
+	}
+	SMTPUsername = os.Getenv("SMTP_USERNAME")
+	SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	SMTPFrom = os.Getenv("SMTP_FROM")
+	if len(SMTPFrom) == 0 {
+		SMTPFrom = SMTPUsername
+	}
 }
@@ -20,6 +20,8 @@ const (
 	ErrLoginWithBitwarden     = "error logging in with Bitwarden"
 	ErrSettingupBackup        = "error setting up backup"
 	ErrBackupNotCancelled     = "error cancelling back up"
+	ErrNotTheUsersToken       = "the access token belongs to another user"
+	ErrPruningBackups         = "error listing the backups to prune"
 
 	MsgSuccessfullyCancelledBackingUp = "successfully cancelled backup process"
 
@@ -86,6 +88,39 @@ func CancelEncryptBackupHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": MsgSuccessfullyCancelledBackingUp})
 }
 
+// PreviewPruneHandler lists which backups in the user's Google Drive the
+// retention policy of the request, or else the stored one, would keep and
+// trash, without trashing any.
+func PreviewPruneHandler(c *gin.Context) {
+	var pu PortwardenUser
+	var opu PortwardenUser
+	if err := c.ShouldBindJSON(&pu); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": ErrBindingFromGin})
+		return
+	}
+	// the listing is private, unlike whether a backup is set up
+	gui, err := RetrieveUserEmail(&oauth2.Token{AccessToken: c.GetString(GoogleOauth2TokenContextVariableName)})
+	if err != nil || gui.Email != pu.Email {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "", "message": ErrNotTheUsersToken})
+		return
+	}
+	opu.Email = pu.Email
+	if err := opu.Get(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": ErrGettingPortwardenUser})
+		return
+	}
+	policy := pu.BackupSetting.Retention
+	if !policy.IsSet() {
+		policy = opu.BackupSetting.Retention
+	}
+	decisions, _, err := PruneBackupFiles(opu.GoogleToken, policy, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": ErrPruningBackups})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"backups": decisions})
+}
+
 //TODO: GoogleDriveHandler() will return Json with the google login url
 // Not sure if it's supposed to call UploadFile() directly
 func (ps *PortwardenServer) GetGoogleDriveLoginURLHandler(c *gin.Context) {
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/vwxyzjn/portwarden"
+	"github.com/vwxyzjn/portwarden/web"
+)
+
+const (
+	ErrSMTPNotConfigured = "SMTP_HOST isn't set, can't send email"
+)
+
+// SendEmail sends a plain text email through the SMTP server in web.
+func SendEmail(to, subject, body string) error {
+	if len(web.SMTPHost) == 0 {
+		return errors.New(ErrSMTPNotConfigured)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", web.SMTPFrom)
+	fmt.Fprintf(&msg, "To: %v\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %v\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(body)
+
+	var auth smtp.Auth
+	if len(web.SMTPUsername) > 0 {
+		auth = smtp.PlainAuth("", web.SMTPUsername, web.SMTPPassword, web.SMTPHost)
+	}
+	return smtp.SendMail(net.JoinHostPort(web.SMTPHost, web.SMTPPort), auth, web.SMTPFrom, []string{to}, msg.Bytes())
+}
+
+// SendExpiryReport emails the expiry report of a backup to the user, unless
+// there is nothing to report.
+func (pu *PortwardenUser) SendExpiryReport(encryptedData []byte) error {
+	b, err := portwarden.ReadBackupBytes(encryptedData, pu.BackupSetting.Passphrase)
+	if err != nil {
+		return err
+	}
+	report := portwarden.AuditExpiry(b, portwarden.ExpiryOptions{Months: pu.BackupSetting.ExpiryReportMonths})
+	if report.Empty() {
+		return nil
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Portwarden found cards and identity documents in your vault that expired or expire within %v months.\n\n", pu.BackupSetting.ExpiryReportMonths)
+	if err := portwarden.WriteExpiryReport(report, &body, portwarden.AuditFormatTable); err != nil {
+		return err
+	}
+	return SendEmail(pu.Email, "Portwarden: expiring cards and documents", body.String())
+}
@@ -10,8 +10,10 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/vwxyzjn/portwarden"
 	"github.com/vwxyzjn/portwarden/web"
 
 	"golang.org/x/net/context"
@@ -94,14 +96,7 @@ func SaveToken(file string, token *oauth2.Token) {
 // UploadFile upload the fileBytes to Google Drive's portwarden folder
 // https://gist.github.com/tzmartin/f5732091783752660b671c20479f519a
 func UploadFile(fileBytes []byte, token *oauth2.Token) (*oauth2.Token, error) {
-	// Get updated access token
-	tokenSource := web.GoogleDriveAppConfig.TokenSource(oauth2.NoContext, token)
-	newToken, err := tokenSource.Token()
-	if err != nil {
-		return nil, err
-	}
-	client := web.GoogleDriveAppConfig.Client(oauth2.NoContext, newToken)
-	srv, err := drive.New(client)
+	srv, newToken, err := driveService(token)
 	if err != nil {
 		return nil, err
 	}
@@ -126,6 +121,75 @@ func UploadFile(fileBytes []byte, token *oauth2.Token) (*oauth2.Token, error) {
 	return newToken, nil
 }
 
+// driveService returns a Google Drive client with an updated access token,
+// and that token.
+func driveService(token *oauth2.Token) (*drive.Service, *oauth2.Token, error) {
+	tokenSource := web.GoogleDriveAppConfig.TokenSource(oauth2.NoContext, token)
+	newToken, err := tokenSource.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	client := web.GoogleDriveAppConfig.Client(oauth2.NoContext, newToken)
+	srv, err := drive.New(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return srv, newToken, nil
+}
+
+// ListBackupFiles returns the backups in Google Drive's portwarden folder.
+// UploadFile names them after the day, so they are told apart by ID and
+// dated by when they were uploaded.
+func ListBackupFiles(srv *drive.Service) ([]portwarden.BackupFile, error) {
+	parentId, err := GetOrCreateFolder(srv, web.PortwardenGoogleDriveBackupFolderName)
+	if err != nil {
+		return nil, err
+	}
+	var backups []portwarden.BackupFile
+	q := fmt.Sprintf("'%s' in parents and trashed=false", parentId)
+	err = srv.Files.List().Q(q).Pages(context.Background(), func(files *drive.FileList) error {
+		for _, f := range files.Items {
+			if !strings.HasSuffix(f.Title, ".portwarden") {
+				continue
+			}
+			created, err := time.Parse(time.RFC3339, f.CreatedDate)
+			if err != nil {
+				return err
+			}
+			backups = append(backups, portwarden.BackupFile{Name: f.Title, ID: f.Id, Time: created})
+		}
+		return nil
+	})
+	return backups, err
+}
+
+// PruneBackupFiles applies policy to the backups in Google Drive's
+// portwarden folder and moves those it doesn't keep to the trash, unless
+// dryRun. It returns the decisions and the updated access token.
+func PruneBackupFiles(token *oauth2.Token, policy portwarden.RetentionPolicy, dryRun bool) ([]portwarden.RetentionDecision, *oauth2.Token, error) {
+	srv, newToken, err := driveService(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	backups, err := ListBackupFiles(srv)
+	if err != nil {
+		return nil, nil, err
+	}
+	decisions, err := policy.Apply(backups)
+	if err != nil || dryRun {
+		return decisions, newToken, err
+	}
+	for _, d := range decisions {
+		if d.Keep() {
+			continue
+		}
+		if _, err := srv.Files.Trash(d.ID).Do(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return decisions, newToken, nil
+}
+
 func GetOrCreateFolder(srv *drive.Service, folderName string) (string, error) {
 	folderId := ""
 	if folderName == "" {
@@ -24,6 +24,12 @@ type BackupSetting struct {
 	Passphrase             string `json:"passphrase"`
 	BackupFrequencySeconds int    `json:"backup_frequency_seconds"`
 	WillSetupBackup        bool   `json:"will_setup_backup"`
+	// ExpiryReportMonths emails a report of cards and identity documents
+	// expiring within this many months after each backup, 0 turns it off
+	ExpiryReportMonths int `json:"expiry_report_months"`
+	// Retention prunes the backups in Google Drive after each backup, an
+	// empty policy keeps all of them
+	Retention portwarden.RetentionPolicy `json:"retention"`
 }
 
 type DecryptBackupInfo struct {
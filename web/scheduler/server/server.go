@@ -38,6 +38,7 @@ func (ps *PortwardenServer) Run() {
 	})
 	ps.Router.POST("/encrypt", EncryptBackupHandler)
 	ps.Router.POST("/encrypt/cancel", CancelEncryptBackupHandler)
+	ps.Router.POST("/prune/preview", PreviewPruneHandler)
 
 	ps.Router.Run(":" + strconv.Itoa(ps.Port))
 }
@@ -45,7 +45,7 @@ func BackupToGoogleDrive(email string) error {
 		return nil
 	}
 
-	encryptedData, err := portwarden.CreateBackupBytesUsingBitwardenLocalJSON(pu.BitwardenDataJSON, web.BITWARDENCLI_APPDATA_DIR, pu.BackupSetting.Passphrase, pu.BitwardenSessionKey, web.BackupDefaultSleepMilliseconds)
+	encryptedData, err := portwarden.CreateBackupBytesUsingBitwardenLocalJSON(pu.BitwardenDataJSON, web.BITWARDENCLI_APPDATA_DIR, pu.BackupSetting.Passphrase, pu.BitwardenSessionKey, portwarden.NewPacerFromSleepMilliseconds(web.BackupDefaultSleepMilliseconds))
 	if err != nil {
 		spew.Dump("BackupToGoogleDrive has an error", err)
 		return err
@@ -55,6 +55,26 @@ func BackupToGoogleDrive(email string) error {
 		return err
 	}
 	pu.GoogleToken = newToken
+	if pu.BackupSetting.ExpiryReportMonths > 0 {
+		// the backup is uploaded, so a failing report doesn't fail the task
+		if err := pu.SendExpiryReport(encryptedData); err != nil {
+			fmt.Printf("sending the expiry report to %v failed: %v\n", pu.Email, err)
+		}
+	}
+	if pu.BackupSetting.Retention.IsSet() {
+		// neither does failing to prune
+		decisions, newToken, err := server.PruneBackupFiles(pu.GoogleToken, pu.BackupSetting.Retention, false)
+		if err != nil {
+			fmt.Printf("pruning the backups of %v failed: %v\n", pu.Email, err)
+		} else {
+			pu.GoogleToken = newToken
+			for _, d := range decisions {
+				if !d.Keep() {
+					fmt.Printf("pruned %v of %v\n", d.Name, pu.Email)
+				}
+			}
+		}
+	}
 
 	// Check whether user cancelled backup
 	opu := server.PortwardenUser{Email: email}